@@ -0,0 +1,118 @@
+// Package acme provides an RFC 8555 ACME server that issues certificates from the CA and a client that obtains
+// certificates from ACME CAs.
+package acme
+
+import (
+	"fmt"
+	"time"
+)
+
+// Status values of ACME accounts, orders, authorizations and challenges.
+const (
+	StatusPending     = "pending"
+	StatusProcessing  = "processing"
+	StatusReady       = "ready"
+	StatusValid       = "valid"
+	StatusInvalid     = "invalid"
+	StatusDeactivated = "deactivated"
+	StatusExpired     = "expired"
+)
+
+// Challenge types.
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeDNS01     = "dns-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// ALPNProtocol is the application layer protocol negotiated for tls-alpn-01 validation (RFC 8737).
+const ALPNProtocol = "acme-tls/1"
+
+// IdentifierDNS is the only identifier type supported.
+const IdentifierDNS = "dns"
+
+// Problem types described in RFC 8555 section 6.7.
+const (
+	ProblemAccountDoesNotExist     = "urn:ietf:params:acme:error:accountDoesNotExist"
+	ProblemBadCSR                  = "urn:ietf:params:acme:error:badCSR"
+	ProblemBadNonce                = "urn:ietf:params:acme:error:badNonce"
+	ProblemBadSignatureAlgorithm   = "urn:ietf:params:acme:error:badSignatureAlgorithm"
+	ProblemConnection              = "urn:ietf:params:acme:error:connection"
+	ProblemDNS                     = "urn:ietf:params:acme:error:dns"
+	ProblemIncorrectResponse       = "urn:ietf:params:acme:error:incorrectResponse"
+	ProblemMalformed               = "urn:ietf:params:acme:error:malformed"
+	ProblemOrderNotReady           = "urn:ietf:params:acme:error:orderNotReady"
+	ProblemRejectedIdentifier      = "urn:ietf:params:acme:error:rejectedIdentifier"
+	ProblemServerInternal          = "urn:ietf:params:acme:error:serverInternal"
+	ProblemTLS                     = "urn:ietf:params:acme:error:tls"
+	ProblemUnauthorized            = "urn:ietf:params:acme:error:unauthorized"
+	ProblemUnsupportedIdentifier   = "urn:ietf:params:acme:error:unsupportedIdentifier"
+	problemContentType             = "application/problem+json"
+	joseContentType                = "application/jose+json"
+	pemCertificateChainContentType = "application/pem-certificate-chain"
+)
+
+// Problem is an RFC 7807 problem document describing an ACME error.
+// Validators may return a Problem to control the error recorded against a failed challenge and the Client returns the
+// problems reported by servers as errors.
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("acme: %s: %s", p.Type, p.Detail)
+}
+
+// Directory lists the URLs of the server's resources.
+type Directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+// Identifier is the subject of an order or authorization.
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Account is an ACME account resource.
+type Account struct {
+	Status               string   `json:"status"`
+	Contact              []string `json:"contact,omitempty"`
+	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed,omitempty"`
+	Orders               string   `json:"orders"`
+	OnlyReturnExisting   bool     `json:"onlyReturnExisting,omitempty"`
+}
+
+// Order is an ACME order resource.
+type Order struct {
+	Status         string       `json:"status"`
+	Expires        time.Time    `json:"expires"`
+	Identifiers    []Identifier `json:"identifiers"`
+	Error          *Problem     `json:"error,omitempty"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+}
+
+// Authorization is an ACME authorization resource.
+type Authorization struct {
+	Identifier Identifier  `json:"identifier"`
+	Status     string      `json:"status"`
+	Expires    time.Time   `json:"expires"`
+	Challenges []Challenge `json:"challenges"`
+	Wildcard   bool        `json:"wildcard,omitempty"`
+}
+
+// Challenge is an ACME challenge resource.
+type Challenge struct {
+	Type      string     `json:"type"`
+	URL       string     `json:"url"`
+	Status    string     `json:"status"`
+	Token     string     `json:"token"`
+	Validated *time.Time `json:"validated,omitempty"`
+	Error     *Problem   `json:"error,omitempty"`
+}
@@ -0,0 +1,406 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcmturner/pki/certificate"
+)
+
+// DefaultPollInterval is the default interval between checks of authorizations and orders being processed.
+const DefaultPollInterval = time.Second
+
+// maxResponseSize limits the size of responses read from the ACME server.
+const maxResponseSize = 1 << 20
+
+// Client obtains certificates from an ACME CA.
+type Client struct {
+	// DirectoryURL is the URL of the ACME server's directory.
+	DirectoryURL string
+	// Key is the account key.
+	Key crypto.Signer
+	// HTTPClient used to send requests to the server. If nil http.DefaultClient is used.
+	HTTPClient *http.Client
+	// Solvers keyed by the challenge type they solve.
+	Solvers map[string]Solver
+	// PollInterval between checks of authorizations and orders. Zero uses DefaultPollInterval.
+	PollInterval time.Duration
+
+	mux   sync.Mutex
+	dir   *Directory
+	kid   string
+	nonce string
+}
+
+// NewClient returns a Client for the ACME server with the directory URL provided using the account key.
+// Solvers for the challenge types to be solved must be added before obtaining certificates.
+func NewClient(directoryURL string, key crypto.Signer, cl *http.Client) *Client {
+	return &Client{
+		DirectoryURL: directoryURL,
+		Key:          key,
+		HTTPClient:   cl,
+		Solvers:      make(map[string]Solver),
+	}
+}
+
+// Register creates the account for the client's key, or finds the existing account, agreeing to the server's terms
+// of service.
+func (c *Client) Register(ctx context.Context, contact []string) (Account, error) {
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+	var a Account
+	resp, err := c.post(ctx, dir.NewAccount, Account{Contact: contact, TermsOfServiceAgreed: true}, &a)
+	if err != nil {
+		return Account{}, fmt.Errorf("could not register account: %w", err)
+	}
+	c.mux.Lock()
+	c.kid = resp.Header.Get("Location")
+	c.mux.Unlock()
+	return a, nil
+}
+
+// Obtain orders a certificate for the DNS names of the CSR, solving the challenges of each authorization with the
+// client's solvers, and returns the certificate chain issued leaf first. The account must have been registered.
+func (c *Client) Obtain(ctx context.Context, csr *x509.CertificateRequest) ([]*x509.Certificate, error) {
+	names, err := csrNames(csr)
+	if err != nil {
+		return nil, err
+	}
+	o, orderURL, err := c.NewOrder(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range o.Authorizations {
+		if err := c.Authorize(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+	o, err = c.Finalize(ctx, orderURL, csr)
+	if err != nil {
+		return nil, err
+	}
+	return c.FetchCertificate(ctx, o.Certificate)
+}
+
+// csrNames returns the DNS names of the CSR including the subject common name.
+func csrNames(csr *x509.CertificateRequest) ([]string, error) {
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return nil, errors.New("CSR may only contain DNS names")
+	}
+	names := append([]string{}, csr.DNSNames...)
+	if cn := csr.Subject.CommonName; cn != "" {
+		var found bool
+		for _, n := range names {
+			found = found || strings.EqualFold(n, cn)
+		}
+		if !found {
+			names = append(names, cn)
+		}
+	}
+	if len(names) < 1 {
+		return nil, errors.New("CSR has no DNS names")
+	}
+	return names, nil
+}
+
+// NewOrder places an order for the DNS names and returns it with its URL.
+func (c *Client) NewOrder(ctx context.Context, names []string) (Order, string, error) {
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return Order{}, "", err
+	}
+	var in struct {
+		Identifiers []Identifier `json:"identifiers"`
+	}
+	for _, n := range names {
+		in.Identifiers = append(in.Identifiers, Identifier{Type: IdentifierDNS, Value: n})
+	}
+	var o Order
+	resp, err := c.post(ctx, dir.NewOrder, in, &o)
+	if err != nil {
+		return Order{}, "", fmt.Errorf("could not place order: %w", err)
+	}
+	return o, resp.Header.Get("Location"), nil
+}
+
+// Authorize solves a challenge of the authorization, using the first challenge offered for which the client has a
+// solver, and waits for the server to validate it.
+func (c *Client) Authorize(ctx context.Context, url string) error {
+	var a Authorization
+	if _, err := c.post(ctx, url, nil, &a); err != nil {
+		return fmt.Errorf("could not get authorization: %w", err)
+	}
+	if a.Status == StatusValid {
+		return nil
+	}
+	var (
+		ch     Challenge
+		solver Solver
+	)
+	for _, ch = range a.Challenges {
+		if solver = c.Solvers[ch.Type]; solver != nil {
+			break
+		}
+	}
+	if solver == nil {
+		return fmt.Errorf("no solver for the challenges offered for %s", a.Identifier.Value)
+	}
+	keyAuth, err := KeyAuthorization(ch.Token, c.Key.Public())
+	if err != nil {
+		return err
+	}
+	if err := solver.Present(ctx, a.Identifier, ch.Token, keyAuth); err != nil {
+		return fmt.Errorf("could not present %s challenge for %s: %v", ch.Type, a.Identifier.Value, err)
+	}
+	defer solver.CleanUp(ctx, a.Identifier, ch.Token, keyAuth)
+	if _, err := c.post(ctx, ch.URL, struct{}{}, nil); err != nil {
+		return fmt.Errorf("could not respond to %s challenge for %s: %w", ch.Type, a.Identifier.Value, err)
+	}
+	err = c.poll(ctx, func() (bool, error) {
+		if _, err := c.post(ctx, url, nil, &a); err != nil {
+			return false, err
+		}
+		return a.Status != StatusPending, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not get authorization: %w", err)
+	}
+	if a.Status != StatusValid {
+		for _, ch := range a.Challenges {
+			if ch.Error != nil {
+				return fmt.Errorf("%s challenge for %s failed: %w", ch.Type, a.Identifier.Value, ch.Error)
+			}
+		}
+		return fmt.Errorf("authorization for %s is %s", a.Identifier.Value, a.Status)
+	}
+	return nil
+}
+
+// Finalize submits the CSR for the order once it is ready and waits for the certificate to be issued.
+func (c *Client) Finalize(ctx context.Context, orderURL string, csr *x509.CertificateRequest) (Order, error) {
+	var o Order
+	wait := func(pending ...string) error {
+		return c.poll(ctx, func() (bool, error) {
+			if _, err := c.post(ctx, orderURL, nil, &o); err != nil {
+				return false, err
+			}
+			for _, s := range pending {
+				if o.Status == s {
+					return false, nil
+				}
+			}
+			return true, nil
+		})
+	}
+	if err := wait(StatusPending); err != nil {
+		return o, fmt.Errorf("could not get order: %w", err)
+	}
+	if o.Status != StatusReady {
+		return o, orderError(o)
+	}
+	if _, err := c.post(ctx, o.Finalize, struct {
+		CSR string `json:"csr"`
+	}{b64(csr.Raw)}, &o); err != nil {
+		return o, fmt.Errorf("could not finalize order: %w", err)
+	}
+	if err := wait(StatusReady, StatusProcessing); err != nil {
+		return o, fmt.Errorf("could not get order: %w", err)
+	}
+	if o.Status != StatusValid {
+		return o, orderError(o)
+	}
+	return o, nil
+}
+
+func orderError(o Order) error {
+	if o.Error != nil {
+		return fmt.Errorf("order is %s: %w", o.Status, o.Error)
+	}
+	return fmt.Errorf("order is %s", o.Status)
+}
+
+// FetchCertificate downloads the PEM certificate chain from the URL and returns it leaf first.
+func (c *Client) FetchCertificate(ctx context.Context, url string) ([]*x509.Certificate, error) {
+	_, b, err := c.do(ctx, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch certificate: %w", err)
+	}
+	bundle, err := certificate.Parse(b, "")
+	if err != nil {
+		return nil, fmt.Errorf("could not parse certificate chain: %v", err)
+	}
+	return bundle.Chain, nil
+}
+
+// poll calls f until it reports done, returns an error or the context is done.
+func (c *Client) poll(ctx context.Context, f func() (bool, error)) error {
+	interval := c.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	for {
+		done, err := f()
+		if err != nil || done {
+			return err
+		}
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) directory(ctx context.Context) (Directory, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.dir != nil {
+		return *c.dir, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, c.DirectoryURL, nil)
+	if err != nil {
+		return Directory{}, err
+	}
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return Directory{}, fmt.Errorf("could not get directory: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Directory{}, fmt.Errorf("could not get directory: server returned status %d", resp.StatusCode)
+	}
+	var dir Directory
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&dir); err != nil {
+		return Directory{}, fmt.Errorf("could not decode directory: %v", err)
+	}
+	c.dir = &dir
+	return dir, nil
+}
+
+// getNonce returns the nonce from the last response or, if there is none, fetches a new one.
+func (c *Client) getNonce(ctx context.Context) (string, error) {
+	c.mux.Lock()
+	n := c.nonce
+	c.nonce = ""
+	c.mux.Unlock()
+	if n != "" {
+		return n, nil
+	}
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodHead, dir.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("could not get nonce: %v", err)
+	}
+	resp.Body.Close()
+	n = resp.Header.Get("Replay-Nonce")
+	if n == "" {
+		return "", errors.New("server did not return a nonce")
+	}
+	return n, nil
+}
+
+// post sends the payload and decodes the response into v if not nil.
+func (c *Client) post(ctx context.Context, url string, payload, v interface{}) (*http.Response, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		// POST-as-GET
+		b = nil
+	}
+	resp, rb, err := c.do(ctx, url, b)
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		if err := json.Unmarshal(rb, v); err != nil {
+			return nil, fmt.Errorf("could not decode response: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+// do sends the JWS signed payload to the URL returning the response and its body. A problem reported by the server is
+// returned as a *Problem error. A request rejected for a bad nonce is retried once.
+func (c *Client) do(ctx context.Context, url string, payload []byte) (*http.Response, []byte, error) {
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.mux.Lock()
+	kid := c.kid
+	c.mux.Unlock()
+	if kid == "" && url != dir.NewAccount {
+		return nil, nil, errors.New("account not registered")
+	}
+	for retry := true; ; retry = false {
+		nonce, err := c.getNonce(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := signJWS(c.Key, kid, nonce, url, payload)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not sign request: %v", err)
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Content-Type", joseContentType)
+		resp, err := c.httpClient().Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, nil, err
+		}
+		rb, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if n := resp.Header.Get("Replay-Nonce"); n != "" {
+			c.mux.Lock()
+			c.nonce = n
+			c.mux.Unlock()
+		}
+		if resp.StatusCode < 400 {
+			return resp, rb, nil
+		}
+		p := &Problem{Status: resp.StatusCode}
+		if err := json.Unmarshal(rb, p); err != nil || p.Type == "" {
+			p.Type = ProblemServerInternal
+			p.Detail = fmt.Sprintf("server returned status %d", resp.StatusCode)
+		}
+		if p.Type != ProblemBadNonce || !retry {
+			return nil, nil, p
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package acme
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/certificate"
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/internal/testpki"
+	"github.com/stretchr/testify/assert"
+)
+
+// redirect returns a dial function that connects to the address provided whatever address is requested.
+func redirect(addr string) func(ctx context.Context, network, _ string) (net.Conn, error) {
+	var d net.Dialer
+	return func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+// alpnServer runs a TLS server that presents the solver's validation certificates.
+func alpnServer(t *testing.T, s *TLSALPN01Solver) string {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: s.GetCertificate, NextProtos: []string{ALPNProtocol}})
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func newTestClientServer(t *testing.T) (*Server, *Client) {
+	s, hs := newTestServer(t)
+	key, err := csr.GenerateKey(csr.ECDSAP256, rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating account key: %v", err)
+	}
+	c := NewClient(hs.URL+pathDirectory, key, nil)
+	c.PollInterval = time.Millisecond * 20
+	if _, err := c.Register(context.Background(), []string{"mailto:admin@test.local"}); err != nil {
+		t.Fatalf("error registering account: %v", err)
+	}
+	return s, c
+}
+
+func TestClient_Obtain(t *testing.T) {
+	s, c := newTestClientServer(t)
+	hs := &HTTP01Solver{}
+	challenges := httptest.NewServer(hs)
+	defer challenges.Close()
+	ts := &TLSALPN01Solver{}
+	s.Validators = map[string]Validator{
+		ChallengeDNS01:     ValidatorFunc(func(context.Context, Identifier, string, string) error { return errors.New("no DNS") }),
+		ChallengeHTTP01:    HTTP01Validator{Client: &http.Client{Transport: &http.Transport{DialContext: redirect(challenges.Listener.Addr().String())}}},
+		ChallengeTLSALPN01: TLSALPN01Validator{DialContext: redirect(alpnServer(t, ts))},
+	}
+
+	var tests = []struct {
+		name   string
+		solver Solver
+	}{
+		{ChallengeHTTP01, hs},
+		{ChallengeTLSALPN01, ts},
+	}
+	for _, test := range tests {
+		c.Solvers = map[string]Solver{test.name: test.solver}
+		r, _ := testpki.CSR(t, "www.test.local", csr.RSA2048, "api.test.local")
+		chain, err := c.Obtain(context.Background(), r)
+		if err != nil {
+			t.Errorf("%s: error obtaining certificate: %v", test.name, err)
+			continue
+		}
+		if !assert.Len(t, chain, 2, "%s: chain not as expected", test.name) {
+			continue
+		}
+		assert.ElementsMatch(t, []string{"www.test.local", "api.test.local"}, chain[0].DNSNames, "%s: DNS names not as expected", test.name)
+		assert.Equal(t, r.PublicKey, chain[0].PublicKey, "%s: public key not that of the CSR", test.name)
+		assert.True(t, chain[1].Equal(s.CAcrt), "%s: chain should end with the CA", test.name)
+		crt, err := certificate.LoadCert(certificate.PEMEncode(chain[0]))
+		if err != nil {
+			t.Fatalf("error loading PEM encoded certificate: %v", err)
+		}
+		assert.True(t, crt.Equal(chain[0]), "%s: PEM round trip not as expected", test.name)
+	}
+}
+
+func TestClient_ObtainFailure(t *testing.T) {
+	s, c := newTestClientServer(t)
+	s.Validators = map[string]Validator{
+		ChallengeHTTP01: ValidatorFunc(func(context.Context, Identifier, string, string) error {
+			return &Problem{Type: ProblemConnection, Detail: "stand-in unreachable"}
+		}),
+	}
+	r, _ := testpki.CSR(t, "www.test.local", csr.RSA2048)
+
+	_, err := c.Obtain(context.Background(), r)
+	assert.Error(t, err, "obtaining without a solver should error")
+
+	c.Solvers[ChallengeHTTP01] = &HTTP01Solver{}
+	_, err = c.Obtain(context.Background(), r)
+	var p *Problem
+	if assert.True(t, errors.As(err, &p), "error should be a problem: %v", err) {
+		assert.Equal(t, ProblemConnection, p.Type, "problem not as expected")
+	}
+
+	// Orders with identifiers the server does not accept are reported as problems.
+	_, _, err = c.NewOrder(context.Background(), []string{"bad name.test.local"})
+	if assert.True(t, errors.As(err, &p), "error should be a problem: %v", err) {
+		assert.Equal(t, ProblemRejectedIdentifier, p.Type, "problem not as expected")
+	}
+
+	unregistered := NewClient(c.DirectoryURL, c.Key, nil)
+	_, err = unregistered.Obtain(context.Background(), r)
+	assert.Error(t, err, "obtaining without registering should error")
+}
+
+func TestTLSALPN01Solver(t *testing.T) {
+	s := &TLSALPN01Solver{}
+	addr := alpnServer(t, s)
+	id := Identifier{Type: IdentifierDNS, Value: "www.test.local"}
+	if err := s.Present(context.Background(), id, "token", "token.thumbprint"); err != nil {
+		t.Fatalf("error presenting challenge: %v", err)
+	}
+	v := TLSALPN01Validator{DialContext: redirect(addr)}
+	assert.NoError(t, v.Validate(context.Background(), id, "token", "token.thumbprint"), "validation should succeed")
+	err := v.Validate(context.Background(), id, "token", "token.other")
+	var p *Problem
+	if assert.True(t, errors.As(err, &p), "error should be a problem: %v", err) {
+		assert.Equal(t, ProblemIncorrectResponse, p.Type, "problem not as expected")
+	}
+	s.CleanUp(context.Background(), id, "token", "token.thumbprint")
+	assert.Error(t, v.Validate(context.Background(), id, "token", "token.thumbprint"), "validation should fail after clean up")
+}
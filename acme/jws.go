@@ -0,0 +1,264 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// jsonWebKey is the public JSON Web Key (RFC 7517) of an account.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// jwsHeader is the protected header of an ACME request.
+type jwsHeader struct {
+	Alg   string      `json:"alg"`
+	Nonce string      `json:"nonce"`
+	URL   string      `json:"url"`
+	JWK   *jsonWebKey `json:"jwk,omitempty"`
+	KID   string      `json:"kid,omitempty"`
+}
+
+// jws is a JSON Web Signature in the flattened JSON serialization.
+type jws struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func jwkFromPublic(pub crypto.PublicKey) (*jsonWebKey, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return &jsonWebKey{Kty: "RSA", N: b64(k.N.Bytes()), E: b64(big.NewInt(int64(k.E)).Bytes())}, nil
+	case *ecdsa.PublicKey:
+		n := (k.Curve.Params().BitSize + 7) / 8
+		return &jsonWebKey{Kty: "EC", Crv: k.Curve.Params().Name, X: b64(pad(k.X.Bytes(), n)), Y: b64(pad(k.Y.Bytes(), n))}, nil
+	case ed25519.PublicKey:
+		return &jsonWebKey{Kty: "OKP", Crv: "Ed25519", X: b64(k)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+func (k *jsonWebKey) public() (crypto.PublicKey, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := dec(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := dec(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) > 4 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var c elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			c = elliptic.P256()
+		case "P-384":
+			c = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := dec(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: c, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !c.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC point not on curve")
+		}
+		return pub, nil
+	case "OKP":
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported OKP key %s", k.Crv)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+// thumbprint returns the RFC 7638 SHA-256 thumbprint of the key.
+func (k *jsonWebKey) thumbprint() string {
+	var s string
+	switch k.Kty {
+	case "RSA":
+		s = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, k.E, k.N)
+	case "EC":
+		s = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, k.Crv, k.X, k.Y)
+	case "OKP":
+		s = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, k.Crv, k.X)
+	}
+	h := sha256.Sum256([]byte(s))
+	return b64(h[:])
+}
+
+// Thumbprint returns the RFC 7638 JWK thumbprint of the public key.
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	k, err := jwkFromPublic(pub)
+	if err != nil {
+		return "", err
+	}
+	return k.thumbprint(), nil
+}
+
+// KeyAuthorization returns the key authorization for the challenge token and account key as described in RFC 8555 section 8.1.
+func KeyAuthorization(token string, pub crypto.PublicKey) (string, error) {
+	t, err := Thumbprint(pub)
+	if err != nil {
+		return "", err
+	}
+	return token + "." + t, nil
+}
+
+// DNS01Record returns the TXT record value to provision for a DNS-01 challenge with the key authorization provided.
+func DNS01Record(keyAuth string) string {
+	h := sha256.Sum256([]byte(keyAuth))
+	return b64(h[:])
+}
+
+// jwsAlgorithm returns the JWS algorithm name and hash for the public key.
+func jwsAlgorithm(pub crypto.PublicKey) (string, crypto.Hash, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256", crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return "ES256", crypto.SHA256, nil
+		case elliptic.P384():
+			return "ES384", crypto.SHA384, nil
+		}
+		return "", 0, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "EdDSA", 0, nil
+	default:
+		return "", 0, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+func digest(h crypto.Hash, b []byte) []byte {
+	switch h {
+	case crypto.SHA256:
+		d := sha256.Sum256(b)
+		return d[:]
+	case crypto.SHA384:
+		d := sha512.Sum384(b)
+		return d[:]
+	}
+	return b
+}
+
+// signJWS signs the payload for the URL. The account key is identified by kid or, if kid is empty, embedded as a JWK.
+// A nil payload produces a POST-as-GET request.
+func signJWS(key crypto.Signer, kid, nonce, url string, payload []byte) ([]byte, error) {
+	alg, h, err := jwsAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	hdr := jwsHeader{Alg: alg, Nonce: nonce, URL: url, KID: kid}
+	if kid == "" {
+		hdr.JWK, err = jwkFromPublic(key.Public())
+		if err != nil {
+			return nil, err
+		}
+	}
+	hb, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+	j := jws{Protected: b64(hb), Payload: b64(payload)}
+	var opts crypto.SignerOpts = h
+	sig, err := key.Sign(rand.Reader, digest(h, []byte(j.Protected+"."+j.Payload)), opts)
+	if err != nil {
+		return nil, err
+	}
+	if ek, ok := key.Public().(*ecdsa.PublicKey); ok {
+		// JWS ECDSA signatures are the fixed length concatenation of r and s rather than ASN.1.
+		var es struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &es); err != nil {
+			return nil, err
+		}
+		n := (ek.Curve.Params().BitSize + 7) / 8
+		sig = append(pad(es.R.Bytes(), n), pad(es.S.Bytes(), n)...)
+	}
+	j.Signature = b64(sig)
+	return json.Marshal(j)
+}
+
+// verify checks the JWS signature with the public key and that the algorithm is appropriate for the key.
+func (j *jws) verify(alg string, pub crypto.PublicKey) error {
+	want, h, err := jwsAlgorithm(pub)
+	if err != nil {
+		return err
+	}
+	if alg != want {
+		return fmt.Errorf("algorithm %s not valid for the account key", alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(j.Signature)
+	if err != nil {
+		return errors.New("signature not valid base64url")
+	}
+	d := digest(h, []byte(j.Protected+"."+j.Payload))
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(k, h, d, sig)
+	case *ecdsa.PublicKey:
+		n := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*n || !ecdsa.Verify(k, d, new(big.Int).SetBytes(sig[:n]), new(big.Int).SetBytes(sig[n:])) {
+			err = errors.New("ECDSA verification failure")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, d, sig) {
+			err = errors.New("Ed25519 verification failure")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("signature not valid: %v", err)
+	}
+	return nil
+}
+
+// pad left pads b with zeros to n bytes.
+func pad(b []byte, n int) []byte {
+	if len(b) >= n {
+		return b
+	}
+	return append(make([]byte, n-len(b)), b...)
+}
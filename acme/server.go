@@ -0,0 +1,774 @@
+package acme
+
+import (
+	"container/list"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/certificate"
+	"github.com/jcmturner/pki/ledger"
+)
+
+const (
+	// DefaultOrderLifetime is the default duration within which an order must be finalized.
+	DefaultOrderLifetime = time.Hour * 24
+	// DefaultValidationTimeout is the default time allowed for a validator to check a challenge.
+	DefaultValidationTimeout = time.Second * 30
+
+	maxRequestSize = 65536
+	maxNonces      = 10000
+	nonceLifetime  = time.Hour
+
+	pathDirectory  = "/directory"
+	pathNewNonce   = "/new-nonce"
+	pathNewAccount = "/new-account"
+	pathNewOrder   = "/new-order"
+	pathAccount    = "/account/"
+	pathOrder      = "/order/"
+	pathAuthz      = "/authz/"
+	pathChallenge  = "/challenge/"
+	pathFinalize   = "/finalize/"
+	pathCert       = "/cert/"
+)
+
+type account struct {
+	id  string
+	key crypto.PublicKey
+	Account
+	orders []string
+}
+
+type order struct {
+	id      string
+	account string
+	authzs  []string
+	cert    []byte
+	Order
+}
+
+type nonceEntry struct {
+	nonce  string
+	issued time.Time
+}
+
+type authorization struct {
+	id      string
+	account string
+	order   string
+	Authorization
+}
+
+// Server is an ACME server that issues certificates signed by the CA using ca.Sign.
+//
+// Identifiers are validated by the Validators registered for each challenge type. Accounts, orders and
+// authorizations are held in memory.
+type Server struct {
+	CAcrt *x509.Certificate
+	CAkey crypto.Signer
+	// Duration is the validity of issued certificates.
+	Duration time.Duration
+	// BaseURL is the external URL the server is served at, for example https://acme.example.com/acme. If the server
+	// is not served at the root it should be wrapped with http.StripPrefix. If empty the URL is derived from the
+	// request assuming the server is served at the root.
+	BaseURL string
+	// Validators keyed by the challenge type they validate.
+	Validators map[string]Validator
+	// OrderLifetime is the duration within which orders must be finalized. Zero uses DefaultOrderLifetime.
+	OrderLifetime time.Duration
+	// ValidationTimeout is the time allowed for a validator. Zero uses DefaultValidationTimeout.
+	ValidationTimeout time.Duration
+	// Ledger, if set, records the certificate issued when each order is finalized. The order fails if it cannot be recorded.
+	Ledger ledger.Ledger
+	Logger *log.Logger
+
+	mux sync.Mutex
+	// nonces indexes nonceList, which holds the outstanding nonces oldest first.
+	nonces    map[string]*list.Element
+	nonceList *list.List
+	accounts  map[string]*account
+	keys      map[string]string
+	orders    map[string]*order
+	authzs    map[string]*authorization
+}
+
+// New returns a Server that signs certificates valid for the duration provided with the CA key and offers http-01,
+// dns-01 and tls-alpn-01 challenges validated against the network.
+func New(CAcrt *x509.Certificate, CAkey crypto.Signer, duration time.Duration) *Server {
+	return &Server{
+		CAcrt:    CAcrt,
+		CAkey:    CAkey,
+		Duration: duration,
+		Validators: map[string]Validator{
+			ChallengeHTTP01:    HTTP01Validator{},
+			ChallengeDNS01:     DNS01Validator{},
+			ChallengeTLSALPN01: TLSALPN01Validator{},
+		},
+		nonces:    make(map[string]*list.Element),
+		nonceList: list.New(),
+		accounts:  make(map[string]*account),
+		keys:      make(map[string]string),
+		orders:    make(map[string]*order),
+		authzs:    make(map[string]*authorization),
+	}
+}
+
+// request is an authenticated ACME request.
+type request struct {
+	payload []byte
+	// account is nil for new account requests.
+	account *account
+	jwk     *jsonWebKey
+	key     crypto.PublicKey
+}
+
+func problem(status int, typ, format string, v ...interface{}) *Problem {
+	return &Problem{Type: typ, Detail: fmt.Sprintf(format, v...), Status: status}
+}
+
+// ServeHTTP handles ACME requests. The directory is served at /directory.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	base := s.base(r)
+	p := r.URL.Path
+	if p == pathDirectory {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.write(w, http.StatusOK, Directory{
+			NewNonce:   base + pathNewNonce,
+			NewAccount: base + pathNewAccount,
+			NewOrder:   base + pathNewOrder,
+		})
+		return
+	}
+	w.Header().Set("Replay-Nonce", s.nonce())
+	w.Header().Set("Link", fmt.Sprintf("<%s>;rel=\"index\"", base+pathDirectory))
+	w.Header().Set("Cache-Control", "no-store")
+	if p == pathNewNonce {
+		switch r.Method {
+		case http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case http.MethodGet:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req, prob := s.parse(w, r, base)
+	if prob != nil {
+		s.error(w, prob)
+		return
+	}
+	switch {
+	case p == pathNewAccount:
+		s.newAccount(w, req, base)
+	case p == pathNewOrder:
+		s.newOrder(w, req, base)
+	case strings.HasPrefix(p, pathAccount):
+		s.account(w, req, strings.TrimPrefix(p, pathAccount))
+	case strings.HasPrefix(p, pathOrder):
+		s.order(w, req, strings.TrimPrefix(p, pathOrder))
+	case strings.HasPrefix(p, pathAuthz):
+		s.authorization(w, req, strings.TrimPrefix(p, pathAuthz))
+	case strings.HasPrefix(p, pathChallenge):
+		s.challenge(w, req, base, strings.TrimPrefix(p, pathChallenge))
+	case strings.HasPrefix(p, pathFinalize):
+		s.finalize(w, req, base, strings.TrimPrefix(p, pathFinalize))
+	case strings.HasPrefix(p, pathCert):
+		s.certificate(w, req, strings.TrimPrefix(p, pathCert))
+	default:
+		s.error(w, problem(http.StatusNotFound, ProblemMalformed, "resource not found"))
+	}
+}
+
+func (s *Server) base(r *http.Request) string {
+	if s.BaseURL != "" {
+		return strings.TrimSuffix(s.BaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// parse verifies the JWS of the request as described in RFC 8555 section 6.2.
+func (s *Server) parse(w http.ResponseWriter, r *http.Request, base string) (*request, *Problem) {
+	if ct := r.Header.Get("Content-Type"); ct != joseContentType {
+		return nil, problem(http.StatusUnsupportedMediaType, ProblemMalformed, "content type must be %s", joseContentType)
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		return nil, problem(http.StatusBadRequest, ProblemMalformed, "could not read request: %v", err)
+	}
+	var j jws
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, problem(http.StatusBadRequest, ProblemMalformed, "could not decode JWS: %v", err)
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(j.Protected)
+	if err != nil {
+		return nil, problem(http.StatusBadRequest, ProblemMalformed, "protected header not valid base64url")
+	}
+	var hdr jwsHeader
+	if err := json.Unmarshal(hb, &hdr); err != nil {
+		return nil, problem(http.StatusBadRequest, ProblemMalformed, "could not decode protected header: %v", err)
+	}
+	switch hdr.Alg {
+	case "RS256", "ES256", "ES384", "EdDSA":
+	default:
+		return nil, problem(http.StatusBadRequest, ProblemBadSignatureAlgorithm, "algorithm %q not supported", hdr.Alg)
+	}
+	if !s.useNonce(hdr.Nonce) {
+		return nil, problem(http.StatusBadRequest, ProblemBadNonce, "nonce not valid")
+	}
+	if hdr.URL != base+r.URL.Path {
+		return nil, problem(http.StatusUnauthorized, ProblemUnauthorized, "URL in protected header does not match the request")
+	}
+	req := new(request)
+	if r.URL.Path == pathNewAccount {
+		if hdr.JWK == nil || hdr.KID != "" {
+			return nil, problem(http.StatusBadRequest, ProblemMalformed, "new account requests must include a JWK and not a key ID")
+		}
+		req.jwk = hdr.JWK
+		req.key, err = hdr.JWK.public()
+		if err != nil {
+			return nil, problem(http.StatusBadRequest, ProblemMalformed, "JWK not valid: %v", err)
+		}
+	} else {
+		if hdr.KID == "" || hdr.JWK != nil {
+			return nil, problem(http.StatusBadRequest, ProblemMalformed, "requests must include a key ID and not a JWK")
+		}
+		s.mux.Lock()
+		a, ok := s.accounts[strings.TrimPrefix(hdr.KID, base+pathAccount)]
+		var status string
+		if ok {
+			status = a.Status
+		}
+		s.mux.Unlock()
+		if !ok || !strings.HasPrefix(hdr.KID, base+pathAccount) {
+			return nil, problem(http.StatusBadRequest, ProblemAccountDoesNotExist, "account %s does not exist", hdr.KID)
+		}
+		if status != StatusValid {
+			return nil, problem(http.StatusUnauthorized, ProblemUnauthorized, "account is %s", status)
+		}
+		req.account = a
+		req.key = a.key
+	}
+	if err := j.verify(hdr.Alg, req.key); err != nil {
+		return nil, problem(http.StatusBadRequest, ProblemMalformed, "%v", err)
+	}
+	req.payload, err = base64.RawURLEncoding.DecodeString(j.Payload)
+	if err != nil {
+		return nil, problem(http.StatusBadRequest, ProblemMalformed, "payload not valid base64url")
+	}
+	return req, nil
+}
+
+func (s *Server) newAccount(w http.ResponseWriter, req *request, base string) {
+	var in Account
+	if err := json.Unmarshal(req.payload, &in); err != nil {
+		s.error(w, problem(http.StatusBadRequest, ProblemMalformed, "could not decode account: %v", err))
+		return
+	}
+	thumb := req.jwk.thumbprint()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if id, ok := s.keys[thumb]; ok {
+		w.Header().Set("Location", base+pathAccount+id)
+		s.write(w, http.StatusOK, s.accounts[id].Account)
+		return
+	}
+	if in.OnlyReturnExisting {
+		s.error(w, problem(http.StatusBadRequest, ProblemAccountDoesNotExist, "no account exists for the key"))
+		return
+	}
+	a := &account{
+		id:  randomID(),
+		key: req.key,
+		Account: Account{
+			Status:               StatusValid,
+			Contact:              in.Contact,
+			TermsOfServiceAgreed: in.TermsOfServiceAgreed,
+		},
+	}
+	a.Orders = base + pathAccount + a.id + "/orders"
+	s.accounts[a.id] = a
+	s.keys[thumb] = a.id
+	w.Header().Set("Location", base+pathAccount+a.id)
+	s.write(w, http.StatusCreated, a.Account)
+}
+
+// account handles updates to, and POST-as-GET requests for, the account and its list of orders.
+func (s *Server) account(w http.ResponseWriter, req *request, id string) {
+	id, list := strings.TrimSuffix(id, "/orders"), strings.HasSuffix(id, "/orders")
+	if id != req.account.id {
+		s.error(w, problem(http.StatusUnauthorized, ProblemUnauthorized, "account does not match the key ID"))
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	a := req.account
+	if list {
+		s.write(w, http.StatusOK, struct {
+			Orders []string `json:"orders"`
+		}{append([]string{}, a.orders...)})
+		return
+	}
+	if len(req.payload) > 0 {
+		var in Account
+		if err := json.Unmarshal(req.payload, &in); err != nil {
+			s.error(w, problem(http.StatusBadRequest, ProblemMalformed, "could not decode account: %v", err))
+			return
+		}
+		if in.Contact != nil {
+			a.Contact = in.Contact
+		}
+		if in.Status == StatusDeactivated {
+			a.Status = StatusDeactivated
+		}
+	}
+	s.write(w, http.StatusOK, a.Account)
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, req *request, base string) {
+	var in struct {
+		Identifiers []Identifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(req.payload, &in); err != nil {
+		s.error(w, problem(http.StatusBadRequest, ProblemMalformed, "could not decode order: %v", err))
+		return
+	}
+	if len(in.Identifiers) < 1 {
+		s.error(w, problem(http.StatusBadRequest, ProblemMalformed, "order has no identifiers"))
+		return
+	}
+	lifetime := s.OrderLifetime
+	if lifetime <= 0 {
+		lifetime = DefaultOrderLifetime
+	}
+	o := &order{
+		id:      randomID(),
+		account: req.account.id,
+		Order: Order{
+			Status:  StatusPending,
+			Expires: time.Now().Add(lifetime).UTC().Truncate(time.Second),
+		},
+	}
+	o.Finalize = base + pathFinalize + o.id
+	var authzs []*authorization
+	seen := make(map[string]bool)
+	for _, id := range in.Identifiers {
+		if id.Type != IdentifierDNS {
+			s.error(w, problem(http.StatusBadRequest, ProblemUnsupportedIdentifier, "identifier type %q not supported", id.Type))
+			return
+		}
+		id.Value = strings.ToLower(strings.TrimSuffix(id.Value, "."))
+		if seen[id.Value] {
+			continue
+		}
+		seen[id.Value] = true
+		a, prob := s.newAuthorization(id, base, o)
+		if prob != nil {
+			s.error(w, prob)
+			return
+		}
+		o.Identifiers = append(o.Identifiers, id)
+		o.authzs = append(o.authzs, a.id)
+		o.Authorizations = append(o.Authorizations, base+pathAuthz+a.id)
+		authzs = append(authzs, a)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, a := range authzs {
+		s.authzs[a.id] = a
+	}
+	s.orders[o.id] = o
+	req.account.orders = append(req.account.orders, base+pathOrder+o.id)
+	w.Header().Set("Location", base+pathOrder+o.id)
+	s.write(w, http.StatusCreated, o.Order)
+}
+
+// newAuthorization creates the authorization for the identifier of the order with a challenge of each type the
+// server has a validator for. Wildcard identifiers are only offered dns-01 challenges.
+func (s *Server) newAuthorization(id Identifier, base string, o *order) (*authorization, *Problem) {
+	value := id.Value
+	wildcard := strings.HasPrefix(value, "*.")
+	if wildcard {
+		value = value[2:]
+	}
+	if value == "" || strings.ContainsAny(value, "*/:@ ") || strings.Contains(value, "..") {
+		return nil, problem(http.StatusBadRequest, ProblemRejectedIdentifier, "identifier %q not valid", id.Value)
+	}
+	a := &authorization{
+		id:      randomID(),
+		account: o.account,
+		order:   o.id,
+		Authorization: Authorization{
+			Identifier: Identifier{Type: IdentifierDNS, Value: value},
+			Status:     StatusPending,
+			Expires:    o.Expires,
+			Wildcard:   wildcard,
+		},
+	}
+	var types []string
+	for t := range s.Validators {
+		if !wildcard || t == ChallengeDNS01 {
+			types = append(types, t)
+		}
+	}
+	if len(types) < 1 {
+		return nil, problem(http.StatusBadRequest, ProblemRejectedIdentifier, "no challenge types available for %q", id.Value)
+	}
+	sort.Strings(types)
+	for i, t := range types {
+		a.Challenges = append(a.Challenges, Challenge{
+			Type:   t,
+			URL:    base + pathChallenge + a.id + "/" + strconv.Itoa(i),
+			Status: StatusPending,
+			Token:  randomID(),
+		})
+	}
+	return a, nil
+}
+
+// lookupOrder returns the order with the ID provided if it belongs to the account.
+// The caller must hold the lock.
+func (s *Server) lookupOrder(id string, a *account) (*order, *Problem) {
+	o, ok := s.orders[id]
+	if !ok || o.account != a.id {
+		return nil, problem(http.StatusNotFound, ProblemMalformed, "order not found")
+	}
+	s.updateOrder(o)
+	return o, nil
+}
+
+// updateOrder sets the status of the order from that of its authorizations and its expiry.
+// The caller must hold the lock.
+func (s *Server) updateOrder(o *order) {
+	if o.Status != StatusPending && o.Status != StatusReady {
+		return
+	}
+	if time.Now().After(o.Expires) {
+		o.Status = StatusInvalid
+		o.Error = problem(http.StatusForbidden, ProblemUnauthorized, "order expired")
+		return
+	}
+	ready := true
+	for _, id := range o.authzs {
+		switch a := s.authzs[id]; a.Status {
+		case StatusValid:
+		case StatusPending:
+			ready = false
+		default:
+			o.Status = StatusInvalid
+			o.Error = problem(http.StatusForbidden, ProblemUnauthorized, "authorization for %s is %s", a.Identifier.Value, a.Status)
+			return
+		}
+	}
+	if ready {
+		o.Status = StatusReady
+	}
+}
+
+func (s *Server) order(w http.ResponseWriter, req *request, id string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	o, prob := s.lookupOrder(id, req.account)
+	if prob != nil {
+		s.error(w, prob)
+		return
+	}
+	s.write(w, http.StatusOK, o.Order)
+}
+
+// lookupAuthorization returns the authorization with the ID provided if it belongs to the account.
+// The caller must hold the lock.
+func (s *Server) lookupAuthorization(id string, a *account) (*authorization, *Problem) {
+	az, ok := s.authzs[id]
+	if !ok || az.account != a.id {
+		return nil, problem(http.StatusNotFound, ProblemMalformed, "authorization not found")
+	}
+	if az.Status == StatusPending && time.Now().After(az.Expires) {
+		az.Status = StatusExpired
+	}
+	return az, nil
+}
+
+func (s *Server) authorization(w http.ResponseWriter, req *request, id string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	az, prob := s.lookupAuthorization(id, req.account)
+	if prob != nil {
+		s.error(w, prob)
+		return
+	}
+	s.write(w, http.StatusOK, az.Authorization)
+}
+
+// challenge returns the challenge and, if the payload is not empty, starts its validation.
+func (s *Server) challenge(w http.ResponseWriter, req *request, base, id string) {
+	parts := strings.SplitN(id, "/", 2)
+	if len(parts) != 2 {
+		s.error(w, problem(http.StatusNotFound, ProblemMalformed, "challenge not found"))
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	az, prob := s.lookupAuthorization(parts[0], req.account)
+	if prob != nil {
+		s.error(w, prob)
+		return
+	}
+	i, err := strconv.Atoi(parts[1])
+	if err != nil || i < 0 || i >= len(az.Challenges) {
+		s.error(w, problem(http.StatusNotFound, ProblemMalformed, "challenge not found"))
+		return
+	}
+	ch := &az.Challenges[i]
+	if len(req.payload) > 0 && ch.Status == StatusPending {
+		if az.Status != StatusPending {
+			s.error(w, problem(http.StatusForbidden, ProblemMalformed, "authorization is %s", az.Status))
+			return
+		}
+		keyAuth, err := KeyAuthorization(ch.Token, req.account.key)
+		if err != nil {
+			s.error(w, problem(http.StatusInternalServerError, ProblemServerInternal, "could not compute key authorization: %v", err))
+			return
+		}
+		ch.Status = StatusProcessing
+		go s.validate(az, i, keyAuth)
+	}
+	w.Header().Set("Link", fmt.Sprintf("<%s>;rel=\"up\"", base+pathAuthz+az.id))
+	s.write(w, http.StatusOK, *ch)
+}
+
+// validate runs the validator for the challenge and records the outcome against it, its authorization and order.
+func (s *Server) validate(az *authorization, i int, keyAuth string) {
+	s.mux.Lock()
+	ch := az.Challenges[i]
+	v := s.Validators[ch.Type]
+	s.mux.Unlock()
+
+	timeout := s.ValidationTimeout
+	if timeout <= 0 {
+		timeout = DefaultValidationTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	err := v.Validate(ctx, az.Identifier, ch.Token, keyAuth)
+	cancel()
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	c := &az.Challenges[i]
+	if err != nil {
+		var prob *Problem
+		if !errors.As(err, &prob) {
+			prob = &Problem{Type: ProblemIncorrectResponse, Detail: err.Error()}
+		}
+		if prob.Status == 0 {
+			prob.Status = http.StatusForbidden
+		}
+		s.logf("%s validation of %s failed: %v", ch.Type, az.Identifier.Value, err)
+		c.Status = StatusInvalid
+		c.Error = prob
+		az.Status = StatusInvalid
+	} else {
+		now := time.Now().UTC()
+		c.Status = StatusValid
+		c.Validated = &now
+		az.Status = StatusValid
+	}
+	s.updateOrder(s.orders[az.order])
+}
+
+func (s *Server) finalize(w http.ResponseWriter, req *request, base, id string) {
+	var in struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.payload, &in); err != nil {
+		s.error(w, problem(http.StatusBadRequest, ProblemMalformed, "could not decode finalize request: %v", err))
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(in.CSR)
+	if err != nil {
+		s.error(w, problem(http.StatusBadRequest, ProblemBadCSR, "CSR not valid base64url"))
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		s.error(w, problem(http.StatusBadRequest, ProblemBadCSR, "could not parse CSR: %v", err))
+		return
+	}
+	if err := csr.CheckSignature(); err != nil {
+		s.error(w, problem(http.StatusBadRequest, ProblemBadCSR, "CSR signature not valid: %v", err))
+		return
+	}
+
+	s.mux.Lock()
+	o, prob := s.lookupOrder(id, req.account)
+	if prob == nil && o.Status != StatusReady {
+		prob = problem(http.StatusForbidden, ProblemOrderNotReady, "order is %s", o.Status)
+	}
+	if prob == nil {
+		if err := checkNames(csr, o.Identifiers); err != nil {
+			prob = problem(http.StatusBadRequest, ProblemBadCSR, "%v", err)
+		}
+	}
+	if prob != nil {
+		s.mux.Unlock()
+		s.error(w, prob)
+		return
+	}
+	// Mark the order processing so the lock is not held while signing, which may be slow with a KMS key, and
+	// recording the certificate.
+	o.Status = StatusProcessing
+	s.mux.Unlock()
+
+	crt, err := ca.Sign(csr, s.CAcrt, s.CAkey, s.Duration, rand.Reader)
+	var lerr error
+	if err == nil && s.Ledger != nil {
+		lerr = s.Ledger.Add(crt, ca.ProfileDefault)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err != nil {
+		s.logf("error signing certificate for order %s: %v", o.id, err)
+		o.Status = StatusReady
+		s.error(w, problem(http.StatusInternalServerError, ProblemServerInternal, "could not sign certificate"))
+		return
+	}
+	if lerr != nil {
+		// The certificate is not returned as it could not be revoked through the ledger.
+		s.logf("error recording certificate %s for order %s in ledger: %v", crt.SerialNumber, o.id, lerr)
+		o.Status = StatusInvalid
+		o.Error = problem(http.StatusInternalServerError, ProblemServerInternal, "could not record certificate")
+		s.error(w, o.Error)
+		return
+	}
+	o.cert = append(certificate.PEMEncode(crt), certificate.PEMEncode(s.CAcrt)...)
+	o.Status = StatusValid
+	o.Certificate = base + pathCert + o.id
+	w.Header().Set("Location", base+pathOrder+o.id)
+	s.write(w, http.StatusOK, o.Order)
+}
+
+// checkNames checks the CSR requests exactly the DNS names of the order's identifiers.
+func checkNames(csr *x509.CertificateRequest, ids []Identifier) error {
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return errors.New("CSR may only contain DNS names")
+	}
+	want := make(map[string]bool)
+	for _, id := range ids {
+		want[id.Value] = true
+	}
+	got := make(map[string]bool)
+	for _, n := range csr.DNSNames {
+		got[strings.ToLower(n)] = true
+	}
+	if cn := strings.ToLower(csr.Subject.CommonName); cn != "" && !got[cn] {
+		got[cn] = true
+	}
+	for n := range got {
+		if !want[n] {
+			return fmt.Errorf("CSR name %s is not an identifier of the order", n)
+		}
+	}
+	for n := range want {
+		if !got[n] {
+			return fmt.Errorf("CSR does not include order identifier %s", n)
+		}
+	}
+	return nil
+}
+
+func (s *Server) certificate(w http.ResponseWriter, req *request, id string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	o, prob := s.lookupOrder(id, req.account)
+	if prob != nil || o.cert == nil {
+		s.error(w, problem(http.StatusNotFound, ProblemMalformed, "certificate not found"))
+		return
+	}
+	w.Header().Set("Content-Type", pemCertificateChainContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(o.cert)
+}
+
+// nonce returns a new nonce. Once maxNonces are outstanding the oldest is discarded.
+func (s *Server) nonce() string {
+	n := randomID()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for e := s.nonceList.Front(); e != nil; e = s.nonceList.Front() {
+		ne := e.Value.(nonceEntry)
+		if s.nonceList.Len() < maxNonces && time.Since(ne.issued) <= nonceLifetime {
+			break
+		}
+		delete(s.nonces, ne.nonce)
+		s.nonceList.Remove(e)
+	}
+	s.nonces[n] = s.nonceList.PushBack(nonceEntry{nonce: n, issued: time.Now()})
+	return n
+}
+
+// useNonce returns if the nonce was issued by the server and has not been used before.
+func (s *Server) useNonce(n string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	e, ok := s.nonces[n]
+	if !ok {
+		return false
+	}
+	delete(s.nonces, n)
+	s.nonceList.Remove(e)
+	return time.Since(e.Value.(nonceEntry).issued) <= nonceLifetime
+}
+
+func (s *Server) write(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) error(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+// randomID returns a random base64url identifier.
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return b64(b)
+}
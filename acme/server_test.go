@@ -0,0 +1,420 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/internal/testpki"
+	"github.com/jcmturner/pki/ledger"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	caCert, caKey := testpki.CA(t, "Test CA", csr.ECDSAP256)
+	s := New(caCert, caKey, time.Hour)
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+	return s, hs
+}
+
+// testClient is a minimal ACME client for driving the server.
+type testClient struct {
+	t     *testing.T
+	dir   Directory
+	key   crypto.Signer
+	kid   string
+	nonce string
+}
+
+func newTestClient(t *testing.T, url string, alg csr.KeyAlgorithm) *testClient {
+	key, err := csr.GenerateKey(alg, rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating account key: %v", err)
+	}
+	c := &testClient{t: t, key: key}
+	resp, err := http.Get(url + pathDirectory)
+	if err != nil {
+		t.Fatalf("error getting directory: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&c.dir); err != nil {
+		t.Fatalf("error decoding directory: %v", err)
+	}
+	resp, err = http.Head(c.dir.NewNonce)
+	if err != nil {
+		t.Fatalf("error getting nonce: %v", err)
+	}
+	resp.Body.Close()
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	return c
+}
+
+// post sends the payload, or a POST-as-GET request if nil, and decodes a successful response into v if not nil.
+func (c *testClient) post(url string, payload, v interface{}) *http.Response {
+	var b []byte
+	if payload != nil {
+		var err error
+		b, err = json.Marshal(payload)
+		if err != nil {
+			c.t.Fatalf("error encoding payload: %v", err)
+		}
+	}
+	body, err := signJWS(c.key, c.kid, c.nonce, url, b)
+	if err != nil {
+		c.t.Fatalf("error signing request: %v", err)
+	}
+	resp, err := http.Post(url, joseContentType, bytes.NewReader(body))
+	if err != nil {
+		c.t.Fatalf("error posting to %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	rb, _ := ioutil.ReadAll(resp.Body)
+	if v != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal(rb, v); err != nil {
+			c.t.Fatalf("error decoding response from %s: %v: %s", url, err, rb)
+		}
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(rb))
+	return resp
+}
+
+func (c *testClient) problem(resp *http.Response) *Problem {
+	var p Problem
+	json.NewDecoder(resp.Body).Decode(&p)
+	return &p
+}
+
+func (c *testClient) register() {
+	resp := c.post(c.dir.NewAccount, Account{Contact: []string{"mailto:admin@test.local"}, TermsOfServiceAgreed: true}, nil)
+	if resp.StatusCode != http.StatusCreated {
+		c.t.Fatalf("account not created: %d %v", resp.StatusCode, c.problem(resp))
+	}
+	c.kid = resp.Header.Get("Location")
+}
+
+func (c *testClient) newOrder(names ...string) (Order, string) {
+	var ids []Identifier
+	for _, n := range names {
+		ids = append(ids, Identifier{Type: IdentifierDNS, Value: n})
+	}
+	var o Order
+	resp := c.post(c.dir.NewOrder, map[string]interface{}{"identifiers": ids}, &o)
+	if resp.StatusCode != http.StatusCreated {
+		c.t.Fatalf("order not created: %d %v", resp.StatusCode, c.problem(resp))
+	}
+	return o, resp.Header.Get("Location")
+}
+
+// wait polls the authorization until it is no longer pending.
+func (c *testClient) wait(url string) Authorization {
+	var a Authorization
+	for i := 0; i < 100; i++ {
+		c.post(url, nil, &a)
+		if a.Status != StatusPending {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	return a
+}
+
+// finalize submits a CSR for the names and, if successful, updates the order.
+func (c *testClient) finalize(o *Order, cn string, names ...string) *http.Response {
+	r, _ := testpki.CSR(c.t, cn, csr.ECDSAP256, names...)
+	return c.post(o.Finalize, map[string]string{"csr": b64(r.Raw)}, o)
+}
+
+type txtRecords map[string][]string
+
+func (r txtRecords) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	txt, ok := r[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return txt, nil
+}
+
+// challengeServer serves http-01 key authorizations and returns a client that connects to it for any host.
+func challengeServer(t *testing.T) (*http.Client, *sync.Map) {
+	var keyAuths sync.Map
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ka, ok := keyAuths.Load(strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(ka.(string)))
+	}))
+	t.Cleanup(hs.Close)
+	var d net.Dialer
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, network, hs.Listener.Addr().String())
+		},
+	}}, &keyAuths
+}
+
+func TestServer_Issue(t *testing.T) {
+	s, hs := newTestServer(t)
+	cl, keyAuths := challengeServer(t)
+	dns := make(txtRecords)
+	s.Validators = map[string]Validator{
+		ChallengeHTTP01: HTTP01Validator{Client: cl},
+		ChallengeDNS01:  DNS01Validator{Resolver: dns},
+	}
+
+	for _, alg := range []csr.KeyAlgorithm{csr.ECDSAP256, csr.ECDSAP384, csr.RSA2048, csr.Ed25519} {
+		c := newTestClient(t, hs.URL, alg)
+		c.register()
+		// Registering the same key again returns the existing account.
+		kid := c.kid
+		c.kid = ""
+		resp := c.post(c.dir.NewAccount, Account{OnlyReturnExisting: true}, nil)
+		c.kid = kid
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "%v: existing account status not as expected", alg)
+		assert.Equal(t, c.kid, resp.Header.Get("Location"), "%v: existing account location not as expected", alg)
+
+		o, orderURL := c.newOrder("www.test.local", "*.test.local")
+		assert.Equal(t, StatusPending, o.Status, "%v: order status not as expected", alg)
+		if !assert.Len(t, o.Authorizations, 2, "%v: authorizations not as expected", alg) {
+			continue
+		}
+		resp = c.finalize(&o, "www.test.local", "*.test.local")
+		assert.Equal(t, ProblemOrderNotReady, c.problem(resp).Type, "%v: finalizing a pending order should error", alg)
+
+		for _, u := range o.Authorizations {
+			var a Authorization
+			c.post(u, nil, &a)
+			var ch Challenge
+			for _, ch = range a.Challenges {
+				if a.Wildcard && ch.Type == ChallengeHTTP01 {
+					t.Errorf("%v: wildcard authorization should not offer http-01", alg)
+				}
+				if ch.Type == ChallengeHTTP01 || a.Wildcard {
+					break
+				}
+			}
+			ka, err := KeyAuthorization(ch.Token, c.key.Public())
+			if err != nil {
+				t.Fatalf("error computing key authorization: %v", err)
+			}
+			if ch.Type == ChallengeDNS01 {
+				dns["_acme-challenge."+a.Identifier.Value] = []string{"other", DNS01Record(ka)}
+			} else {
+				keyAuths.Store(ch.Token, ka)
+			}
+			var got Challenge
+			c.post(ch.URL, struct{}{}, &got)
+			assert.Contains(t, []string{StatusProcessing, StatusValid}, got.Status, "%v: challenge status not as expected", alg)
+			a = c.wait(u)
+			assert.Equal(t, StatusValid, a.Status, "%v: %s authorization not valid: %+v", alg, a.Identifier.Value, a.Challenges)
+		}
+
+		c.post(orderURL, nil, &o)
+		assert.Equal(t, StatusReady, o.Status, "%v: order status not as expected", alg)
+		resp = c.finalize(&o, "www.test.local", "other.test.local")
+		assert.Equal(t, ProblemBadCSR, c.problem(resp).Type, "%v: CSR with names not in the order should error", alg)
+		resp = c.finalize(&o, "www.test.local", "*.test.local")
+		if !assert.Equal(t, http.StatusOK, resp.StatusCode, "%v: finalize status not as expected", alg) {
+			continue
+		}
+		assert.Equal(t, StatusValid, o.Status, "%v: order status not as expected", alg)
+
+		resp = c.post(o.Certificate, nil, nil)
+		assert.Equal(t, pemCertificateChainContentType, resp.Header.Get("Content-Type"), "%v: certificate content type not as expected", alg)
+		b, _ := ioutil.ReadAll(resp.Body)
+		var chain []*x509.Certificate
+		for blk, rest := pem.Decode(b); blk != nil; blk, rest = pem.Decode(rest) {
+			crt, err := x509.ParseCertificate(blk.Bytes)
+			if err != nil {
+				t.Fatalf("error parsing certificate: %v", err)
+			}
+			chain = append(chain, crt)
+		}
+		if assert.Len(t, chain, 2, "%v: chain not as expected", alg) {
+			assert.ElementsMatch(t, []string{"www.test.local", "*.test.local"}, chain[0].DNSNames, "%v: DNS names not as expected", alg)
+			assert.NoError(t, chain[0].CheckSignatureFrom(s.CAcrt), "%v: certificate not signed by the CA", alg)
+		}
+
+		var list struct{ Orders []string }
+		c.post(c.kid+"/orders", nil, &list)
+		assert.Equal(t, []string{orderURL}, list.Orders, "%v: account orders not as expected", alg)
+	}
+}
+
+func TestServer_ValidationFailure(t *testing.T) {
+	s, hs := newTestServer(t)
+	s.Validators = map[string]Validator{
+		ChallengeHTTP01: ValidatorFunc(func(ctx context.Context, id Identifier, token, keyAuth string) error {
+			return errors.New("stand-in refused")
+		}),
+	}
+	c := newTestClient(t, hs.URL, csr.ECDSAP256)
+	c.register()
+	o, orderURL := c.newOrder("www.test.local")
+	var a Authorization
+	c.post(o.Authorizations[0], nil, &a)
+	c.post(a.Challenges[0].URL, struct{}{}, nil)
+	a = c.wait(o.Authorizations[0])
+	assert.Equal(t, StatusInvalid, a.Status, "authorization status not as expected")
+	if assert.NotNil(t, a.Challenges[0].Error, "challenge error missing") {
+		assert.Equal(t, ProblemIncorrectResponse, a.Challenges[0].Error.Type, "challenge error not as expected")
+	}
+	c.post(orderURL, nil, &o)
+	assert.Equal(t, StatusInvalid, o.Status, "order status not as expected")
+
+	// Wildcards cannot be validated without dns-01.
+	resp := c.post(c.dir.NewOrder, map[string]interface{}{"identifiers": []Identifier{{IdentifierDNS, "*.test.local"}}}, nil)
+	assert.Equal(t, ProblemRejectedIdentifier, c.problem(resp).Type, "wildcard order should be rejected")
+}
+
+// failingLedger is a ledger that cannot record certificates.
+type failingLedger struct {
+	ledger.Ledger
+}
+
+func (failingLedger) Add(crt *x509.Certificate, profile string) error {
+	return errors.New("ledger unavailable")
+}
+
+func TestServer_LedgerError(t *testing.T) {
+	s, hs := newTestServer(t)
+	s.Ledger = failingLedger{}
+	s.Validators = map[string]Validator{
+		ChallengeHTTP01: ValidatorFunc(func(ctx context.Context, id Identifier, token, keyAuth string) error {
+			return nil
+		}),
+	}
+	c := newTestClient(t, hs.URL, csr.ECDSAP256)
+	c.register()
+	o, orderURL := c.newOrder("www.test.local")
+	var a Authorization
+	c.post(o.Authorizations[0], nil, &a)
+	c.post(a.Challenges[0].URL, struct{}{}, nil)
+	a = c.wait(o.Authorizations[0])
+	assert.Equal(t, StatusValid, a.Status, "authorization status not as expected")
+
+	resp := c.finalize(&o, "www.test.local", "www.test.local")
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "finalize status not as expected")
+	assert.Equal(t, ProblemServerInternal, c.problem(resp).Type, "finalize error not as expected")
+	c.post(orderURL, nil, &o)
+	assert.Equal(t, StatusInvalid, o.Status, "order status not as expected")
+	assert.Empty(t, o.Certificate, "order should not have a certificate")
+	if assert.NotNil(t, o.Error, "order error missing") {
+		assert.Equal(t, ProblemServerInternal, o.Error.Type, "order error not as expected")
+	}
+}
+
+func TestServer_Requests(t *testing.T) {
+	_, hs := newTestServer(t)
+	c := newTestClient(t, hs.URL, csr.ECDSAP256)
+	c.register()
+	other := newTestClient(t, hs.URL, csr.ECDSAP256)
+	other.register()
+	_, orderURL := c.newOrder("www.test.local")
+
+	var tests = []struct {
+		name    string
+		do      func() *http.Response
+		problem string
+	}{
+		{"reused nonce", func() *http.Response {
+			c.post(orderURL, nil, nil)
+			body, _ := signJWS(c.key, c.kid, "unknown", orderURL, nil)
+			resp, _ := http.Post(orderURL, joseContentType, bytes.NewReader(body))
+			return resp
+		}, ProblemBadNonce},
+		{"URL mismatch", func() *http.Response {
+			body, _ := signJWS(c.key, c.kid, c.nonce, hs.URL+"/elsewhere", nil)
+			resp, _ := http.Post(orderURL, joseContentType, bytes.NewReader(body))
+			return resp
+		}, ProblemUnauthorized},
+		{"wrong key", func() *http.Response {
+			k := other.key
+			other.key = c.key
+			defer func() { other.key = k }()
+			return other.post(orderURL, nil, nil)
+		}, ProblemMalformed},
+		{"other account's order", func() *http.Response {
+			return other.post(orderURL, nil, nil)
+		}, ProblemMalformed},
+		{"unknown account", func() *http.Response {
+			kid := c.kid
+			c.kid = hs.URL + pathAccount + "unknown"
+			defer func() { c.kid = kid }()
+			return c.post(orderURL, nil, nil)
+		}, ProblemAccountDoesNotExist},
+		{"new account without existing", func() *http.Response {
+			n := newTestClient(t, hs.URL, csr.ECDSAP256)
+			return n.post(n.dir.NewAccount, Account{OnlyReturnExisting: true}, nil)
+		}, ProblemAccountDoesNotExist},
+		{"unsupported identifier", func() *http.Response {
+			return c.post(c.dir.NewOrder, map[string]interface{}{"identifiers": []Identifier{{"ip", "10.0.0.1"}}}, nil)
+		}, ProblemUnsupportedIdentifier},
+	}
+	for _, test := range tests {
+		resp := test.do()
+		if resp == nil {
+			t.Errorf("%s: no response", test.name)
+			continue
+		}
+		p := c.problem(resp)
+		resp.Body.Close()
+		assert.Equal(t, test.problem, p.Type, "%s: problem not as expected: %s", test.name, p.Detail)
+		assert.Equal(t, problemContentType, resp.Header.Get("Content-Type"), "%s: content type not as expected", test.name)
+		if nonce := resp.Header.Get("Replay-Nonce"); nonce != "" {
+			c.nonce = nonce
+		}
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// Example from RFC 7638 section 3.1.
+	k := &jsonWebKey{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", k.thumbprint(), "thumbprint not as expected")
+	pub, err := k.public()
+	if err != nil {
+		t.Fatalf("error decoding JWK: %v", err)
+	}
+	tp, err := Thumbprint(pub)
+	if err != nil {
+		t.Fatalf("error computing thumbprint: %v", err)
+	}
+	assert.Equal(t, k.thumbprint(), tp, "thumbprint of the decoded key not as expected")
+	assert.Equal(t, 65537, pub.(*rsa.PublicKey).E, "exponent not as expected")
+}
+
+func TestServer_NonceLimit(t *testing.T) {
+	s := New(nil, nil, time.Hour)
+	first := s.nonce()
+	var last string
+	for i := 0; i < maxNonces; i++ {
+		last = s.nonce()
+	}
+	assert.Len(t, s.nonces, maxNonces, "outstanding nonces should be capped")
+	assert.Equal(t, maxNonces, s.nonceList.Len(), "outstanding nonce list should be capped")
+	assert.False(t, s.useNonce(first), "oldest nonce should have been discarded")
+	assert.True(t, s.useNonce(last), "newest nonce should be valid")
+	assert.False(t, s.useNonce(last), "nonce should only be valid once")
+}
@@ -0,0 +1,147 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Solver provisions the response to a challenge so the ACME server can validate it.
+// A Solver is registered with the Client for each challenge type it can solve.
+type Solver interface {
+	// Present makes the key authorization available for the challenge of the identifier.
+	Present(ctx context.Context, id Identifier, token, keyAuth string) error
+	// CleanUp removes what Present provisioned once the challenge has been validated or has failed.
+	CleanUp(ctx context.Context, id Identifier, token, keyAuth string) error
+}
+
+const http01Path = "/.well-known/acme-challenge/"
+
+// HTTP01Solver solves http-01 challenges by serving key authorizations from the well known path.
+// It must be served on port 80 of the identifier's hosts, either directly or mounted at /.well-known/acme-challenge/.
+type HTTP01Solver struct {
+	mux      sync.RWMutex
+	keyAuths map[string]string
+}
+
+// Present serves the key authorization for the token.
+func (s *HTTP01Solver) Present(ctx context.Context, id Identifier, token, keyAuth string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.keyAuths == nil {
+		s.keyAuths = make(map[string]string)
+	}
+	s.keyAuths[token] = keyAuth
+	return nil
+}
+
+// CleanUp stops serving the key authorization for the token.
+func (s *HTTP01Solver) CleanUp(ctx context.Context, id Identifier, token, keyAuth string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.keyAuths, token)
+	return nil
+}
+
+// ServeHTTP responds to requests for the key authorization of presented tokens.
+func (s *HTTP01Solver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, http01Path) {
+		http.NotFound(w, r)
+		return
+	}
+	s.mux.RLock()
+	ka, ok := s.keyAuths[strings.TrimPrefix(r.URL.Path, http01Path)]
+	s.mux.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write([]byte(ka))
+}
+
+// TLSALPN01Solver solves tls-alpn-01 challenges by presenting a validation certificate to connections negotiating the
+// acme-tls/1 protocol. Its GetCertificate method should be set on the tls.Config of the server on port 443 of the
+// identifier's hosts and ALPNProtocol added to the config's NextProtos.
+type TLSALPN01Solver struct {
+	mux   sync.RWMutex
+	certs map[string]*tls.Certificate
+}
+
+// Present creates the validation certificate for the identifier.
+func (s *TLSALPN01Solver) Present(ctx context.Context, id Identifier, token, keyAuth string) error {
+	crt, err := tlsALPN01Certificate(id.Value, keyAuth)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.certs == nil {
+		s.certs = make(map[string]*tls.Certificate)
+	}
+	s.certs[strings.ToLower(id.Value)] = crt
+	return nil
+}
+
+// CleanUp removes the validation certificate for the identifier.
+func (s *TLSALPN01Solver) CleanUp(ctx context.Context, id Identifier, token, keyAuth string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.certs, strings.ToLower(id.Value))
+	return nil
+}
+
+// GetCertificate returns the validation certificate for connections negotiating acme-tls/1 to a presented identifier.
+// For other connections it returns nil so the certificates of the tls.Config are used.
+func (s *TLSALPN01Solver) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	for _, p := range hello.SupportedProtos {
+		if p == ALPNProtocol {
+			s.mux.RLock()
+			defer s.mux.RUnlock()
+			return s.certs[strings.ToLower(hello.ServerName)], nil
+		}
+	}
+	return nil, nil
+}
+
+// tlsALPN01Certificate returns a self-signed certificate for the name carrying the digest of the key authorization in
+// the critical acmeIdentifier extension as described in RFC 8737 section 3.
+func tlsALPN01Certificate(name, keyAuth string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	d := sha256.Sum256([]byte(keyAuth))
+	ext, err := asn1.Marshal(d[:])
+	if err != nil {
+		return nil, err
+	}
+	sn, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:    sn,
+		Subject:         pkix.Name{CommonName: name},
+		NotBefore:       time.Now().Add(-time.Minute),
+		NotAfter:        time.Now().Add(time.Hour * 24),
+		DNSNames:        []string{name},
+		ExtraExtensions: []pkix.Extension{{Id: idPeAcmeIdentifier, Critical: true, Value: ext}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,166 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Validator checks that the client controls an identifier by verifying its response to a challenge.
+// A Validator is registered with the Server for each challenge type it offers. An error of type *Problem is recorded
+// against the challenge as is, other errors are recorded as incorrect responses.
+type Validator interface {
+	Validate(ctx context.Context, id Identifier, token, keyAuth string) error
+}
+
+// ValidatorFunc adapts a function to a Validator.
+type ValidatorFunc func(ctx context.Context, id Identifier, token, keyAuth string) error
+
+// Validate calls f.
+func (f ValidatorFunc) Validate(ctx context.Context, id Identifier, token, keyAuth string) error {
+	return f(ctx, id, token, keyAuth)
+}
+
+// HTTP01Validator validates http-01 challenges as described in RFC 8555 section 8.3.
+type HTTP01Validator struct {
+	// Client used to fetch the key authorization. If nil http.DefaultClient is used.
+	Client *http.Client
+	// Port to connect to. If zero the well known HTTP port 80 is used.
+	Port int
+}
+
+// Validate fetches the key authorization from the well known path on the identifier's host.
+func (v HTTP01Validator) Validate(ctx context.Context, id Identifier, token, keyAuth string) error {
+	cl := v.Client
+	if cl == nil {
+		cl = http.DefaultClient
+	}
+	port := v.Port
+	if port == 0 {
+		port = 80
+	}
+	u := "http://" + net.JoinHostPort(id.Value, strconv.Itoa(port)) + "/.well-known/acme-challenge/" + token
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return &Problem{Type: ProblemMalformed, Detail: err.Error()}
+	}
+	resp, err := cl.Do(req.WithContext(ctx))
+	if err != nil {
+		return &Problem{Type: ProblemConnection, Detail: fmt.Sprintf("could not fetch %s: %v", u, err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &Problem{Type: ProblemUnauthorized, Detail: fmt.Sprintf("%s returned status %d", u, resp.StatusCode)}
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return &Problem{Type: ProblemConnection, Detail: fmt.Sprintf("could not read %s: %v", u, err)}
+	}
+	if string(bytes.TrimRight(b, " \t\r\n")) != keyAuth {
+		return &Problem{Type: ProblemIncorrectResponse, Detail: fmt.Sprintf("key authorization from %s does not match", u)}
+	}
+	return nil
+}
+
+// TXTResolver looks up DNS TXT records. It is satisfied by *net.Resolver.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// DNS01Validator validates dns-01 challenges as described in RFC 8555 section 8.4.
+type DNS01Validator struct {
+	// Resolver used to look up the TXT records. If nil net.DefaultResolver is used.
+	Resolver TXTResolver
+}
+
+// Validate looks for the digest of the key authorization in the TXT records of the _acme-challenge name of the identifier.
+func (v DNS01Validator) Validate(ctx context.Context, id Identifier, token, keyAuth string) error {
+	var r TXTResolver = net.DefaultResolver
+	if v.Resolver != nil {
+		r = v.Resolver
+	}
+	name := "_acme-challenge." + id.Value
+	txts, err := r.LookupTXT(ctx, name)
+	if err != nil {
+		return &Problem{Type: ProblemDNS, Detail: fmt.Sprintf("could not look up TXT records for %s: %v", name, err)}
+	}
+	want := DNS01Record(keyAuth)
+	for _, txt := range txts {
+		if txt == want {
+			return nil
+		}
+	}
+	return &Problem{Type: ProblemIncorrectResponse, Detail: fmt.Sprintf("no TXT record for %s matches the key authorization", name)}
+}
+
+// idPeAcmeIdentifier is the OID of the acmeIdentifier extension of RFC 8737 section 6.1.
+var idPeAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// TLSALPN01Validator validates tls-alpn-01 challenges as described in RFC 8737 section 3.
+type TLSALPN01Validator struct {
+	// DialContext is used to connect to the identifier's host. If nil a net.Dialer is used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Port to connect to. If zero the well known HTTPS port 443 is used.
+	Port int
+}
+
+// Validate negotiates the acme-tls/1 protocol with the identifier's host and checks the certificate presented is for
+// the identifier and carries the digest of the key authorization.
+func (v TLSALPN01Validator) Validate(ctx context.Context, id Identifier, token, keyAuth string) error {
+	dial := v.DialContext
+	if dial == nil {
+		dial = new(net.Dialer).DialContext
+	}
+	port := v.Port
+	if port == 0 {
+		port = 443
+	}
+	addr := net.JoinHostPort(id.Value, strconv.Itoa(port))
+	c, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return &Problem{Type: ProblemConnection, Detail: fmt.Sprintf("could not connect to %s: %v", addr, err)}
+	}
+	defer c.Close()
+	if d, ok := ctx.Deadline(); ok {
+		c.SetDeadline(d)
+	}
+	tc := tls.Client(c, &tls.Config{
+		ServerName: id.Value,
+		NextProtos: []string{ALPNProtocol},
+		MinVersion: tls.VersionTLS12,
+		// The validation certificate is self-signed.
+		InsecureSkipVerify: true,
+	})
+	if err := tc.Handshake(); err != nil {
+		return &Problem{Type: ProblemTLS, Detail: fmt.Sprintf("TLS handshake with %s failed: %v", addr, err)}
+	}
+	cs := tc.ConnectionState()
+	if cs.NegotiatedProtocol != ALPNProtocol {
+		return &Problem{Type: ProblemTLS, Detail: fmt.Sprintf("%s did not negotiate %s", addr, ALPNProtocol)}
+	}
+	crt := cs.PeerCertificates[0]
+	if len(crt.DNSNames) != 1 || !strings.EqualFold(crt.DNSNames[0], id.Value) {
+		return &Problem{Type: ProblemIncorrectResponse, Detail: fmt.Sprintf("certificate from %s is not for %s", addr, id.Value)}
+	}
+	want := sha256.Sum256([]byte(keyAuth))
+	for _, e := range crt.Extensions {
+		if !e.Id.Equal(idPeAcmeIdentifier) {
+			continue
+		}
+		var got []byte
+		if _, err := asn1.Unmarshal(e.Value, &got); err != nil || !e.Critical || !bytes.Equal(got, want[:]) {
+			break
+		}
+		return nil
+	}
+	return &Problem{Type: ProblemIncorrectResponse, Detail: fmt.Sprintf("certificate from %s does not carry the key authorization", addr)}
+}
@@ -1,26 +1,30 @@
 package ca
 
 import (
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/x509"
-	"encoding/binary"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
 	"io"
 	"math/big"
 	"time"
 )
 
 // New generates a new Certificate Authority
-func New(csr *x509.CertificateRequest, key *rsa.PrivateKey, duration time.Duration, rnd io.Reader) (*x509.Certificate, error) {
-	pubBytes := x509.MarshalPKCS1PublicKey(csr.PublicKey.(*rsa.PublicKey))
-	ski := sha1.Sum(pubBytes)
-	snb := make([]byte, 20)
-	_, err := rand.Read(snb)
+func New(csr *x509.CertificateRequest, key crypto.Signer, duration time.Duration, rnd io.Reader) (*x509.Certificate, error) {
+	ski, err := subjectKeyID(csr.PublicKey)
+	if err != nil {
+		return &x509.Certificate{}, err
+	}
+	sn, err := serialNumber()
 	if err != nil {
 		return &x509.Certificate{}, err
 	}
-	sn := int64(binary.BigEndian.Uint64(snb))
 	clientCRTTemplate := x509.Certificate{
 		Version:            csr.Version,
 		Signature:          csr.Signature,
@@ -29,7 +33,7 @@ func New(csr *x509.CertificateRequest, key *rsa.PrivateKey, duration time.Durati
 		PublicKeyAlgorithm: csr.PublicKeyAlgorithm,
 		PublicKey:          csr.PublicKey,
 
-		SerialNumber:          big.NewInt(sn),
+		SerialNumber:          sn,
 		Issuer:                csr.Subject,
 		Subject:               csr.Subject,
 		NotBefore:             time.Now(),
@@ -37,7 +41,7 @@ func New(csr *x509.CertificateRequest, key *rsa.PrivateKey, duration time.Durati
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 		IsCA:                  true,
-		SubjectKeyId:          ski[:],
+		SubjectKeyId:          ski,
 	}
 	// create certificate from template and CA
 	crtRaw, err := x509.CreateCertificate(rnd, &clientCRTTemplate, &clientCRTTemplate, csr.PublicKey, key)
@@ -51,31 +55,66 @@ func New(csr *x509.CertificateRequest, key *rsa.PrivateKey, duration time.Durati
 	return crt, nil
 }
 
-// Sign the CSR
-func Sign(csr *x509.CertificateRequest, CAcrt *x509.Certificate, CAkey *rsa.PrivateKey, duration time.Duration, rnd io.Reader) (*x509.Certificate, error) {
-	snb := make([]byte, 20)
-	_, err := rand.Read(snb)
+// Sign the CSR using the default profile
+func Sign(csr *x509.CertificateRequest, CAcrt *x509.Certificate, CAkey crypto.Signer, duration time.Duration, rnd io.Reader) (*x509.Certificate, error) {
+	return SignWithProfile(csr, CAcrt, CAkey, DefaultProfiles()[ProfileDefault], duration, rnd)
+}
+
+// SignWithProfile signs the CSR applying the key usages, validity, subject alternative name and path length policy of the profile.
+// An error wrapping ErrNotPermitted is returned if the duration exceeds the profile's maximum validity or the CSR has a
+// subject alternative name of a type the profile does not allow or that the profile's SANFilter rejects.
+// For CA profiles the issuing certificate must be a CA permitted to sign certificates whose path length allows the
+// profile's, and the validity will not extend beyond that of the issuing CA.
+func SignWithProfile(csr *x509.CertificateRequest, CAcrt *x509.Certificate, CAkey crypto.Signer, profile Profile, duration time.Duration, rnd io.Reader) (*x509.Certificate, error) {
+	if profile.MaxValidity > 0 && duration > profile.MaxValidity {
+		return &x509.Certificate{}, fmt.Errorf("%w %s: duration %v exceeds maximum validity %v", ErrNotPermitted, profile.Name, duration, profile.MaxValidity)
+	}
+	if profile.IsCA {
+		var err error
+		duration, err = subordinateDuration(CAcrt, profile.MaxPathLen, duration)
+		if err != nil {
+			return &x509.Certificate{}, err
+		}
+	}
+	sn, err := serialNumber()
 	if err != nil {
 		return &x509.Certificate{}, err
 	}
-	sn := int64(binary.BigEndian.Uint64(snb))
+	ku := profile.KeyUsage
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); !ok {
+		// Key encipherment is only meaningful for RSA keys.
+		ku &^= x509.KeyUsageKeyEncipherment
+	}
 	clientCRTTemplate := x509.Certificate{
-		Version:            csr.Version,
-		Signature:          csr.Signature,
-		SignatureAlgorithm: csr.SignatureAlgorithm,
+		Version: csr.Version,
 
 		PublicKeyAlgorithm: csr.PublicKeyAlgorithm,
 		PublicKey:          csr.PublicKey,
 
-		SerialNumber: big.NewInt(sn),
+		SerialNumber: sn,
 		Issuer:       CAcrt.Subject,
 		Subject:      csr.Subject,
 		NotBefore:    time.Now(),
 		NotAfter:     time.Now().Add(duration),
-		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageAny, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-		DNSNames:     csr.DNSNames,
-		IsCA:         false,
+		KeyUsage:     ku,
+		ExtKeyUsage:  profile.ExtKeyUsage,
+		IsCA:         profile.IsCA,
+	}
+	if len(CAcrt.SubjectKeyId) > 0 {
+		clientCRTTemplate.AuthorityKeyId = CAcrt.SubjectKeyId
+	}
+	if err := profile.applySANs(csr, &clientCRTTemplate); err != nil {
+		return &x509.Certificate{}, err
+	}
+	if profile.IsCA {
+		ski, err := subjectKeyID(csr.PublicKey)
+		if err != nil {
+			return &x509.Certificate{}, err
+		}
+		clientCRTTemplate.SubjectKeyId = ski
+		clientCRTTemplate.BasicConstraintsValid = true
+		clientCRTTemplate.MaxPathLen = profile.MaxPathLen
+		clientCRTTemplate.MaxPathLenZero = profile.MaxPathLen == 0
 	}
 	// create certificate from template and CA
 	crtRaw, err := x509.CreateCertificate(rnd, &clientCRTTemplate, CAcrt, csr.PublicKey, CAkey)
@@ -88,3 +127,73 @@ func Sign(csr *x509.CertificateRequest, CAcrt *x509.Certificate, CAkey *rsa.Priv
 	}
 	return crt, nil
 }
+
+// SignIntermediate signs the CSR as a subordinate CA of the CA provided.
+// The certificate is constrained to the path length provided and its validity will not extend beyond that of the issuing CA,
+// which must not have expired.
+// As with New, CA certificates carry no subject alternative names so any in the CSR are ignored.
+func SignIntermediate(csr *x509.CertificateRequest, CAcrt *x509.Certificate, CAkey crypto.Signer, maxPathLen int, duration time.Duration, rnd io.Reader) (*x509.Certificate, error) {
+	if maxPathLen < 0 {
+		return &x509.Certificate{}, errors.New("intermediate CA path length must not be negative")
+	}
+	profile := DefaultProfiles()[ProfileIntermediateCA]
+	profile.MaxPathLen = maxPathLen
+	profile.MaxValidity = 0
+	r := *csr
+	r.DNSNames, r.IPAddresses, r.EmailAddresses, r.URIs = nil, nil, nil, nil
+	return SignWithProfile(&r, CAcrt, CAkey, profile, duration, rnd)
+}
+
+// subordinateDuration checks the issuing certificate may sign a subordinate CA with the path length provided, negative
+// being unconstrained, and returns the duration limited to the remaining validity of the issuing CA.
+func subordinateDuration(CAcrt *x509.Certificate, maxPathLen int, duration time.Duration) (time.Duration, error) {
+	if !CAcrt.IsCA || CAcrt.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return 0, errors.New("issuing certificate is not a CA permitted to sign certificates")
+	}
+	if CAcrt.MaxPathLen > 0 || CAcrt.MaxPathLenZero {
+		if maxPathLen < 0 {
+			return 0, fmt.Errorf("intermediate CA path length must be constrained below issuing CA path length %d", CAcrt.MaxPathLen)
+		}
+		if maxPathLen >= CAcrt.MaxPathLen {
+			return 0, fmt.Errorf("intermediate CA path length %d must be less than issuing CA path length %d", maxPathLen, CAcrt.MaxPathLen)
+		}
+	}
+	remaining := time.Until(CAcrt.NotAfter)
+	if remaining <= 0 {
+		return 0, fmt.Errorf("issuing CA expired at %v", CAcrt.NotAfter)
+	}
+	if duration > remaining {
+		duration = remaining
+	}
+	return duration, nil
+}
+
+// serialNumber returns a random positive 159 bit serial number.
+func serialNumber() (*big.Int, error) {
+	snb := make([]byte, 20)
+	_, err := rand.Read(snb)
+	if err != nil {
+		return nil, err
+	}
+	// Clear the top bit so the serial is positive and fits within 20 octets when DER encoded.
+	snb[0] &= 0x7f
+	return new(big.Int).SetBytes(snb), nil
+}
+
+// subjectKeyID returns the SHA-1 hash of the subjectPublicKey bit string as described in RFC 5280 section 4.2.1.2.
+func subjectKeyID(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return []byte{}, err
+	}
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	_, err = asn1.Unmarshal(der, &spki)
+	if err != nil {
+		return []byte{}, err
+	}
+	ski := sha1.Sum(spki.SubjectPublicKey.Bytes)
+	return ski[:], nil
+}
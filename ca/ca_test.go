@@ -0,0 +1,130 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/csr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAndSign_KeyAlgorithms(t *testing.T) {
+	var algs = []csr.KeyAlgorithm{
+		csr.RSA2048,
+		csr.ECDSAP256,
+		csr.ECDSAP384,
+		csr.Ed25519,
+	}
+	for _, caAlg := range algs {
+		caCSR, caKey, err := csr.NewWithKeyAlgorithm(pkix.Name{CommonName: "Test CA"}, []string{}, caAlg, rand.Reader)
+		if err != nil {
+			t.Fatalf("error creating CA CSR with %v key: %v", caAlg, err)
+		}
+		caCert, err := New(caCSR, caKey, time.Hour, rand.Reader)
+		if err != nil {
+			t.Fatalf("error creating CA with %v key: %v", caAlg, err)
+		}
+		assert.True(t, caCert.IsCA, "CA certificate not marked as CA for %v", caAlg)
+		assert.Len(t, caCert.SubjectKeyId, 20, "SubjectKeyId not as expected for %v", caAlg)
+		assert.NoError(t, caCert.CheckSignatureFrom(caCert), "CA certificate not self-signed for %v", caAlg)
+
+		for _, alg := range algs {
+			r, _, err := csr.NewWithKeyAlgorithm(pkix.Name{CommonName: "host.test.local"}, []string{}, alg, rand.Reader)
+			if err != nil {
+				t.Fatalf("error creating CSR with %v key: %v", alg, err)
+			}
+			crt, err := Sign(r, caCert, caKey, time.Hour, rand.Reader)
+			if err != nil {
+				t.Errorf("error signing %v CSR with %v CA: %v", alg, caAlg, err)
+				continue
+			}
+			assert.NoError(t, crt.CheckSignatureFrom(caCert), "%v certificate signature not valid from %v CA", alg, caAlg)
+			assert.Equal(t, []string{"host.test.local"}, crt.DNSNames, "DNS names not as expected")
+			assert.Equal(t, r.PublicKeyAlgorithm, crt.PublicKeyAlgorithm, "public key algorithm not as expected")
+			assert.Equal(t, alg == csr.RSA2048, crt.KeyUsage&x509.KeyUsageKeyEncipherment != 0, "key encipherment usage not as expected for %v", alg)
+			assert.Equal(t, 1, crt.SerialNumber.Sign(), "serial number not positive")
+		}
+	}
+}
+
+func TestSignIntermediate(t *testing.T) {
+	rootCSR, rootKey, err := csr.NewWithKeyAlgorithm(pkix.Name{CommonName: "Test Root CA"}, []string{}, csr.ECDSAP384, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating root CA CSR: %v", err)
+	}
+	root, err := New(rootCSR, rootKey, time.Hour*24, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating root CA: %v", err)
+	}
+	intCSR, intKey, err := csr.NewWithKeyAlgorithm(pkix.Name{CommonName: "Test Intermediate CA"}, []string{}, csr.ECDSAP256, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating intermediate CA CSR: %v", err)
+	}
+	intCA, err := SignIntermediate(intCSR, root, rootKey, 1, time.Hour*24*365, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing intermediate CA: %v", err)
+	}
+	assert.True(t, intCA.IsCA, "intermediate not marked as CA")
+	assert.Equal(t, 1, intCA.MaxPathLen, "max path length not as expected")
+	assert.Equal(t, root.SubjectKeyId, intCA.AuthorityKeyId, "authority key ID not as expected")
+	assert.NotEmpty(t, intCA.SubjectKeyId, "subject key ID not set")
+	assert.Equal(t, x509.KeyUsageCertSign|x509.KeyUsageCRLSign, intCA.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign), "key usage not as expected")
+	assert.False(t, intCA.NotAfter.After(root.NotAfter), "intermediate validity extends beyond the root")
+
+	subCSR, subKey, err := csr.NewWithKeyAlgorithm(pkix.Name{CommonName: "Test Issuing CA"}, []string{}, csr.ECDSAP256, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating issuing CA CSR: %v", err)
+	}
+	_, err = SignIntermediate(subCSR, intCA, intKey, 1, time.Hour, rand.Reader)
+	assert.Error(t, err, "path length not less than the issuing CA's should be rejected")
+	subCA, err := SignIntermediate(subCSR, intCA, intKey, 0, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing issuing CA: %v", err)
+	}
+	assert.True(t, subCA.MaxPathLenZero, "max path length zero not set")
+
+	r, _, err := csr.New(pkix.Name{CommonName: "host.test.local"}, []string{}, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CSR: %v", err)
+	}
+	leaf, err := Sign(r, subCA, subKey, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing leaf certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	ints := x509.NewCertPool()
+	ints.AddCert(intCA)
+	ints.AddCert(subCA)
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName:       "host.test.local",
+		Roots:         roots,
+		Intermediates: ints,
+	})
+	assert.NoError(t, err, "leaf certificate chain did not verify")
+
+	_, err = SignIntermediate(r, leaf, subKey, 0, time.Hour, rand.Reader)
+	assert.Error(t, err, "signing with a non CA certificate should be rejected")
+}
+
+func TestSignIntermediate_ExpiredIssuer(t *testing.T) {
+	rootCSR, rootKey, err := csr.New(pkix.Name{CommonName: "Test Root CA"}, []string{}, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating root CA CSR: %v", err)
+	}
+	root, err := New(rootCSR, rootKey, -time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating expired root CA: %v", err)
+	}
+	intCSR, _, err := csr.New(pkix.Name{CommonName: "Test Intermediate CA"}, []string{}, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating intermediate CA CSR: %v", err)
+	}
+	_, err = SignIntermediate(intCSR, root, rootKey, 0, time.Hour, rand.Reader)
+	if assert.Error(t, err, "signing with an expired issuing CA should be rejected") {
+		assert.Contains(t, err.Error(), "expired", "error should say the issuing CA has expired")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/internal/cmdutil"
+	"github.com/jcmturner/pki/ledger"
+)
+
+func main() {
+	dbp := flag.String("db", "./revocations.json", "Path to the revocation database file")
+	ledgerp := flag.String("ledger", "", "Path to the issued certificate ledger file. If provided revocations are recorded in and read from the ledger")
+	revoke := flag.String("revoke", "", "Serial number of a certificate to revoke (decimal or 0x prefixed hex)")
+	reasonn := flag.String("reason", "unspecified", "RFC 5280 revocation reason (eg keyCompromise, superseded, cessationOfOperation)")
+	cacertp := flag.String("cacert", "", "Path to the CA certificate file. If provided a CRL is issued")
+	cakeyp := flag.String("cakey", "", "Path to the CA private key file. Not required if the CA certificate file also holds the key")
+	passfile := flag.String("passfile", "", "File containing the passphrase of the CA private key. If not provided and the key is encrypted the passphrase is prompted for")
+	kmskey := flag.String("kmskey", "", "ARN of the AWS KMS key to sign with instead of a CA private key file")
+	delta := flag.Bool("delta", false, "Issue a delta CRL of the certificates revoked since the last full CRL")
+	nextUpdate := flag.Duration("nextupdate", time.Hour*24*7, "Duration until the next CRL update")
+	der := flag.Bool("der", false, "Write the CRL DER encoded rather than PEM encoded")
+	out := flag.String("out", "./ca.crl", "File to output the CRL to")
+	flag.Parse()
+
+	db, err := ca.LoadRevocationDB(*dbp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *ledgerp != "" {
+		db.Store, err = ledger.Open(*ledgerp)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *revoke != "" {
+		sn, ok := new(big.Int).SetString(*revoke, 0)
+		if !ok {
+			log.Fatalf("invalid serial number: %s", *revoke)
+		}
+		reason, err := ca.ParseRevocationReason(*reasonn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = db.Revoke(sn, reason, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("certificate with serial number %s revoked: %v", sn, reason)
+	}
+
+	if *cacertp != "" {
+		cab, err := cmdutil.LoadCA(*cacertp, *cakeyp, *passfile, *kmskey)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		issue := db.CRL
+		if *delta {
+			issue = db.DeltaCRL
+		}
+		crl, err := issue(cab.Leaf(), cab.Key, *nextUpdate, rand.Reader)
+		if err != nil {
+			log.Fatalf("could not issue CRL: %v", err)
+		}
+		err = ca.WriteCRLFile(crl, *out, *der)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("CRL number %s written to %s", crl.Number, *out)
+	}
+
+	err = db.Save(*dbp)
+	if err != nil {
+		log.Fatalf("could not save revocation database: %v", err)
+	}
+}
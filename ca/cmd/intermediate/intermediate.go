@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/certificate"
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/internal/cmdutil"
+	"github.com/jcmturner/pki/ledger"
+)
+
+func main() {
+	cacertp := flag.String("cacert", "", "Path to the issuing CA certificate file")
+	cakeyp := flag.String("cakey", "", "Path to the issuing CA private key file. Not required if the issuing CA certificate file also holds the key")
+	capassfile := flag.String("capassfile", "", "File containing the passphrase of the issuing CA private key. If not provided and the key is encrypted the passphrase is prompted for")
+	kmskey := flag.String("kmskey", "", "ARN of the AWS KMS key to sign with instead of a CA private key file")
+	csrp := flag.String("csr", "", "Path to the intermediate CA certificate signing request (CSR) file. If not provided a new key and CSR are generated from the subject flags")
+	cn := flag.String("cn", "", "Common Name for the intermediate certificate authority")
+	c := flag.String("c", "", "2 character ISO format country code (eg GB, US)")
+	o := flag.String("o", "", "Organisation name")
+	ou := flag.String("ou", "", "Organisational unit")
+	l := flag.String("l", "", "Locality or city")
+	s := flag.String("s", "", "State, county, region or province")
+	ka := flag.String("keyalg", "rsa2048", "Private key algorithm (rsa2048, rsa3072, rsa4096, p256, p384, p521, ed25519)")
+	pathlen := flag.Int("pathlen", 0, "Maximum number of intermediate CAs that may follow this one in a path")
+	passfile := flag.String("passfile", "", "File containing the passphrase to encrypt a generated intermediate CA private key with. If not provided the passphrase is prompted for")
+	nopass := flag.Bool("nopass", false, "Write a generated intermediate CA private key unencrypted")
+	kdfn := flag.String("kdf", "pbkdf2", "Key derivation function used to encrypt the intermediate CA private key (pbkdf2, scrypt)")
+	p12 := flag.Bool("p12", false, "Also write a generated intermediate CA certificate, private key and issuing CA certificate as PKCS#12 protected by the private key passphrase")
+	ledgerp := flag.String("ledger", "", "Path to the issued certificate ledger file to record the certificate in")
+	out := flag.String("out", "./", "Output path for certificate and private key")
+	d := flag.Duration("duration", time.Hour*24*365*10, "Expiration duration of the intermediate CA")
+	flag.Parse()
+
+	if *p12 && (*nopass || *csrp != "") {
+		log.Fatal("PKCS#12 output requires a generated private key and passphrase and cannot be used with -nopass or -csr")
+	}
+
+	cab, err := cmdutil.LoadCA(*cacertp, *cakeyp, *capassfile, *kmskey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cacert := cab.Leaf()
+
+	var (
+		car        *x509.CertificateRequest
+		key        crypto.Signer
+		kdf        certificate.KDF
+		passphrase string
+	)
+	if *csrp != "" {
+		b, err := ioutil.ReadFile(*csrp)
+		if err != nil {
+			log.Fatalf("could not read CSR file: %v", err)
+		}
+		car, err = csr.Load(b)
+		if err != nil {
+			log.Fatalf("could not load CSR: %v", err)
+		}
+	} else {
+		alg, err := csr.ParseKeyAlgorithm(*ka)
+		if err != nil {
+			log.Fatal(err)
+		}
+		kdf, err = certificate.ParseKDF(*kdfn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !*nopass {
+			passphrase, err = certificate.ReadPassphrase(*passfile, "Intermediate CA private key passphrase: ", true)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		subj := pkix.Name{
+			CommonName: *cn,
+		}
+		if *c != "" {
+			subj.Country = strings.Split(*c, ",")
+		}
+		if *o != "" {
+			subj.Organization = strings.Split(*o, ",")
+		}
+		if *ou != "" {
+			subj.OrganizationalUnit = strings.Split(*ou, ",")
+		}
+		if *l != "" {
+			subj.Locality = strings.Split(*l, ",")
+		}
+		if *s != "" {
+			subj.Province = strings.Split(*s, ",")
+		}
+		var san []string
+		car, key, err = csr.NewWithKeyAlgorithm(subj, san, alg, rand.Reader)
+		if err != nil {
+			log.Fatalf("error creating intermediate CA request: %v\n", err)
+		}
+	}
+
+	cert, err := ca.SignIntermediate(car, cacert, cab.Key, *pathlen, *d, rand.Reader)
+	if err != nil {
+		log.Fatalf("error creating intermediate CA certificate: %v\n", err)
+	}
+
+	if *ledgerp != "" {
+		l, err := ledger.Open(*ledgerp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = l.Add(cert, ca.ProfileIntermediateCA)
+		if err != nil {
+			log.Fatalf("could not record certificate in ledger: %v", err)
+		}
+	}
+
+	err = certificate.WriteCertFile(cert, filepath.Clean(*out)+"/IntermediateCAcert.pem")
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("intermediate CA certificate writen to %s", filepath.Clean(*out)+"/IntermediateCAcert.pem")
+
+	if key != nil {
+		if *nopass {
+			err = certificate.WritePKCS8KeyFile(key, filepath.Clean(*out)+"/IntermediateCAkey.pem")
+		} else {
+			err = certificate.WriteEncryptedKeyFile(key, passphrase, kdf, filepath.Clean(*out)+"/IntermediateCAkey.pem", rand.Reader)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("intermediate CA private key writen to %s", filepath.Clean(*out)+"/IntermediateCAkey.pem")
+	}
+
+	if *p12 {
+		err = certificate.WritePKCS12File(key, cert, []*x509.Certificate{cacert}, passphrase, certificate.PKCS12Modern, filepath.Clean(*out)+"/IntermediateCA.p12", rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("intermediate CA PKCS#12 writen to %s", filepath.Clean(*out)+"/IntermediateCA.p12")
+	}
+}
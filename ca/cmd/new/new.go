@@ -1,17 +1,22 @@
 package main
 
 import (
+	"crypto"
 	"crypto/rand"
+	"crypto/x509"
 	"crypto/x509/pkix"
 	"flag"
 	"log"
+	"net/http"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/jcmturner/pki/ca"
 	"github.com/jcmturner/pki/certificate"
 	csr "github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/kmssigner"
 )
 
 func main() {
@@ -22,9 +27,24 @@ func main() {
 	l := flag.String("l", "", "Locality or city")
 	s := flag.String("s", "", "State, county, region or province")
 	out := flag.String("out", "./", "Output path for certificate and private key")
+	kmskey := flag.String("kmskey", "", "ARN of an asymmetric AWS KMS key to use as the CA key instead of generating one")
+	ka := flag.String("keyalg", "rsa2048", "Private key algorithm (rsa2048, rsa3072, rsa4096, p256, p384, p521, ed25519)")
+	passfile := flag.String("passfile", "", "File containing the passphrase to encrypt the CA private key with. If not provided the passphrase is prompted for")
+	nopass := flag.Bool("nopass", false, "Write the CA private key unencrypted")
+	kdfn := flag.String("kdf", "pbkdf2", "Key derivation function used to encrypt the CA private key (pbkdf2, scrypt)")
+	p12 := flag.Bool("p12", false, "Also write the CA certificate and private key as PKCS#12 protected by the private key passphrase")
 	d := flag.Duration("duration", time.Hour*24*365*20, "Expiration duration of the CA")
 	flag.Parse()
 
+	alg, err := csr.ParseKeyAlgorithm(*ka)
+	if err != nil {
+		log.Fatal(err)
+	}
+	kdf, err := certificate.ParseKDF(*kdfn)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	subj := pkix.Name{
 		CommonName: *cn,
 	}
@@ -45,25 +65,69 @@ func main() {
 	}
 	var san []string
 
-	car, key, err := csr.New(subj, san, rand.Reader)
+	if *kmskey != "" {
+		a, err := arn.Parse(*kmskey)
+		if err != nil {
+			log.Fatalf("invalid KMS key ARN: %v", err)
+		}
+		key, err := kmssigner.GetSigner(http.DefaultClient, a)
+		if err != nil {
+			log.Fatal(err)
+		}
+		car, err := csr.NewWithSigner(subj, san, key, rand.Reader)
+		if err != nil {
+			log.Fatalf("error creating CA request: %v\n", err)
+		}
+		writeCert(car, key, *d, *out)
+		return
+	}
+
+	if *p12 && *nopass {
+		log.Fatal("PKCS#12 output requires a passphrase and cannot be used with -nopass")
+	}
+	var passphrase string
+	if !*nopass {
+		passphrase, err = certificate.ReadPassphrase(*passfile, "CA private key passphrase: ", true)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	car, key, err := csr.NewWithKeyAlgorithm(subj, san, alg, rand.Reader)
 	if err != nil {
 		log.Fatalf("error creating CA request: %v\n", err)
 	}
+	cert := writeCert(car, key, *d, *out)
 
-	cert, err := ca.New(car, key, *d, rand.Reader)
+	if *nopass {
+		err = certificate.WritePKCS8KeyFile(key, filepath.Clean(*out)+"/CAkey.pem")
+	} else {
+		err = certificate.WriteEncryptedKeyFile(key, passphrase, kdf, filepath.Clean(*out)+"/CAkey.pem", rand.Reader)
+	}
 	if err != nil {
-		log.Fatalf("error creating CA certificate: %v\n", err)
+		log.Fatal(err)
 	}
+	log.Printf("CA private key writen to %s", filepath.Clean(*out)+"/CAkey.pem")
 
-	err = certificate.WriteCertFile(cert, filepath.Clean(*out)+"/CAcert.pem")
+	if *p12 {
+		err = certificate.WritePKCS12File(key, cert, nil, passphrase, certificate.PKCS12Modern, filepath.Clean(*out)+"/CA.p12", rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("CA PKCS#12 writen to %s", filepath.Clean(*out)+"/CA.p12")
+	}
+}
+
+func writeCert(car *x509.CertificateRequest, key crypto.Signer, d time.Duration, out string) *x509.Certificate {
+	cert, err := ca.New(car, key, d, rand.Reader)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("error creating CA certificate: %v\n", err)
 	}
-	log.Printf("CA certificate writen to %s", filepath.Clean(*out)+"/CAcert.pem")
 
-	err = certificate.WriteKeyFile(key, filepath.Clean(*out)+"/CAkey.pem")
+	err = certificate.WriteCertFile(cert, filepath.Clean(out)+"/CAcert.pem")
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("CA private key writen to %s", filepath.Clean(*out)+"/CAkey.pem")
+	log.Printf("CA certificate writen to %s", filepath.Clean(out)+"/CAcert.pem")
+	return cert
 }
@@ -10,15 +10,50 @@ import (
 	"github.com/jcmturner/pki/ca"
 	"github.com/jcmturner/pki/certificate"
 	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/internal/cmdutil"
+	"github.com/jcmturner/pki/ledger"
 )
 
 func main() {
-	cacertp := flag.String("cacert", "", "Path to the CA certificate file")
-	cakeyp := flag.String("cakey", "", "Path to the CA private key file")
+	cacertp := flag.String("cacert", "", "Path to the CA certificate file (PEM, DER, PKCS#7 or PKCS#12)")
+	cakeyp := flag.String("cakey", "", "Path to the CA private key file (PEM, DER or PKCS#12). Not required if the CA certificate file also holds the key")
+	passfile := flag.String("passfile", "", "File containing the passphrase of the CA private key. If not provided and the key is encrypted the passphrase is prompted for")
+	kmskey := flag.String("kmskey", "", "ARN of the AWS KMS key to sign with instead of a CA private key file")
 	csrp := flag.String("csr", "", "Path to the certificate signing request (CSR) file")
-	d := flag.Duration("duration", time.Hour*24*365*2, "Expiration duration of the CA")
+	profn := flag.String("profile", ca.ProfileDefault, "Name of the signing profile to apply")
+	profp := flag.String("profiles", "", "Path to a JSON or YAML file of signing profiles")
+	ledgerp := flag.String("ledger", "", "Path to the issued certificate ledger file to record the certificate in")
+	keyp := flag.String("key", "", "Path to the private key of the CSR. Required for PKCS#12 output")
+	keypassfile := flag.String("keypassfile", "", "File containing the passphrase of the private key of the CSR. If not provided and the key is encrypted the passphrase is prompted for")
+	p12 := flag.String("p12", "", "Path to also write the certificate, its private key and the CA chain to as PKCS#12")
+	p12passfile := flag.String("p12passfile", "", "File containing the password to protect the PKCS#12 file with. If not provided the password is prompted for")
+	p12legacy := flag.Bool("p12legacy", false, "Protect the PKCS#12 file with legacy algorithms (3DES, SHA-1 MAC) for older Java and Windows consumers")
+	d := flag.Duration("duration", 0, "Expiration duration of the certificate. Defaults to 2 years or the profile's maximum validity if shorter")
 	flag.Parse()
 
+	if *p12 != "" && *keyp == "" {
+		log.Fatal("the private key of the CSR must be provided with -key for PKCS#12 output")
+	}
+
+	profiles := ca.DefaultProfiles()
+	if *profp != "" {
+		var err error
+		profiles, err = ca.LoadProfilesFile(*profp)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	profile, err := profiles.Get(*profn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *d == 0 {
+		*d = time.Hour * 24 * 365 * 2
+		if profile.MaxValidity > 0 && *d > profile.MaxValidity {
+			*d = profile.MaxValidity
+		}
+	}
+
 	//Load the CSR
 	b, err := ioutil.ReadFile(*csrp)
 	if err != nil {
@@ -29,27 +64,62 @@ func main() {
 		log.Fatalf("could not load CSR: %v", err)
 	}
 
-	cb, err := ioutil.ReadFile(*cacertp)
-	if err != nil {
-		log.Fatalf("could not read CA certificate file: %v", err)
-	}
-	kb, err := ioutil.ReadFile(*cakeyp)
-	if err != nil {
-		log.Fatalf("could not read CA key file: %v", err)
-	}
-
-	cacert, cakey, err := certificate.Load(cb, kb, "")
+	cab, err := cmdutil.LoadCA(*cacertp, *cakeyp, *passfile, *kmskey)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	cert, err := ca.Sign(csr, cacert, cakey, *d, rand.Reader)
+	cert, err := ca.SignWithProfile(csr, cab.Leaf(), cab.Key, profile, *d, rand.Reader)
 	if err != nil {
 		log.Fatalf("could not sign certificate: %v", err)
 	}
+	if *ledgerp != "" {
+		l, err := ledger.Open(*ledgerp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = l.Add(cert, profile.Name)
+		if err != nil {
+			log.Fatalf("could not record certificate in ledger: %v", err)
+		}
+	}
 	err = certificate.WriteCertFile(cert, "./"+csr.Subject.String()+".pem")
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Printf("certificate signed and written to: %s", "./"+csr.Subject.String()+".pem")
+
+	if *p12 != "" {
+		kb, err := ioutil.ReadFile(*keyp)
+		if err != nil {
+			log.Fatalf("could not read key file: %v", err)
+		}
+		var keypass string
+		if certificate.IsEncryptedKey(kb) {
+			keypass, err = certificate.ReadPassphrase(*keypassfile, "Private key passphrase: ", false)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		kbd, err := certificate.Parse(kb, keypass)
+		if err != nil {
+			log.Fatalf("could not load key: %v", err)
+		}
+		if kbd.Key == nil {
+			log.Fatal("no private key found in key file")
+		}
+		password, err := certificate.ReadPassphrase(*p12passfile, "PKCS#12 password: ", true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		enc := certificate.PKCS12Modern
+		if *p12legacy {
+			enc = certificate.PKCS12Legacy
+		}
+		err = certificate.WritePKCS12File(kbd.Key, cert, cab.Chain, password, enc, *p12, rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("PKCS#12 written to: %s", *p12)
+	}
 }
@@ -0,0 +1,320 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RevocationReason is the CRL reason code as described in RFC 5280 section 5.3.1.
+type RevocationReason int
+
+const (
+	ReasonUnspecified          RevocationReason = 0
+	ReasonKeyCompromise        RevocationReason = 1
+	ReasonCACompromise         RevocationReason = 2
+	ReasonAffiliationChanged   RevocationReason = 3
+	ReasonSuperseded           RevocationReason = 4
+	ReasonCessationOfOperation RevocationReason = 5
+	ReasonCertificateHold      RevocationReason = 6
+	ReasonRemoveFromCRL        RevocationReason = 8
+	ReasonPrivilegeWithdrawn   RevocationReason = 9
+	ReasonAACompromise         RevocationReason = 10
+)
+
+var revocationReasonNames = map[RevocationReason]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "cACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonCertificateHold:      "certificateHold",
+	ReasonRemoveFromCRL:        "removeFromCRL",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	ReasonAACompromise:         "aACompromise",
+}
+
+func (r RevocationReason) String() string {
+	if s, ok := revocationReasonNames[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("RevocationReason(%d)", int(r))
+}
+
+// ParseRevocationReason returns the reason for the RFC 5280 name provided (eg keyCompromise). Matching is case insensitive.
+func ParseRevocationReason(s string) (RevocationReason, error) {
+	for r, n := range revocationReasonNames {
+		if strings.EqualFold(s, n) {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason: %s", s)
+}
+
+// oidDeltaCRLIndicator is the delta CRL indicator extension as described in RFC 5280 section 5.2.4.
+var oidDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+
+// Revocation records the revocation of a certificate.
+type Revocation struct {
+	SerialNumber *big.Int         `json:"serial_number"`
+	RevokedAt    time.Time        `json:"revoked_at"`
+	Reason       RevocationReason `json:"reason"`
+	// Recorded is when the revocation was recorded and is used to select the entries of delta CRLs.
+	Recorded time.Time `json:"recorded"`
+}
+
+// CreateCRL creates a CRL signed by the CA listing the revoked certificates provided.
+func CreateCRL(CAcrt *x509.Certificate, CAkey crypto.Signer, revoked []Revocation, number *big.Int, nextUpdate time.Duration, rnd io.Reader) (*x509.RevocationList, error) {
+	return createCRL(CAcrt, CAkey, revoked, number, nil, nextUpdate, rnd)
+}
+
+// CreateDeltaCRL creates a delta CRL signed by the CA listing the certificates revoked since the base CRL identified by baseNumber.
+func CreateDeltaCRL(CAcrt *x509.Certificate, CAkey crypto.Signer, revoked []Revocation, number, baseNumber *big.Int, nextUpdate time.Duration, rnd io.Reader) (*x509.RevocationList, error) {
+	if baseNumber == nil {
+		return &x509.RevocationList{}, errors.New("base CRL number required for a delta CRL")
+	}
+	return createCRL(CAcrt, CAkey, revoked, number, baseNumber, nextUpdate, rnd)
+}
+
+func createCRL(CAcrt *x509.Certificate, CAkey crypto.Signer, revoked []Revocation, number, baseNumber *big.Int, nextUpdate time.Duration, rnd io.Reader) (*x509.RevocationList, error) {
+	now := time.Now()
+	template := x509.RevocationList{
+		Number:     number,
+		ThisUpdate: now,
+		NextUpdate: now.Add(nextUpdate),
+	}
+	for _, r := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   r.SerialNumber,
+			RevocationTime: r.RevokedAt,
+			ReasonCode:     int(r.Reason),
+		})
+	}
+	if baseNumber != nil {
+		b, err := asn1.Marshal(baseNumber)
+		if err != nil {
+			return &x509.RevocationList{}, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+			Id:       oidDeltaCRLIndicator,
+			Critical: true,
+			Value:    b,
+		})
+	}
+	crlRaw, err := x509.CreateRevocationList(rnd, &template, CAcrt, CAkey)
+	if err != nil {
+		return &x509.RevocationList{}, err
+	}
+	crl, err := x509.ParseRevocationList(crlRaw)
+	if err != nil {
+		return &x509.RevocationList{}, err
+	}
+	return crl, nil
+}
+
+// RevocationStore records revoked certificates. It allows a RevocationDB to be backed by an external source of truth
+// such as an issued certificate ledger.
+type RevocationStore interface {
+	Revoke(sn *big.Int, reason RevocationReason, at time.Time) error
+	Revocations() ([]Revocation, error)
+}
+
+// RevocationDB records revoked certificates and the numbering of the CRLs issued from them.
+// It is persisted as JSON with Save and LoadRevocationDB.
+type RevocationDB struct {
+	mux         sync.Mutex
+	Revocations []Revocation `json:"revocations"`
+	// CRLNumber is the number of the last CRL or delta CRL issued.
+	CRLNumber *big.Int `json:"crl_number"`
+	// BaseCRLNumber is the number of the last full CRL issued.
+	BaseCRLNumber *big.Int `json:"base_crl_number"`
+	// BaseCRLTime is when the last full CRL was issued.
+	BaseCRLTime time.Time `json:"base_crl_time"`
+	// Store, if set, records the revocations in place of the Revocations field.
+	Store RevocationStore `json:"-"`
+}
+
+// Revoke records the revocation of the certificate with the serial number provided.
+func (db *RevocationDB) Revoke(sn *big.Int, reason RevocationReason, at time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if db.Store != nil {
+		return db.Store.Revoke(sn, reason, at)
+	}
+	for _, r := range db.Revocations {
+		if r.SerialNumber.Cmp(sn) == 0 {
+			return fmt.Errorf("certificate with serial number %s already revoked", sn)
+		}
+	}
+	db.Revocations = append(db.Revocations, Revocation{
+		SerialNumber: sn,
+		RevokedAt:    at,
+		Reason:       reason,
+		Recorded:     time.Now(),
+	})
+	return nil
+}
+
+// IsRevoked returns the revocation of the certificate with the serial number provided, if revoked.
+// An error is returned if the revocations could not be read from the Store.
+func (db *RevocationDB) IsRevoked(sn *big.Int) (Revocation, bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	rs, err := db.revocations()
+	if err != nil {
+		return Revocation{}, false, fmt.Errorf("could not read revocations: %v", err)
+	}
+	for _, r := range rs {
+		if r.SerialNumber.Cmp(sn) == 0 {
+			return r, true, nil
+		}
+	}
+	return Revocation{}, false, nil
+}
+
+func (db *RevocationDB) revocations() ([]Revocation, error) {
+	if db.Store != nil {
+		return db.Store.Revocations()
+	}
+	return db.Revocations, nil
+}
+
+// nextNumber increments and returns the CRL number.
+func (db *RevocationDB) nextNumber() *big.Int {
+	if db.CRLNumber == nil {
+		db.CRLNumber = big.NewInt(0)
+	}
+	db.CRLNumber = new(big.Int).Add(db.CRLNumber, big.NewInt(1))
+	return db.CRLNumber
+}
+
+// CRL issues a full CRL of all revoked certificates that becomes the base for subsequent delta CRLs.
+func (db *RevocationDB) CRL(CAcrt *x509.Certificate, CAkey crypto.Signer, nextUpdate time.Duration, rnd io.Reader) (*x509.RevocationList, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	// Taken before reading the revocations so that any recorded concurrently are included in the next delta CRL.
+	t := time.Now()
+	rs, err := db.sorted(time.Time{})
+	if err != nil {
+		return &x509.RevocationList{}, err
+	}
+	n := db.nextNumber()
+	crl, err := CreateCRL(CAcrt, CAkey, rs, n, nextUpdate, rnd)
+	if err != nil {
+		return crl, err
+	}
+	db.BaseCRLNumber = n
+	db.BaseCRLTime = t
+	return crl, nil
+}
+
+// DeltaCRL issues a delta CRL of the certificates revoked since the last full CRL.
+func (db *RevocationDB) DeltaCRL(CAcrt *x509.Certificate, CAkey crypto.Signer, nextUpdate time.Duration, rnd io.Reader) (*x509.RevocationList, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if db.BaseCRLNumber == nil {
+		return &x509.RevocationList{}, errors.New("a full CRL must be issued before a delta CRL")
+	}
+	rs, err := db.sorted(db.BaseCRLTime)
+	if err != nil {
+		return &x509.RevocationList{}, err
+	}
+	return CreateDeltaCRL(CAcrt, CAkey, rs, db.nextNumber(), db.BaseCRLNumber, nextUpdate, rnd)
+}
+
+// sorted returns the revocations recorded after the time provided ordered by serial number.
+func (db *RevocationDB) sorted(after time.Time) ([]Revocation, error) {
+	all, err := db.revocations()
+	if err != nil {
+		return nil, fmt.Errorf("could not get revocations: %v", err)
+	}
+	var rs []Revocation
+	for _, r := range all {
+		if r.Recorded.After(after) {
+			rs = append(rs, r)
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].SerialNumber.Cmp(rs[j].SerialNumber) < 0
+	})
+	return rs, nil
+}
+
+// LoadRevocationDB loads the revocation database from the JSON file provided.
+// An empty database is returned if the file does not exist.
+func LoadRevocationDB(path string) (*RevocationDB, error) {
+	db := new(RevocationDB)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return db, nil
+		}
+		return db, fmt.Errorf("could not read revocation database: %v", err)
+	}
+	err = json.Unmarshal(b, db)
+	if err != nil {
+		return db, fmt.Errorf("could not parse revocation database: %v", err)
+	}
+	return db, nil
+}
+
+// Save writes the revocation database as JSON to the file provided.
+func (db *RevocationDB) Save(path string) error {
+	db.mux.Lock()
+	b, err := json.MarshalIndent(db, "", "  ")
+	db.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// PEMEncodeCRL returns the PEM encoded bytes for the CRL.
+func PEMEncodeCRL(crl *x509.RevocationList) []byte {
+	return pem.EncodeToMemory(
+		&pem.Block{
+			Type:  "X509 CRL",
+			Bytes: crl.Raw,
+		},
+	)
+}
+
+// WriteCRL writes the CRL to the io.Writer provided as PEM or, if der is true, as DER.
+func WriteCRL(crl *x509.RevocationList, w io.Writer, der bool) error {
+	if der {
+		_, err := w.Write(crl.Raw)
+		return err
+	}
+	return pem.Encode(w, &pem.Block{Type: "X509 CRL", Bytes: crl.Raw})
+}
+
+// WriteCRLFile writes the CRL to the file provided as PEM or, if der is true, as DER.
+func WriteCRLFile(crl *x509.RevocationList, out string, der bool) error {
+	crlOut, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("could not create CRL file: %v", err)
+	}
+	err = WriteCRL(crl, crlOut, der)
+	if err != nil {
+		return fmt.Errorf("failed to write CRL data: %v", err)
+	}
+	err = crlOut.Close()
+	if err != nil {
+		return fmt.Errorf("could not close CRL file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevocationDB_CRL(t *testing.T) {
+	caCert, caKey := testCA(t)
+	db := new(RevocationDB)
+	err := db.Revoke(big.NewInt(100), ReasonKeyCompromise, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("error revoking: %v", err)
+	}
+	err = db.Revoke(big.NewInt(100), ReasonSuperseded, time.Now())
+	assert.Error(t, err, "revoking twice should error")
+	_, err = db.DeltaCRL(caCert, caKey, time.Hour, rand.Reader)
+	assert.Error(t, err, "delta CRL without base should error")
+
+	crl, err := db.CRL(caCert, caKey, time.Hour*24, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CRL: %v", err)
+	}
+	assert.NoError(t, crl.CheckSignatureFrom(caCert), "CRL signature not valid")
+	assert.Equal(t, int64(1), crl.Number.Int64(), "CRL number not as expected")
+	if assert.Len(t, crl.RevokedCertificateEntries, 1, "number of revoked entries not as expected") {
+		assert.Equal(t, int64(100), crl.RevokedCertificateEntries[0].SerialNumber.Int64(), "revoked serial not as expected")
+		assert.Equal(t, int(ReasonKeyCompromise), crl.RevokedCertificateEntries[0].ReasonCode, "reason code not as expected")
+	}
+
+	err = db.Revoke(big.NewInt(200), ReasonCessationOfOperation, time.Now())
+	if err != nil {
+		t.Fatalf("error revoking: %v", err)
+	}
+	delta, err := db.DeltaCRL(caCert, caKey, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating delta CRL: %v", err)
+	}
+	assert.NoError(t, delta.CheckSignatureFrom(caCert), "delta CRL signature not valid")
+	assert.Equal(t, int64(2), delta.Number.Int64(), "delta CRL number not as expected")
+	if assert.Len(t, delta.RevokedCertificateEntries, 1, "number of delta entries not as expected") {
+		assert.Equal(t, int64(200), delta.RevokedCertificateEntries[0].SerialNumber.Int64(), "delta revoked serial not as expected")
+	}
+	var found bool
+	for _, e := range delta.Extensions {
+		if e.Id.Equal(oidDeltaCRLIndicator) {
+			found = true
+			assert.True(t, e.Critical, "delta CRL indicator should be critical")
+		}
+	}
+	assert.True(t, found, "delta CRL indicator extension not present")
+
+	r, ok, err := db.IsRevoked(big.NewInt(200))
+	if err != nil {
+		t.Fatalf("error checking revocation: %v", err)
+	}
+	assert.True(t, ok, "serial should be revoked")
+	assert.Equal(t, ReasonCessationOfOperation, r.Reason, "reason not as expected")
+	_, ok, err = db.IsRevoked(big.NewInt(300))
+	if err != nil {
+		t.Fatalf("error checking revocation: %v", err)
+	}
+	assert.False(t, ok, "serial should not be revoked")
+}
+
+func TestRevocationDB_SaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "revocations.json")
+	db, err := LoadRevocationDB(path)
+	if err != nil {
+		t.Fatalf("error loading missing database: %v", err)
+	}
+	caCert, caKey := testCA(t)
+	db.Revoke(big.NewInt(42), ReasonAffiliationChanged, time.Now())
+	_, err = db.CRL(caCert, caKey, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CRL: %v", err)
+	}
+	err = db.Save(path)
+	if err != nil {
+		t.Fatalf("error saving database: %v", err)
+	}
+	db, err = LoadRevocationDB(path)
+	if err != nil {
+		t.Fatalf("error loading database: %v", err)
+	}
+	_, ok, err := db.IsRevoked(big.NewInt(42))
+	if err != nil {
+		t.Fatalf("error checking revocation: %v", err)
+	}
+	assert.True(t, ok, "revocation not persisted")
+	assert.Equal(t, int64(1), db.BaseCRLNumber.Int64(), "base CRL number not persisted")
+}
+
+func TestWriteCRL(t *testing.T) {
+	caCert, caKey := testCA(t)
+	crl, err := CreateCRL(caCert, caKey, nil, big.NewInt(1), time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CRL: %v", err)
+	}
+	var b bytes.Buffer
+	err = WriteCRL(crl, &b, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, PEMEncodeCRL(crl), b.Bytes(), "PEM encoding not as expected")
+	p, _ := pem.Decode(b.Bytes())
+	if assert.NotNil(t, p, "could not decode PEM") {
+		assert.Equal(t, "X509 CRL", p.Type, "PEM type not as expected")
+	}
+	b.Reset()
+	err = WriteCRL(crl, &b, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = x509.ParseRevocationList(b.Bytes())
+	assert.NoError(t, err, "DER CRL could not be parsed")
+}
@@ -0,0 +1,293 @@
+package ca
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SANType is a bit mask of the subject alternative name types a profile permits.
+type SANType int
+
+const (
+	SANDNS SANType = 1 << iota
+	SANIP
+	SANEmail
+	SANURI
+
+	SANAny = SANDNS | SANIP | SANEmail | SANURI
+)
+
+// Names of the built in profiles.
+const (
+	ProfileDefault        = "default"
+	ProfileServer         = "server"
+	ProfileClient         = "client"
+	ProfileCodeSigning    = "code-signing"
+	ProfileEmail          = "email"
+	ProfileIntermediateCA = "intermediate-ca"
+	ProfileOCSPSigning    = "ocsp-signing"
+)
+
+// ErrNotPermitted is returned when a signing request asks for a subject alternative name or validity the profile does
+// not permit.
+var ErrNotPermitted = errors.New("not permitted by signing profile")
+
+// SANFilter is called for each subject alternative name of a type permitted by a profile.
+// The name is the string form of the DNS name, IP address, email address or URI.
+// Returning false rejects the signing request.
+type SANFilter func(t SANType, name string) bool
+
+// Profile is a signing policy that controls the content of the certificates issued by SignWithProfile.
+type Profile struct {
+	Name        string
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	// MaxValidity caps the duration of issued certificates. Zero means no limit.
+	MaxValidity time.Duration
+	AllowedSANs SANType
+	// SANFilter, if set, is applied to each subject alternative name of an allowed type.
+	SANFilter SANFilter
+	IsCA      bool
+	// MaxPathLen is the path length constraint for CA profiles. A negative value means unconstrained.
+	MaxPathLen int
+}
+
+// Profiles is a set of profiles keyed by name.
+type Profiles map[string]Profile
+
+// DefaultProfiles returns the built in profiles.
+// The default profile matches the certificates issued by Sign.
+func DefaultProfiles() Profiles {
+	return Profiles{
+		ProfileDefault: {
+			Name:        ProfileDefault,
+			KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageAny, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			AllowedSANs: SANAny,
+			MaxPathLen:  -1,
+		},
+		ProfileServer: {
+			Name:        ProfileServer,
+			KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			MaxValidity: time.Hour * 24 * 397,
+			AllowedSANs: SANDNS | SANIP,
+			MaxPathLen:  -1,
+		},
+		ProfileClient: {
+			Name:        ProfileClient,
+			KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			MaxValidity: time.Hour * 24 * 365 * 2,
+			AllowedSANs: SANAny,
+			MaxPathLen:  -1,
+		},
+		ProfileCodeSigning: {
+			Name:        ProfileCodeSigning,
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+			MaxValidity: time.Hour * 24 * 365 * 3,
+			AllowedSANs: SANEmail | SANURI,
+			MaxPathLen:  -1,
+		},
+		ProfileEmail: {
+			Name:        ProfileEmail,
+			KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+			MaxValidity: time.Hour * 24 * 365 * 2,
+			AllowedSANs: SANEmail,
+			MaxPathLen:  -1,
+		},
+		ProfileOCSPSigning: {
+			Name:        ProfileOCSPSigning,
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+			MaxValidity: time.Hour * 24 * 90,
+			AllowedSANs: SANDNS,
+			MaxPathLen:  -1,
+		},
+		ProfileIntermediateCA: {
+			Name:        ProfileIntermediateCA,
+			KeyUsage:    x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageCRLSign,
+			MaxValidity: time.Hour * 24 * 365 * 10,
+			IsCA:        true,
+			MaxPathLen:  0,
+		},
+	}
+}
+
+// Get returns the profile with the name provided.
+func (p Profiles) Get(name string) (Profile, error) {
+	prof, ok := p[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown signing profile: %s", name)
+	}
+	return prof, nil
+}
+
+// profileConfig is the JSON/YAML representation of a Profile.
+type profileConfig struct {
+	KeyUsage    []string `json:"key_usage" yaml:"key_usage"`
+	ExtKeyUsage []string `json:"ext_key_usage" yaml:"ext_key_usage"`
+	MaxValidity string   `json:"max_validity" yaml:"max_validity"`
+	AllowedSANs []string `json:"allowed_sans" yaml:"allowed_sans"`
+	CA          bool     `json:"ca" yaml:"ca"`
+	MaxPathLen  *int     `json:"max_path_len" yaml:"max_path_len"`
+}
+
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digital_signature":  x509.KeyUsageDigitalSignature,
+	"content_commitment": x509.KeyUsageContentCommitment,
+	"key_encipherment":   x509.KeyUsageKeyEncipherment,
+	"data_encipherment":  x509.KeyUsageDataEncipherment,
+	"key_agreement":      x509.KeyUsageKeyAgreement,
+	"cert_sign":          x509.KeyUsageCertSign,
+	"crl_sign":           x509.KeyUsageCRLSign,
+	"encipher_only":      x509.KeyUsageEncipherOnly,
+	"decipher_only":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"any":              x509.ExtKeyUsageAny,
+	"server_auth":      x509.ExtKeyUsageServerAuth,
+	"client_auth":      x509.ExtKeyUsageClientAuth,
+	"code_signing":     x509.ExtKeyUsageCodeSigning,
+	"email_protection": x509.ExtKeyUsageEmailProtection,
+	"time_stamping":    x509.ExtKeyUsageTimeStamping,
+	"ocsp_signing":     x509.ExtKeyUsageOCSPSigning,
+}
+
+var sanTypeNames = map[string]SANType{
+	"dns":   SANDNS,
+	"ip":    SANIP,
+	"email": SANEmail,
+	"uri":   SANURI,
+	"any":   SANAny,
+}
+
+// LoadProfiles parses JSON or YAML encoded profiles keyed by name and returns them along with the built in profiles.
+// A loaded profile replaces any built in profile of the same name.
+//
+// Example YAML:
+//
+//	server:
+//	  key_usage: [digital_signature, key_encipherment]
+//	  ext_key_usage: [server_auth]
+//	  max_validity: 9528h
+//	  allowed_sans: [dns, ip]
+func LoadProfiles(b []byte) (Profiles, error) {
+	// YAML is a superset of JSON so both are handled by the YAML decoder.
+	var cfg map[string]profileConfig
+	err := yaml.Unmarshal(b, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signing profiles: %v", err)
+	}
+	profs := DefaultProfiles()
+	for name, c := range cfg {
+		p, err := c.profile(name)
+		if err != nil {
+			return nil, fmt.Errorf("signing profile %s: %v", name, err)
+		}
+		profs[name] = p
+	}
+	return profs, nil
+}
+
+// LoadProfilesFile reads the JSON or YAML file of profiles at the path provided.
+func LoadProfilesFile(path string) (Profiles, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read signing profiles file: %v", err)
+	}
+	return LoadProfiles(b)
+}
+
+func (c profileConfig) profile(name string) (Profile, error) {
+	p := Profile{
+		Name:       name,
+		IsCA:       c.CA,
+		MaxPathLen: -1,
+	}
+	for _, s := range c.KeyUsage {
+		ku, ok := keyUsageNames[strings.ToLower(s)]
+		if !ok {
+			return Profile{}, fmt.Errorf("unknown key usage: %s", s)
+		}
+		p.KeyUsage |= ku
+	}
+	for _, s := range c.ExtKeyUsage {
+		eku, ok := extKeyUsageNames[strings.ToLower(s)]
+		if !ok {
+			return Profile{}, fmt.Errorf("unknown extended key usage: %s", s)
+		}
+		p.ExtKeyUsage = append(p.ExtKeyUsage, eku)
+	}
+	for _, s := range c.AllowedSANs {
+		st, ok := sanTypeNames[strings.ToLower(s)]
+		if !ok {
+			return Profile{}, fmt.Errorf("unknown subject alternative name type: %s", s)
+		}
+		p.AllowedSANs |= st
+	}
+	if c.MaxValidity != "" {
+		d, err := time.ParseDuration(c.MaxValidity)
+		if err != nil {
+			return Profile{}, fmt.Errorf("invalid max validity: %v", err)
+		}
+		p.MaxValidity = d
+	}
+	if c.MaxPathLen != nil {
+		if !c.CA {
+			return Profile{}, fmt.Errorf("max path length is only valid for CA profiles")
+		}
+		p.MaxPathLen = *c.MaxPathLen
+	}
+	return p, nil
+}
+
+// allows returns if the subject alternative name is permitted by the profile.
+func (p Profile) allows(t SANType, name string) bool {
+	if p.AllowedSANs&t == 0 {
+		return false
+	}
+	if p.SANFilter != nil {
+		return p.SANFilter(t, name)
+	}
+	return true
+}
+
+// applySANs copies the subject alternative names of the CSR to the certificate template.
+// An error naming the first subject alternative name not permitted by the profile is returned.
+func (p Profile) applySANs(csr *x509.CertificateRequest, tmpl *x509.Certificate) error {
+	for _, n := range csr.DNSNames {
+		if !p.allows(SANDNS, n) {
+			return fmt.Errorf("%w %s: DNS name %s", ErrNotPermitted, p.Name, n)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		if !p.allows(SANIP, ip.String()) {
+			return fmt.Errorf("%w %s: IP address %s", ErrNotPermitted, p.Name, ip)
+		}
+	}
+	for _, e := range csr.EmailAddresses {
+		if !p.allows(SANEmail, e) {
+			return fmt.Errorf("%w %s: email address %s", ErrNotPermitted, p.Name, e)
+		}
+	}
+	for _, u := range csr.URIs {
+		if !p.allows(SANURI, u.String()) {
+			return fmt.Errorf("%w %s: URI %s", ErrNotPermitted, p.Name, u)
+		}
+	}
+	tmpl.DNSNames = csr.DNSNames
+	tmpl.IPAddresses = csr.IPAddresses
+	tmpl.EmailAddresses = csr.EmailAddresses
+	tmpl.URIs = csr.URIs
+	return nil
+}
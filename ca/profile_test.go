@@ -0,0 +1,188 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/csr"
+	"github.com/stretchr/testify/assert"
+)
+
+const testProfilesYAML = `
+server:
+  key_usage: [digital_signature, key_encipherment]
+  ext_key_usage: [server_auth]
+  max_validity: 720h
+  allowed_sans: [dns, ip]
+sub-ca:
+  key_usage: [cert_sign, crl_sign]
+  ca: true
+  max_path_len: 1
+`
+
+const testProfilesJSON = `{
+  "email-only": {
+    "key_usage": ["digital_signature"],
+    "ext_key_usage": ["email_protection"],
+    "allowed_sans": ["email"]
+  }
+}`
+
+func testCA(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	caCSR, caKey, err := csr.NewWithKeyAlgorithm(pkix.Name{CommonName: "Test CA"}, []string{}, csr.ECDSAP256, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CA CSR: %v", err)
+	}
+	caCert, err := New(caCSR, caKey, time.Hour*24*365, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	return caCert, caKey
+}
+
+func TestLoadProfiles(t *testing.T) {
+	profs, err := LoadProfiles([]byte(testProfilesYAML))
+	if err != nil {
+		t.Fatalf("error loading YAML profiles: %v", err)
+	}
+	p, err := profs.Get(ProfileServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment, p.KeyUsage, "key usage not as expected")
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, p.ExtKeyUsage, "extended key usage not as expected")
+	assert.Equal(t, time.Hour*720, p.MaxValidity, "max validity not as expected")
+	assert.Equal(t, SANDNS|SANIP, p.AllowedSANs, "allowed SANs not as expected")
+	p, err = profs.Get("sub-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, p.IsCA, "profile should be for a CA")
+	assert.Equal(t, 1, p.MaxPathLen, "max path length not as expected")
+	_, err = profs.Get(ProfileCodeSigning)
+	assert.NoError(t, err, "built in profiles should be included")
+
+	profs, err = LoadProfiles([]byte(testProfilesJSON))
+	if err != nil {
+		t.Fatalf("error loading JSON profiles: %v", err)
+	}
+	p, err = profs.Get("email-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, SANEmail, p.AllowedSANs, "allowed SANs not as expected")
+	assert.Equal(t, -1, p.MaxPathLen, "max path length not as expected")
+
+	_, err = LoadProfiles([]byte("bad:\n  key_usage: [not_a_usage]\n"))
+	assert.Error(t, err, "error expected for unknown key usage")
+	_, err = LoadProfiles([]byte("bad:\n  max_path_len: 1\n"))
+	assert.Error(t, err, "error expected for path length on non CA profile")
+}
+
+func TestSignWithProfile(t *testing.T) {
+	caCert, caKey := testCA(t)
+	r, _, err := csr.New(pkix.Name{CommonName: "host.test.local"}, []string{}, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CSR: %v", err)
+	}
+	profs := DefaultProfiles()
+
+	_, err = SignWithProfile(r, caCert, caKey, profs[ProfileServer], time.Hour*24*365*2, rand.Reader)
+	if assert.Error(t, err, "duration beyond the profile's maximum validity should error") {
+		assert.True(t, errors.Is(err, ErrNotPermitted), "error should wrap ErrNotPermitted")
+		assert.Contains(t, err.Error(), "17520h0m0s", "error should name the duration")
+	}
+	crt, err := SignWithProfile(r, caCert, caKey, profs[ProfileServer], profs[ProfileServer].MaxValidity, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing with server profile: %v", err)
+	}
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, crt.ExtKeyUsage, "extended key usage not as expected")
+	assert.Equal(t, []string{"host.test.local"}, crt.DNSNames, "DNS names not as expected")
+	assert.False(t, crt.IsCA, "certificate should not be a CA")
+
+	_, err = SignWithProfile(r, caCert, caKey, profs[ProfileEmail], time.Hour, rand.Reader)
+	if assert.Error(t, err, "DNS names should not be allowed by the email profile") {
+		assert.Contains(t, err.Error(), "DNS name host.test.local", "error should name the rejected SAN")
+	}
+	er, _, err := csr.New(pkix.Name{CommonName: "John Smith"}, []string{"john@test.local"}, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CSR: %v", err)
+	}
+	crt, err = SignWithProfile(er, caCert, caKey, profs[ProfileEmail], time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing with email profile for a personal name: %v", err)
+	}
+	assert.Equal(t, []string{"john@test.local"}, crt.EmailAddresses, "email addresses not as expected")
+
+	ir, _, err := csr.New(pkix.Name{}, []string{}, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CSR: %v", err)
+	}
+	crt, err = SignWithProfile(ir, caCert, caKey, profs[ProfileIntermediateCA], time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing with intermediate CA profile: %v", err)
+	}
+	assert.True(t, crt.IsCA, "certificate should be a CA")
+	assert.True(t, crt.MaxPathLenZero, "max path length zero not set")
+	assert.Equal(t, x509.KeyUsageCertSign, crt.KeyUsage&x509.KeyUsageCertSign, "cert sign usage not set")
+	assert.False(t, crt.NotAfter.After(caCert.NotAfter), "CA validity extends beyond the issuing CA")
+
+	crt, err = SignWithProfile(ir, caCert, caKey, profs[ProfileIntermediateCA], time.Hour*24*365, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing with intermediate CA profile: %v", err)
+	}
+	assert.False(t, crt.NotAfter.After(caCert.NotAfter), "CA validity should be limited to that of the issuing CA")
+
+	sr, subKey, err := csr.New(pkix.Name{CommonName: "Test Issuing CA"}, []string{}, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CSR: %v", err)
+	}
+	subCA, err := SignIntermediate(sr, caCert, caKey, 0, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing issuing CA: %v", err)
+	}
+	_, err = SignWithProfile(ir, subCA, subKey, profs[ProfileIntermediateCA], time.Hour, rand.Reader)
+	assert.Error(t, err, "CA profile beyond the issuing CA's path length should be rejected")
+	leaf, err := Sign(r, subCA, subKey, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing leaf certificate: %v", err)
+	}
+	_, err = SignWithProfile(ir, leaf, subKey, profs[ProfileIntermediateCA], time.Hour, rand.Reader)
+	assert.Error(t, err, "CA profile signed by a non CA certificate should be rejected")
+}
+
+func TestSignWithProfile_SANs(t *testing.T) {
+	caCert, caKey := testCA(t)
+	r, _, err := csr.New(pkix.Name{CommonName: "host.test.local"}, []string{"10.1.2.3", "admin@test.local", "spiffe://test.local/web"}, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CSR: %v", err)
+	}
+	crt, err := Sign(r, caCert, caKey, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing: %v", err)
+	}
+	assert.Equal(t, []string{"host.test.local"}, crt.DNSNames, "DNS names not as expected")
+	assert.Len(t, crt.IPAddresses, 1, "IP addresses not carried through")
+	assert.Equal(t, []string{"admin@test.local"}, crt.EmailAddresses, "email addresses not carried through")
+	assert.Len(t, crt.URIs, 1, "URIs not carried through")
+
+	_, err = SignWithProfile(r, caCert, caKey, DefaultProfiles()[ProfileServer], time.Hour, rand.Reader)
+	if assert.Error(t, err, "email addresses should not be allowed by the server profile") {
+		assert.True(t, errors.Is(err, ErrNotPermitted), "error should wrap ErrNotPermitted")
+		assert.Contains(t, err.Error(), "email address admin@test.local", "error should name the rejected SAN")
+	}
+
+	p := DefaultProfiles()[ProfileDefault]
+	p.SANFilter = func(st SANType, name string) bool {
+		return st != SANURI || name != "spiffe://test.local/web"
+	}
+	_, err = SignWithProfile(r, caCert, caKey, p, time.Hour, rand.Reader)
+	if assert.Error(t, err, "SAN rejected by the filter should error") {
+		assert.Contains(t, err.Error(), "URI spiffe://test.local/web", "error should name the rejected SAN")
+	}
+}
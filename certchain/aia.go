@@ -0,0 +1,151 @@
+package certchain
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/jcmturner/pki/certificate"
+)
+
+const (
+	// DefaultAIADepth is the default maximum number of issuer certificates fetched to complete a chain.
+	DefaultAIADepth = 5
+	// maxAIASize limits the size of a CA Issuers response.
+	maxAIASize = 1 << 20
+)
+
+// AIA completes certificate chains by following the Authority Information Access "CA Issuers" URLs of the
+// certificates to fetch missing issuers. Fetched certificates are cached by URL.
+type AIA struct {
+	// Client fetches the issuer certificates. If nil a client with DefaultTimeout is used.
+	Client *http.Client
+	// Roots are the trust anchors at which chasing stops. If nil the system roots are used.
+	Roots *x509.CertPool
+	// MaxDepth is the maximum number of issuer certificates fetched for a chain. Zero or less uses DefaultAIADepth.
+	MaxDepth int
+	mu       sync.Mutex
+	cache    map[string][]*x509.Certificate
+}
+
+// NewAIA returns an AIA that fetches issuer certificates with the HTTP client provided.
+func NewAIA(cl *http.Client) *AIA {
+	return &AIA{
+		Client:   cl,
+		MaxDepth: DefaultAIADepth,
+	}
+}
+
+// Certificates returns the certificate chain presented by the endpoint ordered leaf to root and completed with any
+// missing issuers. The address must be in the form <fqdn>:<port>
+func (a *AIA) Certificates(addr string, proto Protocol) ([]*x509.Certificate, error) {
+	certs, err := Certificates(addr, proto)
+	if err != nil {
+		return nil, err
+	}
+	return a.Complete(certs)
+}
+
+// Complete orders the certificates leaf to root and fetches missing issuers until the chain reaches a self-signed
+// certificate or one issued by a trust anchor. The chain built so far is returned with any error.
+func (a *AIA) Complete(certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	chain := Order(certs)
+	if len(chain) < 1 {
+		return chain, errors.New("no certificates to complete")
+	}
+	maxDepth := a.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultAIADepth
+	}
+	for depth := 0; ; depth++ {
+		c := chain[len(chain)-1]
+		if isSelfSigned(c) || a.anchored(c) {
+			return chain, nil
+		}
+		if len(c.IssuingCertificateURL) < 1 {
+			return chain, fmt.Errorf("chain incomplete: no CA issuers URL in certificate %s", c.Subject)
+		}
+		if depth >= maxDepth {
+			return chain, fmt.Errorf("chain incomplete: maximum depth of %d reached", maxDepth)
+		}
+		p, err := a.issuer(c)
+		if err != nil {
+			return chain, err
+		}
+		if contains(chain, p) {
+			return chain, fmt.Errorf("chain loops at certificate %s", p.Subject)
+		}
+		chain = append(chain, p)
+	}
+}
+
+// anchored returns true if the certificate is issued by one of the roots.
+func (a *AIA) anchored(c *x509.Certificate) bool {
+	_, err := c.Verify(x509.VerifyOptions{
+		Roots:     a.Roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+// issuer fetches the issuer of the certificate from its CA Issuers URLs, trying each in turn.
+func (a *AIA) issuer(c *x509.Certificate) (*x509.Certificate, error) {
+	var errs []error
+	for _, u := range c.IssuingCertificateURL {
+		certs, err := a.fetch(u)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, p := range certs {
+			if c.CheckSignatureFrom(p) == nil {
+				return p, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("no issuer of %s found at %s", c.Subject, u))
+	}
+	return nil, fmt.Errorf("could not fetch issuer: %v", errs)
+}
+
+// fetch returns the certificates at the URL, which may be DER, PEM or PKCS#7 encoded.
+func (a *AIA) fetch(u string) ([]*x509.Certificate, error) {
+	a.mu.Lock()
+	if a.cache == nil {
+		a.cache = make(map[string][]*x509.Certificate)
+	}
+	certs, ok := a.cache[u]
+	a.mu.Unlock()
+	if ok {
+		return certs, nil
+	}
+
+	cl := a.Client
+	if cl == nil {
+		cl = &http.Client{Timeout: DefaultTimeout}
+	}
+	resp, err := cl.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch %s: %v", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch %s: %s", u, resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAIASize))
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", u, err)
+	}
+	bundle, err := certificate.Parse(b, "")
+	if err != nil {
+		return nil, fmt.Errorf("could not parse certificates from %s: %v", u, err)
+	}
+
+	a.mu.Lock()
+	a.cache[u] = bundle.Chain
+	a.mu.Unlock()
+	return bundle.Chain, nil
+}
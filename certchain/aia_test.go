@@ -0,0 +1,131 @@
+package certchain
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/certificate"
+	"github.com/stretchr/testify/assert"
+)
+
+// issue returns a certificate for a new key issued by the parent, or self-signed if the parent is nil.
+// Certificates that are not CAs have the common name and any IP addresses provided as subject alternative names.
+func issue(t *testing.T, cn string, ca bool, aia string, parent *x509.Certificate, parentKey crypto.Signer, ips ...string) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+		DNSNames:              []string{cn},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, ip := range ips {
+		tmpl.IPAddresses = append(tmpl.IPAddresses, net.ParseIP(ip))
+	}
+	if ca {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		tmpl.DNSNames = nil
+		tmpl.IPAddresses = nil
+	}
+	if aia != "" {
+		tmpl.IssuingCertificateURL = []string{aia}
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	return c, key
+}
+
+func TestAIA_Complete(t *testing.T) {
+	var reqs int32
+	mux := http.NewServeMux()
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	root, rootKey := issue(t, "Root CA", true, "", nil, nil)
+	inter2, inter2Key := issue(t, "Intermediate CA 2", true, s.URL+"/root.cer", root, rootKey)
+	inter1, inter1Key := issue(t, "Intermediate CA 1", true, s.URL+"/inter2.crt", inter2, inter2Key)
+	leaf, _ := issue(t, "host.test.local", false, s.URL+"/inter1.pem", inter1, inter1Key)
+	broken, _ := issue(t, "broken.test.local", false, s.URL+"/missing.cer", inter1, inter1Key)
+
+	serve := func(b []byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&reqs, 1)
+			w.Write(b)
+		}
+	}
+	mux.HandleFunc("/root.cer", serve(root.Raw))
+	mux.HandleFunc("/inter2.crt", serve(certificate.PEMEncode(inter2)))
+	mux.HandleFunc("/inter1.pem", serve(certificate.PEMEncode(inter1)))
+
+	a := NewAIA(s.Client())
+	chain, err := a.Complete([]*x509.Certificate{leaf})
+	if err != nil {
+		t.Fatalf("error completing chain: %v", err)
+	}
+	assert.Equal(t, []*x509.Certificate{leaf, inter1, inter2, root}, chain, "chain not as expected")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&reqs), "number of requests not as expected")
+
+	// Cached
+	chain, err = a.Complete([]*x509.Certificate{leaf})
+	if err != nil {
+		t.Fatalf("error completing chain: %v", err)
+	}
+	assert.Len(t, chain, 4, "chain not complete from cache")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&reqs), "issuers should be cached")
+
+	// Stops at a trust anchor
+	roots := x509.NewCertPool()
+	roots.AddCert(inter2)
+	a.Roots = roots
+	chain, err = a.Complete([]*x509.Certificate{leaf, inter1})
+	if err != nil {
+		t.Fatalf("error completing chain: %v", err)
+	}
+	assert.Equal(t, []*x509.Certificate{leaf, inter1}, chain, "chain should stop at the trust anchor")
+	a.Roots = nil
+
+	// Depth limit
+	a.MaxDepth = 1
+	chain, err = a.Complete([]*x509.Certificate{leaf})
+	assert.Error(t, err, "exceeding the depth limit should error")
+	assert.Equal(t, []*x509.Certificate{leaf, inter1}, chain, "partial chain not as expected")
+	a.MaxDepth = DefaultAIADepth
+
+	// A zero value AIA uses the default depth.
+	chain, err = (&AIA{Client: s.Client()}).Complete([]*x509.Certificate{leaf})
+	if err != nil {
+		t.Fatalf("error completing chain with zero value AIA: %v", err)
+	}
+	assert.Len(t, chain, 4, "chain not complete with zero value AIA")
+
+	// Missing issuer
+	chain, err = a.Complete([]*x509.Certificate{broken})
+	assert.Error(t, err, "missing issuer should error")
+	assert.Equal(t, []*x509.Certificate{broken}, chain, "partial chain not as expected")
+}
@@ -1,28 +1,29 @@
 package certchain
 
 import (
-	"crypto/tls"
+	"context"
 	"crypto/x509"
 	"io"
 
 	"github.com/jcmturner/pki/certificate"
 )
 
-// conn establishes a connection without verifying the certificate
-func conn(addr string) (*tls.Conn, error) {
-	cfg := &tls.Config{InsecureSkipVerify: true}
-	return tls.Dial("tcp", addr, cfg)
+// Certificates returns the certificate chain presented by the endpoint.
+// The address must be in the form <fqdn>:<port>
+// The protocol determines any STARTTLS negotiation performed before the TLS handshake.
+func Certificates(addr string, proto Protocol) ([]*x509.Certificate, error) {
+	f := &Fetcher{Protocol: proto, Timeout: DefaultTimeout}
+	return f.Certificates(context.Background(), addr)
 }
 
 // Bytes returns a byte slice of the complete certificate chain.
 // The address must be in the form <fqdn>:<port>
-func Bytes(addr string) ([]byte, error) {
+func Bytes(addr string, proto Protocol) ([]byte, error) {
 	var b []byte
-	conn, err := conn(addr)
+	certs, err := Certificates(addr, proto)
 	if err != nil {
 		return b, err
 	}
-	certs := conn.ConnectionState().PeerCertificates
 	for _, c := range certs {
 		b = append(b, certificate.PEMEncode(c)...)
 	}
@@ -32,23 +33,32 @@ func Bytes(addr string) ([]byte, error) {
 // Write returns writes the certificate chain to the io.Writer provided.
 // The values returned are the number of bytes written and any error.
 // The address must be in the form <fqdn>:<port>
-func Write(addr string, w io.Writer) (int, error) {
-	b, err := Bytes(addr)
+func Write(addr string, proto Protocol, w io.Writer) (int, error) {
+	b, err := Bytes(addr, proto)
 	if err != nil {
 		return 0, err
 	}
 	return w.Write(b)
 }
 
+// TrustStore returns the certificate chain as a Java trust store of the format specified protected by the password.
+// The address must be in the form <fqdn>:<port>
+func TrustStore(addr string, proto Protocol, format certificate.TrustStoreFormat, password string, rnd io.Reader) ([]byte, error) {
+	certs, err := Certificates(addr, proto)
+	if err != nil {
+		return []byte{}, err
+	}
+	return certificate.EncodeTrustStore(certs, format, password, rnd)
+}
+
 // CertPool returns the certificate chain as a x509.CertPool.
 // The address must be in the form <fqdn>:<port>
-func CertPool(addr string) (*x509.CertPool, error) {
+func CertPool(addr string, proto Protocol) (*x509.CertPool, error) {
 	cp := x509.NewCertPool()
-	conn, err := conn(addr)
+	certs, err := Certificates(addr, proto)
 	if err != nil {
 		return cp, err
 	}
-	certs := conn.ConnectionState().PeerCertificates
 	for _, c := range certs {
 		cp.AddCert(c)
 	}
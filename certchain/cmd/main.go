@@ -1,31 +1,269 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jcmturner/pki/certchain"
+	"github.com/jcmturner/pki/certificate"
 )
 
 func main() {
 	fqdn := flag.String("fqdn", "", "FQDN of endpoint to get certificate chain from")
-	port := flag.Int("port", 443, "TCP port to connect to")
-	out := flag.String("out", "./certchain.pem", "File to output certificate chain to")
+	port := flag.Int("port", 0, "TCP port to connect to (default the protocol's well known port)")
+	protocol := flag.String("protocol", "tls", "Protocol to negotiate TLS with (tls, smtp, imap, pop3, ldap, postgres, mysql, xmpp)")
+	in := flag.String("in", "", "File of certificates, such as a CA certificate, to use instead of an endpoint's chain")
+	format := flag.String("format", "pem", "Output format (pem, jks, p12)")
+	storepass := flag.String("storepass", "changeit", "Password of the trust store for jks and p12 output")
+	out := flag.String("out", "", "File to output certificate chain, or scan report, to (default ./certchain.<format> or ./certchain-report.<report>)")
+	verify := flag.Bool("verify", false, "Verify the chain and output it ordered from leaf to root. Problems found are reported and cause a non-zero exit")
+	rootsp := flag.String("roots", "", "File of root certificates to verify against instead of the system roots")
+	aia := flag.Bool("aia", false, "Fetch intermediates missing from the chain using the certificates' CA Issuers URLs")
+	aiatimeout := flag.Duration("aiatimeout", certchain.DefaultTimeout, "Timeout for each CA Issuers URL fetch")
+	timeout := flag.Duration("timeout", certchain.DefaultTimeout, "Timeout for connecting to the endpoint and completing the TLS handshake")
+	sni := flag.String("servername", "", "Server name indication (SNI) to send instead of the FQDN")
+	proxyURL := flag.String("proxy", "", "Proxy to connect through as http://[user:pass@]host:port (HTTP CONNECT) or socks5://[user:pass@]host:port")
+	clientcert := flag.String("clientcert", "", "Client certificate file to present if requested (PEM, DER or PKCS#12)")
+	clientkey := flag.String("clientkey", "", "Client certificate private key file. Not required if the client certificate file also holds the key")
+	clientpassfile := flag.String("clientpassfile", "", "File containing the passphrase of the client certificate key. If not provided and the key is encrypted the passphrase is prompted for")
+	minver := flag.String("minversion", "", "Minimum TLS version to negotiate (1.0, 1.1, 1.2, 1.3)")
+	maxver := flag.String("maxversion", "", "Maximum TLS version to negotiate (1.0, 1.1, 1.2, 1.3)")
+	targetsp := flag.String("targets", "", "File listing endpoints to scan, one [<protocol>://]<fqdn>[:<port>] per line, producing a report instead of a chain. Use - for stdin")
+	workers := flag.Int("workers", certchain.DefaultWorkers, "Number of endpoints to scan concurrently")
+	report := flag.String("report", "json", "Format of the scan report (json, csv)")
 	flag.Parse()
 
-	f, err := os.Create(*out)
+	proto, err := certchain.ParseProtocol(*protocol)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error creating output file: %v\n", err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	n, err := certchain.Write(fmt.Sprintf("%s:%d", *fqdn, *port), f)
+	if *port == 0 {
+		*port = proto.DefaultPort()
+	}
+	if *out == "" {
+		*out = "./certchain." + strings.ToLower(*format)
+		if *targetsp != "" {
+			*out = "./certchain-report." + strings.ToLower(*report)
+		}
+	}
+
+	var roots *x509.CertPool
+	if *rootsp != "" {
+		rb, err := certificate.ParseFile(*rootsp, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading roots: %v\n", err)
+			os.Exit(1)
+		}
+		roots = x509.NewCertPool()
+		for _, c := range rb.Chain {
+			roots.AddCert(c)
+		}
+	}
+
+	f := &certchain.Fetcher{Protocol: proto, Timeout: *timeout, ServerName: *sni}
+	err = configure(f, *proxyURL, *clientcert, *clientkey, *clientpassfile, *minver, *maxver)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var aiaClient *http.Client
+	if *aia {
+		aiaClient = &http.Client{Timeout: *aiatimeout}
+	}
+
+	if *targetsp != "" {
+		scan(f, roots, aiaClient, *targetsp, *workers, *report, *out)
+		return
+	}
+
+	var certs []*x509.Certificate
+	if *in != "" {
+		var b *certificate.Bundle
+		b, err = certificate.ParseFile(*in, "")
+		if b != nil {
+			certs = b.Chain
+		}
+	} else {
+		certs, err = f.Certificates(context.Background(), net.JoinHostPort(*fqdn, strconv.Itoa(*port)))
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error writing certificate chain: %v\n", err)
+		fmt.Fprintf(os.Stderr, "error getting certificate chain: %v\n", err)
 		os.Exit(1)
 	}
-	if n < 1 {
-		fmt.Fprintln(os.Stderr, "no bytes written to output file")
+	if len(certs) < 1 {
+		fmt.Fprintln(os.Stderr, "no certificates found")
 		os.Exit(1)
 	}
+
+	if aiaClient != nil {
+		a := certchain.NewAIA(aiaClient)
+		a.Roots = roots
+		certs, err = a.Complete(certs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error completing certificate chain: %v\n", err)
+		}
+	}
+
+	var problems bool
+	if *verify {
+		host := *fqdn
+		if *sni != "" {
+			host = *sni
+		}
+		res := certchain.VerifyCertificates(certs, host, roots, time.Now())
+		for _, p := range res.Problems {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		problems = len(res.Problems) > 0
+		certs = res.Chain
+	}
+
+	var b []byte
+	if strings.ToLower(*format) == "pem" {
+		for _, c := range certs {
+			b = append(b, certificate.PEMEncode(c)...)
+		}
+	} else {
+		f, err := certificate.ParseTrustStoreFormat(*format)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		b, err = certificate.EncodeTrustStore(certs, f, *storepass, rand.Reader)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error creating trust store: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	err = ioutil.WriteFile(*out, b, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing output file: %v\n", err)
+		os.Exit(1)
+	}
+	if problems {
+		os.Exit(2)
+	}
+}
+
+// scan fetches and verifies the chains of the endpoints listed in the targets file and writes a report of them.
+func scan(f *certchain.Fetcher, roots *x509.CertPool, aiaClient *http.Client, targetsp string, workers int, format, out string) {
+	var r io.Reader = os.Stdin
+	if targetsp != "-" {
+		tf, err := os.Open(targetsp)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error opening targets file: %v\n", err)
+			os.Exit(1)
+		}
+		defer tf.Close()
+		r = tf
+	}
+	targets, err := certchain.ReadTargets(r, f.Protocol)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	s := &certchain.Scanner{Fetcher: *f, Roots: roots, Workers: workers}
+	if aiaClient != nil {
+		s.AIA = certchain.NewAIA(aiaClient)
+		s.AIA.Roots = roots
+	}
+	reports := s.Scan(context.Background(), targets)
+
+	var b bytes.Buffer
+	switch strings.ToLower(format) {
+	case "json":
+		err = certchain.WriteJSON(reports, &b)
+	case "csv":
+		err = certchain.WriteCSV(reports, &b)
+	default:
+		err = fmt.Errorf("unknown report format: %s", format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(out, b.Bytes(), 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report file: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// configure sets the proxy, client certificate and TLS version options of the fetcher from the flag values.
+func configure(f *certchain.Fetcher, proxyURL, clientcert, clientkey, passfile, minver, maxver string) error {
+	var err error
+	if proxyURL != "" {
+		f.Proxy, err = url.Parse(proxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %v", err)
+		}
+	}
+	if minver != "" {
+		f.MinVersion, err = certchain.ParseTLSVersion(minver)
+		if err != nil {
+			return err
+		}
+	}
+	if maxver != "" {
+		f.MaxVersion, err = certchain.ParseTLSVersion(maxver)
+		if err != nil {
+			return err
+		}
+	}
+	if clientcert == "" {
+		return nil
+	}
+	crt, err := loadBundle(clientcert, passfile)
+	if err != nil {
+		return fmt.Errorf("could not load client certificate: %v", err)
+	}
+	if clientkey != "" {
+		kb, err := loadBundle(clientkey, passfile)
+		if err != nil {
+			return fmt.Errorf("could not load client certificate key: %v", err)
+		}
+		crt.Key = kb.Key
+	}
+	if crt.Leaf() == nil || crt.Key == nil {
+		return errors.New("client certificate and private key required")
+	}
+	tc := tls.Certificate{PrivateKey: crt.Key, Leaf: crt.Leaf()}
+	for _, c := range crt.Chain {
+		tc.Certificate = append(tc.Certificate, c.Raw)
+	}
+	f.ClientCertificates = []tls.Certificate{tc}
+	return nil
+}
+
+// loadBundle loads the file, prompting for the passphrase if it holds an encrypted key and no passphrase file is given.
+func loadBundle(path, passfile string) (*certificate.Bundle, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var passphrase string
+	if certificate.IsEncryptedKey(b) {
+		passphrase, err = certificate.ReadPassphrase(passfile, "Client certificate key passphrase: ", false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return certificate.Parse(b, passphrase)
 }
@@ -0,0 +1,225 @@
+package certchain
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// DefaultTimeout is the timeout applied by the package level functions to fetching a certificate chain.
+const DefaultTimeout = 30 * time.Second
+
+// Dialer establishes network connections. It is satisfied by *net.Dialer.
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Fetcher fetches the certificate chains presented by endpoints.
+// The certificates are not verified while connecting.
+type Fetcher struct {
+	// Protocol determines any STARTTLS negotiation performed before the TLS handshake.
+	Protocol Protocol
+	// Timeout covers connecting, protocol negotiation and the TLS handshake. If zero only the context limits the fetch.
+	Timeout time.Duration
+	// ServerName overrides the server name indication (SNI) sent, which by default is the host of the address.
+	ServerName string
+	// Proxy to connect through. The http scheme uses HTTP CONNECT and the socks5 scheme SOCKS5. User credentials in the
+	// URL are used to authenticate to the proxy.
+	Proxy *url.URL
+	// ClientCertificates are presented if the endpoint requests a client certificate.
+	ClientCertificates []tls.Certificate
+	// MinVersion and MaxVersion of TLS to negotiate. If zero the crypto/tls defaults apply.
+	MinVersion uint16
+	MaxVersion uint16
+	// Dialer connects to the endpoint or proxy. If nil a net.Dialer is used.
+	Dialer Dialer
+}
+
+// Certificates returns the certificate chain presented by the endpoint.
+// The address must be in the form <fqdn>:<port>
+func (f *Fetcher) Certificates(ctx context.Context, addr string) ([]*x509.Certificate, error) {
+	cs, err := f.ConnectionState(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return cs.PeerCertificates, nil
+}
+
+// ConnectionState returns the state of a TLS connection to the endpoint, which includes the certificate chain
+// presented and the version and cipher suite negotiated. The connection is closed before returning.
+// The address must be in the form <fqdn>:<port>
+func (f *Fetcher) ConnectionState(ctx context.Context, addr string) (tls.ConnectionState, error) {
+	var cs tls.ConnectionState
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return cs, err
+	}
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+	c, err := f.dial(ctx, addr)
+	if err != nil {
+		return cs, err
+	}
+	defer c.Close()
+	// Bound the protocol negotiation, which does not take the context, by the context.
+	if d, ok := ctx.Deadline(); ok {
+		c.SetDeadline(d)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	// The upgraded connection is kept separate as the goroutine above reads c.
+	sc, err := starttls(c, f.Protocol, host)
+	if err != nil {
+		return cs, err
+	}
+	sni := f.ServerName
+	if sni == "" {
+		sni = host
+	}
+	tc := tls.Client(sc, &tls.Config{
+		ServerName:         sni,
+		InsecureSkipVerify: true,
+		Certificates:       f.ClientCertificates,
+		MinVersion:         f.MinVersion,
+		MaxVersion:         f.MaxVersion,
+	})
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return cs, fmt.Errorf("TLS handshake failed: %v", err)
+	}
+	return tc.ConnectionState(), nil
+}
+
+// dial connects to the address directly or through the proxy.
+func (f *Fetcher) dial(ctx context.Context, addr string) (net.Conn, error) {
+	d := f.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	if f.Proxy == nil {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	switch f.Proxy.Scheme {
+	case "http":
+		return httpConnect(ctx, d, f.Proxy, addr)
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if f.Proxy.User != nil {
+			p, _ := f.Proxy.User.Password()
+			auth = &proxy.Auth{User: f.Proxy.User.Username(), Password: p}
+		}
+		host := f.Proxy.Host
+		if f.Proxy.Port() == "" {
+			host = net.JoinHostPort(f.Proxy.Hostname(), "1080")
+		}
+		pd, err := proxy.SOCKS5("tcp", host, auth, forwardDialer{d})
+		if err != nil {
+			return nil, err
+		}
+		c, err := pd.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect through SOCKS5 proxy: %v", err)
+		}
+		return c, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", f.Proxy.Scheme)
+	}
+}
+
+// httpConnect connects to the address through the HTTP proxy using the CONNECT method.
+func httpConnect(ctx context.Context, d Dialer, u *url.URL, addr string) (net.Conn, error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+	c, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to proxy: %v", err)
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		c.SetDeadline(dl)
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		p, _ := u.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+p)))
+	}
+	if err := req.Write(c); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("could not send proxy CONNECT request: %v", err)
+	}
+	r := bufio.NewReader(c)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("could not read proxy CONNECT response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.Close()
+		return nil, fmt.Errorf("proxy CONNECT failed: %s", resp.Status)
+	}
+	c.SetDeadline(time.Time{})
+	// The endpoint may already have sent a greeting, such as for SMTP, which will be buffered.
+	return bufferedConn{c, r}, nil
+}
+
+// forwardDialer adapts a Dialer for use by the SOCKS5 proxy dialer.
+type forwardDialer struct {
+	Dialer
+}
+
+func (d forwardDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// bufferedConn reads through the buffered reader so that data read ahead by it is not lost.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion returns the TLS version for the name provided (1.0, 1.1, 1.2 or 1.3).
+func ParseTLSVersion(s string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(s), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version: %s", s)
+	}
+	return v, nil
+}
@@ -0,0 +1,258 @@
+package certchain
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func noNegotiation(r *bufio.Reader, w io.Writer) error { return nil }
+
+func testServerCert(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	root, rootKey := issue(t, "Root CA", true, "", nil, nil)
+	leaf, leafKey := issue(t, "host.test.local", false, "", root, rootKey)
+	return tls.Certificate{Certificate: [][]byte{leaf.Raw, root.Raw}, PrivateKey: leafKey}, leaf
+}
+
+// pipe copies between the connections until either closes.
+func pipe(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	io.Copy(b, a)
+	b.Close()
+}
+
+// httpProxy runs an HTTP CONNECT proxy that requires the basic credentials provided.
+func httpProxy(t *testing.T, user, pass string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				req, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil || req.Method != http.MethodConnect {
+					c.Close()
+					return
+				}
+				req.Header.Set("Authorization", req.Header.Get("Proxy-Authorization"))
+				if u, p, ok := req.BasicAuth(); !ok || u != user || p != pass {
+					io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					c.Close()
+					return
+				}
+				d, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					c.Close()
+					return
+				}
+				io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+				pipe(c, d)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// socks5Proxy runs a SOCKS5 proxy without authentication that supports CONNECT to IPv4 addresses and domain names.
+func socks5Proxy(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				r := bufio.NewReader(c)
+				hdr := make([]byte, 2)
+				io.ReadFull(r, hdr)
+				io.ReadFull(r, make([]byte, hdr[1]))
+				c.Write([]byte{5, 0})
+				req := make([]byte, 4)
+				io.ReadFull(r, req)
+				var host string
+				switch req[3] {
+				case 1:
+					ip := make([]byte, 4)
+					io.ReadFull(r, ip)
+					host = net.IP(ip).String()
+				case 3:
+					n, _ := r.ReadByte()
+					name := make([]byte, n)
+					io.ReadFull(r, name)
+					host = string(name)
+				}
+				port := make([]byte, 2)
+				io.ReadFull(r, port)
+				d, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+				if err != nil {
+					c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					c.Close()
+					return
+				}
+				c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				pipe(c, d)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestFetcher_ServerName(t *testing.T) {
+	crt, leaf := testServerCert(t)
+	sni := make(chan string, 1)
+	addr := fakeServer(t, &tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		sni <- hello.ServerName
+		return &crt, nil
+	}}, noNegotiation)
+	f := &Fetcher{ServerName: "sni.test.local"}
+	certs, err := f.Certificates(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("error getting certificates: %v", err)
+	}
+	assert.True(t, certs[0].Equal(leaf), "leaf certificate not as expected")
+	assert.Equal(t, "sni.test.local", <-sni, "server name not as expected")
+}
+
+func TestFetcher_Timeout(t *testing.T) {
+	// A server that accepts connections but never sends an SMTP greeting.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+
+	f := &Fetcher{Protocol: ProtocolSMTP, Timeout: time.Millisecond * 200}
+	start := time.Now()
+	_, err = f.Certificates(context.Background(), l.Addr().String())
+	assert.Error(t, err, "fetch should time out")
+	assert.True(t, time.Since(start) < time.Second*5, "timeout not applied")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		cancel()
+	}()
+	start = time.Now()
+	_, err = (&Fetcher{Protocol: ProtocolSMTP}).Certificates(ctx, l.Addr().String())
+	assert.Error(t, err, "fetch should be cancelled")
+	assert.True(t, time.Since(start) < time.Second*5, "cancellation not applied")
+}
+
+func TestFetcher_Proxy(t *testing.T) {
+	crt, leaf := testServerCert(t)
+	hp := httpProxy(t, "user", "secret")
+	sp := socks5Proxy(t)
+
+	var tests = []struct {
+		name  string
+		proxy string
+		proto Protocol
+		err   bool
+	}{
+		{"HTTP CONNECT", "http://user:secret@" + hp, ProtocolTLS, false},
+		// The SMTP greeting arrives with the CONNECT response so must not be lost.
+		{"HTTP CONNECT STARTTLS", "http://user:secret@" + hp, ProtocolSMTP, false},
+		{"HTTP CONNECT bad credentials", "http://user:wrong@" + hp, ProtocolTLS, true},
+		{"SOCKS5", "socks5://" + sp, ProtocolTLS, false},
+		{"SOCKS5 STARTTLS", "socks5://" + sp, ProtocolSMTP, false},
+	}
+	for _, test := range tests {
+		negotiate := noNegotiation
+		if test.proto == ProtocolSMTP {
+			negotiate = smtpServer
+		}
+		addr := fakeServer(t, &tls.Config{Certificates: []tls.Certificate{crt}}, negotiate)
+		u, _ := url.Parse(test.proxy)
+		f := &Fetcher{Protocol: test.proto, Proxy: u, Timeout: time.Second * 5}
+		certs, err := f.Certificates(context.Background(), addr)
+		if test.err {
+			assert.Error(t, err, "%s: expected error", test.name)
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error getting certificates: %v", test.name, err)
+			continue
+		}
+		assert.True(t, certs[0].Equal(leaf), "%s: leaf certificate not as expected", test.name)
+	}
+}
+
+func TestFetcher_ClientCertificate(t *testing.T) {
+	crt, _ := testServerCert(t)
+	client, clientKey := issue(t, "client.test.local", false, "", nil, nil)
+	got := make(chan []byte, 1)
+	addr := fakeServer(t, &tls.Config{
+		Certificates: []tls.Certificate{crt},
+		ClientAuth:   tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			got <- raw[0]
+			return nil
+		},
+	}, noNegotiation)
+	f := &Fetcher{ClientCertificates: []tls.Certificate{{Certificate: [][]byte{client.Raw}, PrivateKey: clientKey}}}
+	_, err := f.Certificates(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("error getting certificates: %v", err)
+	}
+	assert.Equal(t, client.Raw, <-got, "client certificate not presented")
+}
+
+func TestFetcher_Version(t *testing.T) {
+	crt, _ := testServerCert(t)
+	cfg := &tls.Config{Certificates: []tls.Certificate{crt}, MaxVersion: tls.VersionTLS12}
+
+	cs, err := (&Fetcher{}).ConnectionState(context.Background(), fakeServer(t, cfg, noNegotiation))
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	assert.Equal(t, uint16(tls.VersionTLS12), cs.Version, "TLS version not as expected")
+
+	_, err = (&Fetcher{MinVersion: tls.VersionTLS13}).ConnectionState(context.Background(), fakeServer(t, cfg, noNegotiation))
+	assert.Error(t, err, "connecting below the minimum version should error")
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	var tests = []struct {
+		s    string
+		want uint16
+	}{
+		{"1.0", tls.VersionTLS10},
+		{"1.2", tls.VersionTLS12},
+		{"TLS1.3", tls.VersionTLS13},
+	}
+	for _, test := range tests {
+		v, err := ParseTLSVersion(test.s)
+		if err != nil {
+			t.Errorf("error parsing %s: %v", test.s, err)
+		}
+		assert.Equal(t, test.want, v, "version not as expected for %s", test.s)
+	}
+	_, err := ParseTLSVersion("SSL3")
+	assert.Error(t, err, "unknown version should error")
+}
@@ -0,0 +1,246 @@
+package certchain
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWorkers is the default number of targets scanned concurrently.
+const DefaultWorkers = 10
+
+// Target is an endpoint to scan.
+type Target struct {
+	// Addr in the form <fqdn>:<port>
+	Addr     string
+	Protocol Protocol
+}
+
+func (t Target) String() string {
+	if t.Protocol == ProtocolTLS {
+		return t.Addr
+	}
+	return t.Protocol.String() + "://" + t.Addr
+}
+
+// ParseTarget parses a target of the form [<protocol>://]<fqdn>[:<port>]. If not specified the protocol is that
+// provided and the port is the protocol's well known port.
+func ParseTarget(s string, proto Protocol) (Target, error) {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, "://"); i >= 0 {
+		p, err := ParseProtocol(s[:i])
+		if err != nil {
+			return Target{}, err
+		}
+		proto, s = p, s[i+3:]
+	}
+	if s == "" {
+		return Target{}, errors.New("target has no host")
+	}
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		s = net.JoinHostPort(strings.Trim(s, "[]"), strconv.Itoa(proto.DefaultPort()))
+	}
+	return Target{Addr: s, Protocol: proto}, nil
+}
+
+// ReadTargets reads targets, one per line, in the form accepted by ParseTarget. Blank lines and lines starting with #
+// are ignored.
+func ReadTargets(r io.Reader, proto Protocol) ([]Target, error) {
+	var targets []Target
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		t, err := ParseTarget(l, proto)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target on line %d: %v", n, err)
+		}
+		targets = append(targets, t)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("could not read targets: %v", err)
+	}
+	return targets, nil
+}
+
+// Report is the result of scanning a target. The certificate details are those of the leaf and are not set if the
+// scan errored.
+type Report struct {
+	Target             string     `json:"target"`
+	Error              string     `json:"error,omitempty"`
+	Subject            string     `json:"subject,omitempty"`
+	Issuer             string     `json:"issuer,omitempty"`
+	SANs               []string   `json:"sans,omitempty"`
+	KeyType            string     `json:"key_type,omitempty"`
+	KeySize            int        `json:"key_size,omitempty"`
+	SignatureAlgorithm string     `json:"signature_algorithm,omitempty"`
+	NotAfter           *time.Time `json:"not_after,omitempty"`
+	DaysToExpiry       *int       `json:"days_to_expiry,omitempty"`
+	ChainLength        int        `json:"chain_length"`
+	Verified           bool       `json:"verified"`
+	Problems           []string   `json:"problems,omitempty"`
+}
+
+// Scanner fetches and verifies the certificate chains of many targets concurrently.
+type Scanner struct {
+	// Fetcher holds the options used to connect to the targets. Its protocol is replaced by that of each target.
+	Fetcher Fetcher
+	// Roots to verify against. If nil the system roots are used.
+	Roots *x509.CertPool
+	// AIA, if not nil, is used to complete chains missing intermediates before verifying.
+	AIA *AIA
+	// Workers is the number of targets scanned concurrently. If zero DefaultWorkers is used.
+	Workers int
+}
+
+// Scan returns a report for each target in the order provided.
+func (s *Scanner) Scan(ctx context.Context, targets []Target) []Report {
+	workers := s.Workers
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+	reports := make([]Report, len(targets))
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				reports[i] = s.scan(ctx, targets[i])
+			}
+		}()
+	}
+	for i := range targets {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+	return reports
+}
+
+func (s *Scanner) scan(ctx context.Context, t Target) Report {
+	r := Report{Target: t.String()}
+	f := s.Fetcher
+	f.Protocol = t.Protocol
+	certs, err := f.Certificates(ctx, t.Addr)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	if s.AIA != nil {
+		// A chain that cannot be completed is reported by verification.
+		certs, _ = s.AIA.Complete(certs)
+	}
+	host := f.ServerName
+	if host == "" {
+		host, _, _ = net.SplitHostPort(t.Addr)
+	}
+	now := time.Now()
+	res := VerifyCertificates(certs, host, s.Roots, now)
+	if len(res.Chain) < 1 {
+		r.Error = "no certificates presented"
+		return r
+	}
+	leaf := res.Chain[0]
+	r.Subject = leaf.Subject.String()
+	r.Issuer = leaf.Issuer.String()
+	r.SANs = sans(leaf)
+	r.KeyType, r.KeySize = keyInfo(leaf.PublicKey)
+	r.SignatureAlgorithm = leaf.SignatureAlgorithm.String()
+	days := int(math.Floor(leaf.NotAfter.Sub(now).Hours() / 24))
+	r.NotAfter, r.DaysToExpiry = &leaf.NotAfter, &days
+	r.ChainLength = len(res.Chain)
+	r.Verified = res.Verified
+	for _, p := range res.Problems {
+		r.Problems = append(r.Problems, p.String())
+	}
+	return r
+}
+
+func sans(c *x509.Certificate) []string {
+	s := append([]string{}, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		s = append(s, ip.String())
+	}
+	s = append(s, c.EmailAddresses...)
+	for _, u := range c.URIs {
+		s = append(s, u.String())
+	}
+	return s
+}
+
+// keyInfo returns the type and size in bits of the public key.
+func keyInfo(pub interface{}) (string, int) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	default:
+		return fmt.Sprintf("%T", pub), 0
+	}
+}
+
+// WriteJSON writes the reports as a JSON array.
+func WriteJSON(reports []Report, w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(reports)
+}
+
+var csvHeader = []string{"target", "error", "subject", "issuer", "sans", "key_type", "key_size", "signature_algorithm",
+	"not_after", "days_to_expiry", "chain_length", "verified", "problems"}
+
+// WriteCSV writes the reports as CSV with a header row. Multiple SANs and problems are separated by semicolons.
+func WriteCSV(reports []Report, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, r := range reports {
+		var notAfter, days, size string
+		if r.NotAfter != nil {
+			notAfter = r.NotAfter.UTC().Format(time.RFC3339)
+		}
+		if r.DaysToExpiry != nil {
+			days = strconv.Itoa(*r.DaysToExpiry)
+		}
+		if r.Error == "" {
+			size = strconv.Itoa(r.KeySize)
+		}
+		cw.Write([]string{
+			r.Target,
+			r.Error,
+			r.Subject,
+			r.Issuer,
+			strings.Join(r.SANs, ";"),
+			r.KeyType,
+			size,
+			r.SignatureAlgorithm,
+			notAfter,
+			days,
+			strconv.Itoa(r.ChainLength),
+			strconv.FormatBool(r.Verified),
+			strings.Join(r.Problems, ";"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,122 @@
+package certchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadTargets(t *testing.T) {
+	in := `# endpoints
+www.test.local
+www.test.local:8443
+
+smtp://mail.test.local
+ldap://[::1]:1389
+10.0.0.1
+`
+	targets, err := ReadTargets(strings.NewReader(in), ProtocolTLS)
+	if err != nil {
+		t.Fatalf("error reading targets: %v", err)
+	}
+	assert.Equal(t, []Target{
+		{"www.test.local:443", ProtocolTLS},
+		{"www.test.local:8443", ProtocolTLS},
+		{"mail.test.local:25", ProtocolSMTP},
+		{"[::1]:1389", ProtocolLDAP},
+		{"10.0.0.1:443", ProtocolTLS},
+	}, targets, "targets not as expected")
+	assert.Equal(t, "smtp://mail.test.local:25", targets[2].String(), "target string not as expected")
+
+	_, err = ReadTargets(strings.NewReader("gopher://host.test.local\n"), ProtocolTLS)
+	assert.Error(t, err, "unknown protocol should error")
+}
+
+func TestScanner_Scan(t *testing.T) {
+	crt, leaf := testServerCert(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(leafIssuer(t, crt))
+	cfg := &tls.Config{Certificates: []tls.Certificate{crt}}
+
+	// A port with nothing listening.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	targets := []Target{
+		{fakeServer(t, cfg, noNegotiation), ProtocolTLS},
+		{closed, ProtocolTLS},
+		{fakeServer(t, cfg, smtpServer), ProtocolSMTP},
+	}
+	s := &Scanner{Fetcher: Fetcher{ServerName: "host.test.local", Timeout: time.Second * 5}, Roots: roots, Workers: 2}
+	reports := s.Scan(context.Background(), targets)
+	if !assert.Len(t, reports, 3, "number of reports not as expected") {
+		t.FailNow()
+	}
+	for _, i := range []int{0, 2} {
+		r := reports[i]
+		assert.Equal(t, targets[i].String(), r.Target, "target not as expected")
+		assert.Empty(t, r.Error, "%s: unexpected error", r.Target)
+		assert.Equal(t, leaf.Subject.String(), r.Subject, "%s: subject not as expected", r.Target)
+		assert.Equal(t, "CN=Root CA", r.Issuer, "%s: issuer not as expected", r.Target)
+		assert.Equal(t, []string{"host.test.local"}, r.SANs, "%s: SANs not as expected", r.Target)
+		assert.Equal(t, "ECDSA", r.KeyType, "%s: key type not as expected", r.Target)
+		assert.Equal(t, 256, r.KeySize, "%s: key size not as expected", r.Target)
+		assert.Equal(t, "ECDSA-SHA256", r.SignatureAlgorithm, "%s: signature algorithm not as expected", r.Target)
+		if assert.NotNil(t, r.DaysToExpiry, "%s: days to expiry not set", r.Target) {
+			assert.Equal(t, 0, *r.DaysToExpiry, "%s: days to expiry not as expected", r.Target)
+		}
+		assert.Equal(t, 2, r.ChainLength, "%s: chain length not as expected", r.Target)
+		assert.True(t, r.Verified, "%s: should be verified: %v", r.Target, r.Problems)
+	}
+	assert.NotEmpty(t, reports[1].Error, "unreachable target should report an error")
+
+	var b bytes.Buffer
+	if err := WriteJSON(reports, &b); err != nil {
+		t.Fatalf("error writing JSON: %v", err)
+	}
+	var decoded []Report
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatalf("error decoding JSON report: %v", err)
+	}
+	assert.Equal(t, reports[0].Subject, decoded[0].Subject, "JSON report not as expected")
+	var fields []map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &fields); err != nil {
+		t.Fatalf("error decoding JSON report: %v", err)
+	}
+	assert.NotContains(t, fields[1], "not_after", "JSON report of an error should omit the expiry")
+	assert.NotContains(t, fields[1], "days_to_expiry", "JSON report of an error should omit the days to expiry")
+
+	b.Reset()
+	if err := WriteCSV(reports, &b); err != nil {
+		t.Fatalf("error writing CSV: %v", err)
+	}
+	rows, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatalf("error reading CSV report: %v", err)
+	}
+	assert.Len(t, rows, 4, "CSV should have a header and a row per target")
+	assert.Equal(t, csvHeader, rows[0], "CSV header not as expected")
+	assert.Equal(t, "true", rows[1][11], "CSV verified column not as expected")
+	assert.Equal(t, "", rows[2][9], "CSV days to expiry should be empty for errors")
+}
+
+func leafIssuer(t *testing.T, crt tls.Certificate) *x509.Certificate {
+	c, err := x509.ParseCertificate(crt.Certificate[len(crt.Certificate)-1])
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	return c
+}
@@ -0,0 +1,340 @@
+package certchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Protocol spoken by the endpoint before the TLS handshake.
+type Protocol int
+
+const (
+	// ProtocolTLS is a direct TLS connection.
+	ProtocolTLS Protocol = iota
+	ProtocolSMTP
+	ProtocolIMAP
+	ProtocolPOP3
+	ProtocolLDAP
+	ProtocolPostgreSQL
+	ProtocolMySQL
+	ProtocolXMPP
+)
+
+var protocolNames = map[Protocol]string{
+	ProtocolTLS:        "tls",
+	ProtocolSMTP:       "smtp",
+	ProtocolIMAP:       "imap",
+	ProtocolPOP3:       "pop3",
+	ProtocolLDAP:       "ldap",
+	ProtocolPostgreSQL: "postgres",
+	ProtocolMySQL:      "mysql",
+	ProtocolXMPP:       "xmpp",
+}
+
+var protocolPorts = map[Protocol]int{
+	ProtocolTLS:        443,
+	ProtocolSMTP:       25,
+	ProtocolIMAP:       143,
+	ProtocolPOP3:       110,
+	ProtocolLDAP:       389,
+	ProtocolPostgreSQL: 5432,
+	ProtocolMySQL:      3306,
+	ProtocolXMPP:       5222,
+}
+
+func (p Protocol) String() string {
+	if s, ok := protocolNames[p]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// DefaultPort returns the well known port of the protocol.
+func (p Protocol) DefaultPort() int {
+	return protocolPorts[p]
+}
+
+// ParseProtocol returns the protocol for the name provided (tls, smtp, imap, pop3, ldap, postgres, mysql or xmpp).
+func ParseProtocol(s string) (Protocol, error) {
+	s = strings.ToLower(s)
+	if s == "postgresql" {
+		s = "postgres"
+	}
+	for p, n := range protocolNames {
+		if n == s {
+			return p, nil
+		}
+	}
+	return ProtocolTLS, fmt.Errorf("unknown protocol: %s", s)
+}
+
+// starttls performs the protocol specific negotiation on the connection to request an upgrade to TLS. The connection
+// returned should be used for the TLS handshake.
+func starttls(c net.Conn, proto Protocol, host string) (net.Conn, error) {
+	if proto == ProtocolTLS {
+		return c, nil
+	}
+	r := bufio.NewReader(c)
+	var err error
+	switch proto {
+	case ProtocolSMTP:
+		err = smtpStartTLS(r, c)
+	case ProtocolIMAP:
+		err = imapStartTLS(r, c)
+	case ProtocolPOP3:
+		err = pop3StartTLS(r, c)
+	case ProtocolLDAP:
+		err = ldapStartTLS(r, c)
+	case ProtocolPostgreSQL:
+		err = postgresStartTLS(r, c)
+	case ProtocolMySQL:
+		err = mysqlStartTLS(r, c)
+	case ProtocolXMPP:
+		err = xmppStartTLS(r, c, host)
+	default:
+		err = fmt.Errorf("unsupported protocol: %d", proto)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bufferedConn{c, r}, nil
+}
+
+// smtpReply reads a possibly multi-line SMTP reply and returns its code.
+func smtpReply(r *bufio.Reader) (string, error) {
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("could not read SMTP reply: %v", err)
+		}
+		if len(l) < 4 {
+			return "", fmt.Errorf("invalid SMTP reply: %q", l)
+		}
+		if l[3] != '-' {
+			return l[:3], nil
+		}
+	}
+}
+
+func smtpStartTLS(r *bufio.Reader, w io.Writer) error {
+	if code, err := smtpReply(r); err != nil || code != "220" {
+		return fmt.Errorf("SMTP greeting not accepted: %s %v", code, err)
+	}
+	fmt.Fprint(w, "EHLO certchain\r\n")
+	if code, err := smtpReply(r); err != nil || code != "250" {
+		return fmt.Errorf("SMTP EHLO not accepted: %s %v", code, err)
+	}
+	fmt.Fprint(w, "STARTTLS\r\n")
+	if code, err := smtpReply(r); err != nil || code != "220" {
+		return fmt.Errorf("SMTP STARTTLS not accepted: %s %v", code, err)
+	}
+	return nil
+}
+
+func imapStartTLS(r *bufio.Reader, w io.Writer) error {
+	l, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(l, "* OK") {
+		return fmt.Errorf("IMAP greeting not accepted: %q %v", l, err)
+	}
+	fmt.Fprint(w, "a001 STARTTLS\r\n")
+	for {
+		l, err = r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("could not read IMAP response: %v", err)
+		}
+		if strings.HasPrefix(l, "a001 ") {
+			break
+		}
+	}
+	if !strings.HasPrefix(l, "a001 OK") {
+		return fmt.Errorf("IMAP STARTTLS not accepted: %q", l)
+	}
+	return nil
+}
+
+func pop3StartTLS(r *bufio.Reader, w io.Writer) error {
+	l, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(l, "+OK") {
+		return fmt.Errorf("POP3 greeting not accepted: %q %v", l, err)
+	}
+	fmt.Fprint(w, "STLS\r\n")
+	l, err = r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(l, "+OK") {
+		return fmt.Errorf("POP3 STLS not accepted: %q %v", l, err)
+	}
+	return nil
+}
+
+// ldapStartTLSOID is the name of the StartTLS extended operation of RFC 4511 section 4.14.
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+func ldapStartTLS(r *bufio.Reader, w io.Writer) error {
+	// LDAPMessage { messageID 1, ExtendedRequest [APPLICATION 23] { requestName [0] ldapStartTLSOID } }
+	name, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: []byte(ldapStartTLSOID)})
+	req, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 23, IsCompound: true, Bytes: name})
+	msg, _ := asn1.Marshal(struct {
+		ID  int
+		Req asn1.RawValue
+	}{1, asn1.RawValue{FullBytes: req}})
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("could not send LDAP StartTLS request: %v", err)
+	}
+	b, err := readBER(r)
+	if err != nil {
+		return fmt.Errorf("could not read LDAP response: %v", err)
+	}
+	var resp struct {
+		ID   int
+		Resp asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(b, &resp); err != nil {
+		return fmt.Errorf("could not parse LDAP response: %v", err)
+	}
+	if resp.Resp.Class != asn1.ClassApplication || resp.Resp.Tag != 24 {
+		return fmt.Errorf("unexpected LDAP response type: %d", resp.Resp.Tag)
+	}
+	var code asn1.Enumerated
+	if _, err := asn1.Unmarshal(resp.Resp.Bytes, &code); err != nil {
+		return fmt.Errorf("could not parse LDAP result code: %v", err)
+	}
+	if code != 0 {
+		return fmt.Errorf("LDAP StartTLS not accepted: result code %d", code)
+	}
+	return nil
+}
+
+// readBER reads a single definite length BER element.
+func readBER(r *bufio.Reader) ([]byte, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	l := int(hdr[1])
+	if l&0x80 != 0 {
+		n := l & 0x7f
+		if n == 0 || n > 4 {
+			return nil, errors.New("unsupported BER length")
+		}
+		lb := make([]byte, n)
+		if _, err := io.ReadFull(r, lb); err != nil {
+			return nil, err
+		}
+		hdr = append(hdr, lb...)
+		l = 0
+		for _, b := range lb {
+			l = l<<8 | int(b)
+		}
+	}
+	if l > 1<<20 {
+		return nil, errors.New("BER element too large")
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return append(hdr, b...), nil
+}
+
+// postgresSSLRequest is the request code of the PostgreSQL SSLRequest message.
+const postgresSSLRequest = 80877103
+
+func postgresStartTLS(r *bufio.Reader, w io.Writer) error {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint32(msg, 8)
+	binary.BigEndian.PutUint32(msg[4:], postgresSSLRequest)
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("could not send PostgreSQL SSLRequest: %v", err)
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("could not read PostgreSQL response: %v", err)
+	}
+	if b != 'S' {
+		return errors.New("PostgreSQL server does not support SSL")
+	}
+	return nil
+}
+
+const (
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSSL              = 0x00000800
+	mysqlClientSecureConnection = 0x00008000
+)
+
+func mysqlStartTLS(r *bufio.Reader, w io.Writer) error {
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return fmt.Errorf("could not read MySQL handshake: %v", err)
+	}
+	n := int(hdr[0]) | int(hdr[1])<<8 | int(hdr[2])<<16
+	p := make([]byte, n)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return fmt.Errorf("could not read MySQL handshake: %v", err)
+	}
+	if len(p) < 1 || p[0] != 10 {
+		return errors.New("unsupported MySQL handshake")
+	}
+	// protocol version, null terminated server version, connection id (4), auth data (8), filler (1), capabilities (2)
+	i := bytes.IndexByte(p[1:], 0)
+	if i < 0 || len(p) < 1+i+1+4+8+1+2 {
+		return errors.New("invalid MySQL handshake")
+	}
+	off := 1 + i + 1 + 4 + 8 + 1
+	if binary.LittleEndian.Uint16(p[off:])&mysqlClientSSL == 0 {
+		return errors.New("MySQL server does not support SSL")
+	}
+	// SSLRequest: capabilities (4), max packet size (4), character set (1), reserved (23)
+	req := make([]byte, 4+32)
+	req[0], req[3] = 32, 1
+	binary.LittleEndian.PutUint32(req[4:], mysqlClientProtocol41|mysqlClientSSL|mysqlClientSecureConnection)
+	binary.LittleEndian.PutUint32(req[8:], 1<<24)
+	req[12] = 33 // utf8_general_ci
+	if _, err := w.Write(req); err != nil {
+		return fmt.Errorf("could not send MySQL SSLRequest: %v", err)
+	}
+	return nil
+}
+
+func xmppStartTLS(r *bufio.Reader, w io.Writer, host string) error {
+	fmt.Fprintf(w, "<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' "+
+		"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", host)
+	features, err := readUntil(r, "</stream:features>")
+	if err != nil {
+		return fmt.Errorf("could not read XMPP stream features: %v", err)
+	}
+	if !strings.Contains(features, "<starttls") {
+		return errors.New("XMPP server does not offer STARTTLS")
+	}
+	fmt.Fprint(w, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+	resp, err := readUntil(r, "/>")
+	if err != nil {
+		return fmt.Errorf("could not read XMPP STARTTLS response: %v", err)
+	}
+	if !strings.Contains(resp, "<proceed") {
+		return fmt.Errorf("XMPP STARTTLS not accepted: %s", resp)
+	}
+	return nil
+}
+
+// readUntil reads from r until the delimiter is found, returning what was read.
+func readUntil(r *bufio.Reader, delim string) (string, error) {
+	var b strings.Builder
+	for b.Len() < 1<<16 {
+		c, err := r.ReadByte()
+		if err != nil {
+			return b.String(), err
+		}
+		b.WriteByte(c)
+		if strings.HasSuffix(b.String(), delim) {
+			return b.String(), nil
+		}
+	}
+	return b.String(), errors.New("response too large")
+}
@@ -0,0 +1,216 @@
+package certchain
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServer accepts a connection, performs the server side of the protocol negotiation and then the TLS handshake.
+func fakeServer(t *testing.T, cfg *tls.Config, negotiate func(r *bufio.Reader, w io.Writer) error) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		if err := negotiate(r, c); err != nil {
+			t.Errorf("server negotiation failed: %v", err)
+			return
+		}
+		tls.Server(bufferedConn{c, r}, cfg).Handshake()
+	}()
+	return l.Addr().String()
+}
+
+func expectLine(r *bufio.Reader, want string) error {
+	l, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(l) != want {
+		return fmt.Errorf("expected %q got %q", want, l)
+	}
+	return nil
+}
+
+func smtpServer(r *bufio.Reader, w io.Writer) error {
+	fmt.Fprint(w, "220-mail.test.local ESMTP\r\n220 ready\r\n")
+	if err := expectLine(r, "EHLO certchain"); err != nil {
+		return err
+	}
+	fmt.Fprint(w, "250-mail.test.local\r\n250-STARTTLS\r\n250 8BITMIME\r\n")
+	if err := expectLine(r, "STARTTLS"); err != nil {
+		return err
+	}
+	fmt.Fprint(w, "220 go ahead\r\n")
+	return nil
+}
+
+func imapServer(r *bufio.Reader, w io.Writer) error {
+	fmt.Fprint(w, "* OK [CAPABILITY IMAP4rev1 STARTTLS] ready\r\n")
+	if err := expectLine(r, "a001 STARTTLS"); err != nil {
+		return err
+	}
+	fmt.Fprint(w, "a001 OK Begin TLS negotiation now\r\n")
+	return nil
+}
+
+func pop3Server(r *bufio.Reader, w io.Writer) error {
+	fmt.Fprint(w, "+OK POP3 ready\r\n")
+	if err := expectLine(r, "STLS"); err != nil {
+		return err
+	}
+	fmt.Fprint(w, "+OK Begin TLS negotiation\r\n")
+	return nil
+}
+
+func ldapServer(r *bufio.Reader, w io.Writer) error {
+	b, err := readBER(r)
+	if err != nil {
+		return err
+	}
+	var req struct {
+		ID  int
+		Req asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(b, &req); err != nil {
+		return err
+	}
+	var name asn1.RawValue
+	asn1.Unmarshal(req.Req.Bytes, &name)
+	if req.Req.Tag != 23 || string(name.Bytes) != ldapStartTLSOID {
+		return fmt.Errorf("unexpected LDAP request: %x", b)
+	}
+	// ExtendedResponse [APPLICATION 24] { resultCode success, matchedDN "", diagnosticMessage "" }
+	result := []byte{0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00}
+	resp, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 24, IsCompound: true, Bytes: result})
+	msg, _ := asn1.Marshal(struct {
+		ID   int
+		Resp asn1.RawValue
+	}{req.ID, asn1.RawValue{FullBytes: resp}})
+	_, err = w.Write(msg)
+	return err
+}
+
+func postgresServer(r *bufio.Reader, w io.Writer) error {
+	msg := make([]byte, 8)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(msg[4:]) != postgresSSLRequest {
+		return fmt.Errorf("unexpected PostgreSQL request: %x", msg)
+	}
+	_, err := w.Write([]byte{'S'})
+	return err
+}
+
+func mysqlServer(r *bufio.Reader, w io.Writer) error {
+	p := []byte{10}
+	p = append(p, []byte("8.0.36\x00")...)
+	p = append(p, 1, 0, 0, 0)               // connection id
+	p = append(p, []byte("abcdefgh")...)    // auth plugin data part 1
+	p = append(p, 0)                        // filler
+	p = append(p, 0x00, 0x8a)               // capabilities: protocol 41, SSL, secure connection
+	p = append(p, 33, 2, 0, 0xff, 0xc1, 21) // character set, status, capabilities upper, auth data length
+	p = append(p, make([]byte, 10)...)
+	hdr := []byte{byte(len(p)), byte(len(p) >> 8), byte(len(p) >> 16), 0}
+	w.Write(append(hdr, p...))
+	req := make([]byte, 36)
+	if _, err := io.ReadFull(r, req); err != nil {
+		return err
+	}
+	if req[3] != 1 || binary.LittleEndian.Uint32(req[4:])&mysqlClientSSL == 0 {
+		return fmt.Errorf("unexpected MySQL SSLRequest: %x", req)
+	}
+	return nil
+}
+
+func xmppServer(r *bufio.Reader, w io.Writer) error {
+	if _, err := readUntil(r, "version='1.0'>"); err != nil {
+		return err
+	}
+	fmt.Fprint(w, "<?xml version='1.0'?><stream:stream from='xmpp.test.local' id='1' version='1.0' "+
+		"xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams'><stream:features>"+
+		"<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls></stream:features>")
+	if _, err := readUntil(r, "/>"); err != nil {
+		return err
+	}
+	fmt.Fprint(w, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+	return nil
+}
+
+func TestCertificates_StartTLS(t *testing.T) {
+	root, rootKey := issue(t, "Root CA", true, "", nil, nil)
+	leaf, leafKey := issue(t, "host.test.local", false, "", root, rootKey)
+	crt := tls.Certificate{Certificate: [][]byte{leaf.Raw, root.Raw}, PrivateKey: leafKey}
+
+	var tests = []struct {
+		proto     Protocol
+		negotiate func(r *bufio.Reader, w io.Writer) error
+	}{
+		{ProtocolTLS, func(r *bufio.Reader, w io.Writer) error { return nil }},
+		{ProtocolSMTP, smtpServer},
+		{ProtocolIMAP, imapServer},
+		{ProtocolPOP3, pop3Server},
+		{ProtocolLDAP, ldapServer},
+		{ProtocolPostgreSQL, postgresServer},
+		{ProtocolMySQL, mysqlServer},
+		{ProtocolXMPP, xmppServer},
+	}
+	for _, test := range tests {
+		addr := fakeServer(t, &tls.Config{Certificates: []tls.Certificate{crt}}, test.negotiate)
+		certs, err := Certificates(addr, test.proto)
+		if err != nil {
+			t.Errorf("%s: error getting certificates: %v", test.proto, err)
+			continue
+		}
+		if assert.Len(t, certs, 2, "%s: number of certificates not as expected", test.proto) {
+			assert.True(t, certs[0].Equal(leaf), "%s: leaf certificate not as expected", test.proto)
+		}
+	}
+}
+
+func TestCertificates_StartTLSRefused(t *testing.T) {
+	root, rootKey := issue(t, "Root CA", true, "", nil, nil)
+	crt := tls.Certificate{Certificate: [][]byte{root.Raw}, PrivateKey: rootKey}
+	addr := fakeServer(t, &tls.Config{Certificates: []tls.Certificate{crt}}, func(r *bufio.Reader, w io.Writer) error {
+		fmt.Fprint(w, "220 ready\r\n")
+		r.ReadString('\n')
+		fmt.Fprint(w, "250 mail.test.local\r\n")
+		r.ReadString('\n')
+		fmt.Fprint(w, "454 TLS not available\r\n")
+		// Wait for the client to hang up.
+		r.ReadByte()
+		return nil
+	})
+	_, err := Certificates(addr, ProtocolSMTP)
+	assert.Error(t, err, "refused STARTTLS should error")
+}
+
+func TestParseProtocol(t *testing.T) {
+	for p, n := range protocolNames {
+		got, err := ParseProtocol(strings.ToUpper(n))
+		if err != nil {
+			t.Errorf("error parsing %s: %v", n, err)
+		}
+		assert.Equal(t, p, got, "protocol not as expected")
+		assert.NotZero(t, p.DefaultPort(), "%s: default port not defined", n)
+	}
+	_, err := ParseProtocol("gopher")
+	assert.Error(t, err, "unknown protocol should error")
+}
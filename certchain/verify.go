@@ -0,0 +1,210 @@
+package certchain
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ProblemType categorises a problem found when verifying a certificate chain.
+type ProblemType int
+
+const (
+	ProblemHostname ProblemType = iota
+	ProblemExpired
+	ProblemNotYetValid
+	ProblemSignature
+	ProblemUnknownAuthority
+	ProblemUntrusted
+	ProblemUnused
+)
+
+func (p ProblemType) String() string {
+	switch p {
+	case ProblemHostname:
+		return "hostname mismatch"
+	case ProblemExpired:
+		return "expired"
+	case ProblemNotYetValid:
+		return "not yet valid"
+	case ProblemSignature:
+		return "invalid signature"
+	case ProblemUnknownAuthority:
+		return "unknown authority"
+	case ProblemUntrusted:
+		return "untrusted"
+	case ProblemUnused:
+		return "unused certificate"
+	default:
+		return "unknown"
+	}
+}
+
+// Problem is an issue found with a certificate of the chain.
+type Problem struct {
+	Type ProblemType
+	// Certificate the problem relates to.
+	Certificate *x509.Certificate
+	Detail      string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Type, p.Certificate.Subject, p.Detail)
+}
+
+// Result of verifying a certificate chain.
+type Result struct {
+	// Chain is ordered leaf to root. If verified it ends with the trusted root, which may not have been presented.
+	Chain    []*x509.Certificate
+	Verified bool
+	Problems []Problem
+}
+
+// Verify fetches the certificate chain presented by the endpoint and verifies it against the roots provided, or the
+// system roots if nil. The address must be in the form <fqdn>:<port>
+func Verify(addr string, proto Protocol, roots *x509.CertPool) (*Result, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := Certificates(addr, proto)
+	if err != nil {
+		return nil, err
+	}
+	return VerifyCertificates(certs, host, roots, time.Now()), nil
+}
+
+// VerifyCertificates orders the certificates leaf to root and verifies them at the time provided against the roots, or
+// the system roots if nil. The leaf is checked against the host unless it is empty.
+func VerifyCertificates(certs []*x509.Certificate, host string, roots *x509.CertPool, now time.Time) *Result {
+	res := &Result{Chain: Order(certs)}
+	if len(res.Chain) < 1 {
+		return res
+	}
+	leaf := res.Chain[0]
+	for _, c := range certs {
+		if !contains(res.Chain, c) {
+			res.Problems = append(res.Problems, Problem{ProblemUnused, c, "not part of the chain from the leaf"})
+		}
+	}
+	if host != "" {
+		if err := leaf.VerifyHostname(host); err != nil {
+			res.Problems = append(res.Problems, Problem{ProblemHostname, leaf, err.Error()})
+		}
+	}
+	for i, c := range res.Chain {
+		if now.After(c.NotAfter) {
+			res.Problems = append(res.Problems, Problem{ProblemExpired, c, "expired at " + c.NotAfter.Format(time.RFC3339)})
+		} else if now.Before(c.NotBefore) {
+			res.Problems = append(res.Problems, Problem{ProblemNotYetValid, c, "valid from " + c.NotBefore.Format(time.RFC3339)})
+		}
+		if i+1 < len(res.Chain) {
+			if err := c.CheckSignatureFrom(res.Chain[i+1]); err != nil {
+				res.Problems = append(res.Problems, Problem{ProblemSignature, c, err.Error()})
+			}
+		}
+	}
+
+	inter := x509.NewCertPool()
+	for _, c := range res.Chain[1:] {
+		inter.AddCert(c)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inter,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		switch e := err.(type) {
+		case x509.UnknownAuthorityError:
+			res.Problems = append(res.Problems, Problem{ProblemUnknownAuthority, res.Chain[len(res.Chain)-1], e.Error()})
+		case x509.CertificateInvalidError:
+			// Validity periods have already been reported against the certificates concerned.
+			if e.Reason != x509.Expired {
+				res.Problems = append(res.Problems, Problem{ProblemUntrusted, e.Cert, e.Error()})
+			}
+		default:
+			res.Problems = append(res.Problems, Problem{ProblemUntrusted, leaf, err.Error()})
+		}
+		return res
+	}
+	res.Verified = true
+	res.Chain = chains[0]
+	return res
+}
+
+// Order returns the certificates ordered from the leaf to the root, or as far towards the root as the certificates
+// provided allow. Certificates that are not part of the chain from the leaf are dropped.
+func Order(certs []*x509.Certificate) []*x509.Certificate {
+	if len(certs) < 1 {
+		return nil
+	}
+	leaf := certs[0]
+	// Servers should present the leaf first but if the first certificate issued another it is not the leaf.
+	if issuedAny(leaf, certs) {
+		for _, c := range certs {
+			if !issuedAny(c, certs) {
+				leaf = c
+				break
+			}
+		}
+	}
+	chain := []*x509.Certificate{leaf}
+	for {
+		c := chain[len(chain)-1]
+		if isSelfSigned(c) {
+			break
+		}
+		parent := issuer(c, certs, chain)
+		if parent == nil {
+			break
+		}
+		chain = append(chain, parent)
+	}
+	return chain
+}
+
+// issuer returns the certificate that issued c, excluding those already in the chain.
+func issuer(c *x509.Certificate, certs, chain []*x509.Certificate) *x509.Certificate {
+	for _, p := range certs {
+		if contains(chain, p) {
+			continue
+		}
+		if bytes.Equal(c.RawIssuer, p.RawSubject) && c.CheckSignatureFrom(p) == nil {
+			return p
+		}
+	}
+	// Fall back to a name match so that a bad signature is reported rather than the issuer going missing.
+	for _, p := range certs {
+		if !contains(chain, p) && bytes.Equal(c.RawIssuer, p.RawSubject) {
+			return p
+		}
+	}
+	return nil
+}
+
+// issuedAny returns true if c issued another of the certificates.
+func issuedAny(c *x509.Certificate, certs []*x509.Certificate) bool {
+	for _, o := range certs {
+		if o != c && !o.Equal(c) && bytes.Equal(o.RawIssuer, c.RawSubject) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSelfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil
+}
+
+func contains(certs []*x509.Certificate, c *x509.Certificate) bool {
+	for _, o := range certs {
+		if o.Equal(c) {
+			return true
+		}
+	}
+	return false
+}
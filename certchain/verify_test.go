@@ -0,0 +1,124 @@
+package certchain
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testChain struct {
+	root, inter, leaf *x509.Certificate
+	leafKey           crypto.Signer
+}
+
+func newTestChain(t *testing.T) testChain {
+	root, rootKey := issue(t, "Test Root CA", true, "", nil, nil)
+	inter, interKey := issue(t, "Test Intermediate CA", true, "", root, rootKey)
+	leaf, leafKey := issue(t, "host.test.local", false, "", inter, interKey, "127.0.0.1")
+	return testChain{root: root, inter: inter, leaf: leaf, leafKey: leafKey}
+}
+
+func TestOrder(t *testing.T) {
+	tc := newTestChain(t)
+	var tests = []struct {
+		name  string
+		certs []*x509.Certificate
+		want  []*x509.Certificate
+	}{
+		{"ordered", []*x509.Certificate{tc.leaf, tc.inter, tc.root}, []*x509.Certificate{tc.leaf, tc.inter, tc.root}},
+		{"reversed", []*x509.Certificate{tc.root, tc.inter, tc.leaf}, []*x509.Certificate{tc.leaf, tc.inter, tc.root}},
+		{"shuffled", []*x509.Certificate{tc.inter, tc.leaf}, []*x509.Certificate{tc.leaf, tc.inter}},
+		{"gap", []*x509.Certificate{tc.leaf, tc.root}, []*x509.Certificate{tc.leaf}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, Order(test.certs), "%s: order not as expected", test.name)
+	}
+	assert.Nil(t, Order(nil), "ordering no certificates should return nil")
+}
+
+func TestVerifyCertificates(t *testing.T) {
+	tc := newTestChain(t)
+	other := newTestChain(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(tc.root)
+	now := time.Now()
+
+	res := VerifyCertificates([]*x509.Certificate{tc.inter, tc.leaf}, "host.test.local", roots, now)
+	assert.True(t, res.Verified, "chain should be verified")
+	assert.Empty(t, res.Problems, "no problems expected")
+	assert.Equal(t, []*x509.Certificate{tc.leaf, tc.inter, tc.root}, res.Chain, "verified chain should end with the root")
+
+	var tests = []struct {
+		name     string
+		certs    []*x509.Certificate
+		host     string
+		roots    *x509.CertPool
+		now      time.Time
+		want     []ProblemType
+		verified bool
+	}{
+		{"hostname", []*x509.Certificate{tc.leaf, tc.inter}, "other.test.local", roots, now, []ProblemType{ProblemHostname}, true},
+		{"expired", []*x509.Certificate{tc.leaf, tc.inter}, "", roots, now.Add(time.Hour * 2), []ProblemType{ProblemExpired, ProblemExpired}, false},
+		{"not yet valid", []*x509.Certificate{tc.leaf, tc.inter}, "", roots, now.Add(-time.Hour * 2), []ProblemType{ProblemNotYetValid, ProblemNotYetValid}, false},
+		{"unknown authority", []*x509.Certificate{tc.leaf, tc.inter}, "", x509.NewCertPool(), now, []ProblemType{ProblemUnknownAuthority}, false},
+		{"missing intermediate", []*x509.Certificate{tc.leaf}, "", roots, now, []ProblemType{ProblemUnknownAuthority}, false},
+		{"unused", []*x509.Certificate{tc.leaf, other.leaf, tc.inter}, "", roots, now, []ProblemType{ProblemUnused}, true},
+	}
+	for _, test := range tests {
+		res := VerifyCertificates(test.certs, test.host, test.roots, test.now)
+		var got []ProblemType
+		for _, p := range res.Problems {
+			got = append(got, p.Type)
+		}
+		assert.Equal(t, test.want, got, "%s: problems not as expected: %v", test.name, res.Problems)
+		assert.Equal(t, test.verified, res.Verified, "%s: verified not as expected", test.name)
+		assert.Equal(t, tc.leaf, res.Chain[0], "%s: chain should start with the leaf", test.name)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tc := newTestChain(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{
+			// Present the chain out of order
+			Certificate: [][]byte{tc.leaf.Raw, tc.root.Raw, tc.inter.Raw},
+			PrivateKey:  tc.leafKey,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(tc.root)
+	res, err := Verify(l.Addr().String(), ProtocolTLS, roots)
+	if err != nil {
+		t.Fatalf("error verifying: %v", err)
+	}
+	assert.True(t, res.Verified, "chain should be verified: %v", res.Problems)
+	assert.Equal(t, []*x509.Certificate{tc.leaf, tc.inter, tc.root}, res.Chain, "chain not ordered as expected")
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	res, err = Verify(net.JoinHostPort("localhost", port), ProtocolTLS, x509.NewCertPool())
+	if err != nil {
+		t.Fatalf("error verifying: %v", err)
+	}
+	assert.False(t, res.Verified, "chain should not be verified")
+	assert.Len(t, res.Problems, 2, "expected hostname and unknown authority problems: %v", res.Problems)
+}
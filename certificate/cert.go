@@ -1,8 +1,12 @@
 package certificate
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -19,6 +23,34 @@ func PEMEncodeRSAPrivateKey(key *rsa.PrivateKey) []byte {
 	)
 }
 
+// PEMEncodePrivateKey returns the PEM encoded bytes for the private key.
+// RSA keys are encoded as PKCS#1, ECDSA keys as SEC 1 and all other keys as PKCS#8.
+func PEMEncodePrivateKey(key crypto.Signer) ([]byte, error) {
+	b, err := privateKeyPEMBlock(key)
+	if err != nil {
+		return []byte{}, err
+	}
+	return pem.EncodeToMemory(b), nil
+}
+
+// PEMEncodePKCS8PrivateKey returns the PEM encoded bytes for the private key as unencrypted PKCS#8.
+func PEMEncodePKCS8PrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return []byte{}, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// PEMEncodeEncryptedPrivateKey returns the PEM encoded bytes for the private key as PKCS#8 encrypted with the passphrase.
+func PEMEncodeEncryptedPrivateKey(key crypto.Signer, passphrase string, kdf KDF, rnd io.Reader) ([]byte, error) {
+	b, err := encryptedPrivateKeyPEMBlock(key, passphrase, kdf, rnd)
+	if err != nil {
+		return []byte{}, err
+	}
+	return pem.EncodeToMemory(b), nil
+}
+
 func PEMEncode(crt *x509.Certificate) []byte {
 	return pem.EncodeToMemory(
 		&pem.Block{
@@ -29,44 +61,168 @@ func PEMEncode(crt *x509.Certificate) []byte {
 }
 
 // Load certificate and key from PEM encoded bytes
-func Load(cert, key []byte, passphrase string) (CAcrt *x509.Certificate, CAkey *rsa.PrivateKey, err error) {
+func Load(cert, key []byte, passphrase string) (CAcrt *x509.Certificate, CAkey crypto.Signer, err error) {
 	// CA Certificate
-	pemBlock, _ := pem.Decode(cert)
-	if pemBlock == nil {
-		err = errors.New("could not decode certificate bytes")
-		return
-	}
-	CAcrt, err = x509.ParseCertificate(pemBlock.Bytes)
+	CAcrt, err = LoadCert(cert)
 	if err != nil {
 		return
 	}
 
 	// CA private key
-	pemBlock, _ = pem.Decode(key)
+	CAkey, err = LoadKey(key, passphrase)
+	return
+}
+
+// LoadCert loads a certificate from PEM encoded bytes
+func LoadCert(cert []byte) (*x509.Certificate, error) {
+	pemBlock, _ := pem.Decode(cert)
 	if pemBlock == nil {
-		err = errors.New("could not decode key bytes")
-		return
+		return nil, errors.New("could not decode certificate bytes")
 	}
+	return x509.ParseCertificate(pemBlock.Bytes)
+}
+
+// LoadKey loads a private key from PEM encoded bytes.
+// Encrypted PKCS#8 keys and legacy encrypted PEM blocks are decrypted with the passphrase.
+func LoadKey(key []byte, passphrase string) (crypto.Signer, error) {
+	pemBlock, _ := pem.Decode(key)
+	if pemBlock == nil {
+		return nil, errors.New("could not decode key bytes")
+	}
+	return decodeKeyBlock(pemBlock, passphrase)
+}
+
+// decodeKeyBlock decrypts, if required, and parses the private key in the PEM block.
+func decodeKeyBlock(pemBlock *pem.Block, passphrase string) (crypto.Signer, error) {
+	pemType := pemBlock.Type
 	der := pemBlock.Bytes
-	if x509.IsEncryptedPEMBlock(pemBlock) {
+	var err error
+	switch {
+	case pemType == "ENCRYPTED PRIVATE KEY":
+		if passphrase == "" {
+			return nil, errors.New("passphrase required to decrypt private key")
+		}
+		der, err = DecryptPKCS8PrivateKey(der, []byte(passphrase))
+		if err != nil {
+			return nil, err
+		}
+		pemType = "PRIVATE KEY"
+	case x509.IsEncryptedPEMBlock(pemBlock):
 		der, err = x509.DecryptPEMBlock(pemBlock, []byte(passphrase))
 		if err != nil {
-			return
+			return nil, err
 		}
 	}
-	CAkey, err = x509.ParsePKCS1PrivateKey(der)
+	return parsePrivateKey(pemType, der)
+}
+
+// IsEncryptedKey reports whether the encoded private key is encrypted, or held in a PKCS#12 file, and so requires a
+// passphrase to load. PEM, which may hold multiple blocks, and DER encodings are recognised.
+func IsEncryptedKey(key []byte) bool {
+	if !bytes.Contains(key, []byte("-----BEGIN ")) {
+		var epki encryptedPrivateKeyInfo
+		if _, err := asn1.Unmarshal(key, &epki); err == nil && epki.Algo.Algorithm.Equal(oidPBES2) {
+			return true
+		}
+		var pfx pfxPDU
+		_, err := asn1.Unmarshal(key, &pfx)
+		return err == nil && pfx.Version == 3
+	}
+	rest := key
+	for {
+		var pemBlock *pem.Block
+		pemBlock, rest = pem.Decode(rest)
+		if pemBlock == nil {
+			return false
+		}
+		if pemBlock.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(pemBlock) {
+			return true
+		}
+	}
+}
+
+// parsePrivateKey parses the DER bytes of a private key according to the PEM block type.
+func parsePrivateKey(pemType string, der []byte) (crypto.Signer, error) {
+	switch pemType {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := k.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type: %T", k)
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type for private key: %s", pemType)
+	}
+}
+
+// privateKeyPEMBlock returns the PEM block for the private key.
+func privateKeyPEMBlock(key crypto.Signer) (*pem.Block, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+	}
+}
+
+// encryptedPrivateKeyPEMBlock returns the PEM block for the private key as PKCS#8 encrypted with the passphrase.
+func encryptedPrivateKeyPEMBlock(key crypto.Signer, passphrase string, kdf KDF, rnd io.Reader) (*pem.Block, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase required to encrypt private key")
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
 	if err != nil {
-		return
+		return nil, err
 	}
-	return
+	return EncryptPKCS8PrivateKey(rnd, der, []byte(passphrase), kdf)
 }
 
 func WriteCert(crt *x509.Certificate, w io.Writer) error {
 	return pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw})
 }
 
-func WriteKey(key *rsa.PrivateKey, w io.Writer) error {
-	return pem.Encode(w, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+func WriteKey(key crypto.Signer, w io.Writer) error {
+	b, err := privateKeyPEMBlock(key)
+	if err != nil {
+		return err
+	}
+	return pem.Encode(w, b)
+}
+
+// WritePKCS8Key writes the private key to the io.Writer provided as unencrypted PKCS#8.
+func WritePKCS8Key(key crypto.Signer, w io.Writer) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return pem.Encode(w, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// WriteEncryptedKey writes the private key to the io.Writer provided as PKCS#8 encrypted with the passphrase.
+func WriteEncryptedKey(key crypto.Signer, passphrase string, kdf KDF, w io.Writer, rnd io.Reader) error {
+	b, err := encryptedPrivateKeyPEMBlock(key, passphrase, kdf, rnd)
+	if err != nil {
+		return err
+	}
+	return pem.Encode(w, b)
 }
 
 func WriteCertFile(crt *x509.Certificate, out string) error {
@@ -85,13 +241,35 @@ func WriteCertFile(crt *x509.Certificate, out string) error {
 	return nil
 }
 
-func WriteKeyFile(key *rsa.PrivateKey, out string) error {
-	keyOut, err := os.Create(out)
+func WriteKeyFile(key crypto.Signer, out string) error {
+	return writeKeyFile(out, func(w io.Writer) error {
+		return WriteKey(key, w)
+	})
+}
+
+// WritePKCS8KeyFile writes the private key to the file provided as unencrypted PKCS#8.
+func WritePKCS8KeyFile(key crypto.Signer, out string) error {
+	return writeKeyFile(out, func(w io.Writer) error {
+		return WritePKCS8Key(key, w)
+	})
+}
+
+// WriteEncryptedKeyFile writes the private key to the file provided as PKCS#8 encrypted with the passphrase.
+func WriteEncryptedKeyFile(key crypto.Signer, passphrase string, kdf KDF, out string, rnd io.Reader) error {
+	return writeKeyFile(out, func(w io.Writer) error {
+		return WriteEncryptedKey(key, passphrase, kdf, w, rnd)
+	})
+}
+
+// writeKeyFile creates the key file, readable only by the owner, and writes to it with the function provided.
+func writeKeyFile(out string, write func(w io.Writer) error) error {
+	keyOut, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("could not create key file: %v", err)
 	}
-	err = WriteKey(key, keyOut)
+	err = write(keyOut)
 	if err != nil {
+		keyOut.Close()
 		return fmt.Errorf("failed to write key data: %v", err)
 	}
 	err = keyOut.Close()
@@ -0,0 +1,43 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/csr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteKeyAndLoad(t *testing.T) {
+	var algs = []csr.KeyAlgorithm{
+		csr.RSA2048,
+		csr.ECDSAP256,
+		csr.Ed25519,
+	}
+	for _, alg := range algs {
+		r, key, err := csr.NewWithKeyAlgorithm(pkix.Name{CommonName: "Test CA"}, []string{}, alg, rand.Reader)
+		if err != nil {
+			t.Fatalf("error creating CSR with %v key: %v", alg, err)
+		}
+		crt, err := ca.New(r, key, time.Hour, rand.Reader)
+		if err != nil {
+			t.Fatalf("error creating CA with %v key: %v", alg, err)
+		}
+		var kb bytes.Buffer
+		err = WriteKey(key, &kb)
+		if err != nil {
+			t.Fatalf("error writing %v key: %v", alg, err)
+		}
+		lcrt, lkey, err := Load(PEMEncode(crt), kb.Bytes(), "")
+		if err != nil {
+			t.Fatalf("error loading %v key: %v", alg, err)
+		}
+		assert.Equal(t, crt.Raw, lcrt.Raw, "loaded certificate not as expected")
+		assert.True(t, key.(interface{ Equal(crypto.PrivateKey) bool }).Equal(lkey), "loaded %v key not as expected", alg)
+	}
+}
@@ -0,0 +1,24 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// VerifyClient verifies the TLS client certificate of the connection for client authentication against the roots and
+// returns its chains, or nil if no certificate was presented. It is for servers whose TLS config requests, but does
+// not verify, client certificates (tls.RequestClientCert) so clients may authenticate by other means.
+func VerifyClient(cs *tls.ConnectionState, roots *x509.CertPool) ([][]*x509.Certificate, error) {
+	if cs == nil || len(cs.PeerCertificates) < 1 {
+		return nil, nil
+	}
+	inters := x509.NewCertPool()
+	for _, c := range cs.PeerCertificates[1:] {
+		inters.AddCert(c)
+	}
+	return cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inters,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+}
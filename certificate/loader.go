@@ -0,0 +1,225 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Format of encoded certificates and private keys.
+type Format int
+
+const (
+	FormatUnknown Format = iota
+	FormatPEM
+	FormatDER
+	FormatPKCS7
+	FormatPKCS12
+	FormatJKS
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatPEM:
+		return "PEM"
+	case FormatDER:
+		return "DER"
+	case FormatPKCS7:
+		return "PKCS#7"
+	case FormatPKCS12:
+		return "PKCS#12"
+	case FormatJKS:
+		return "JKS"
+	default:
+		return "unknown"
+	}
+}
+
+// pfxPDU is the outer PFX structure of RFC 7292 section 4.
+type pfxPDU struct {
+	Version  int
+	AuthSafe PKCS7ContentInfo
+	MacData  asn1.RawValue `asn1:"optional"`
+}
+
+// Bundle holds the certificates and private key loaded from any of the supported formats.
+type Bundle struct {
+	Format Format
+	// Chain holds the certificates in the order loaded except that the certificate of the private key, if present, is first.
+	Chain []*x509.Certificate
+	// Key is the private key or nil if none was loaded.
+	Key crypto.Signer
+}
+
+// Leaf returns the first certificate of the chain or nil if there are none.
+func (b *Bundle) Leaf() *x509.Certificate {
+	if len(b.Chain) < 1 {
+		return nil
+	}
+	return b.Chain[0]
+}
+
+// ParseFile reads the file provided and parses it with Parse.
+func ParseFile(path, passphrase string) (*Bundle, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file: %v", err)
+	}
+	return Parse(b, passphrase)
+}
+
+// Parse detects the format of the bytes and returns the certificates and private key they hold.
+//
+// Supported formats are PEM, which may hold multiple blocks of certificates, keys and PKCS#7 bundles, DER encoded
+// certificates, PKCS#7 (P7B) certificate bundles, PKCS#12 (PFX) files, JKS trust stores and PKCS#1, SEC 1 and PKCS#8
+// private keys. The passphrase is used to decrypt encrypted private keys and PKCS#12 files and to verify JKS trust stores.
+func Parse(b []byte, passphrase string) (*Bundle, error) {
+	var (
+		bundle *Bundle
+		err    error
+	)
+	if bytes.Contains(b, []byte("-----BEGIN ")) {
+		bundle, err = parsePEM(b, passphrase)
+	} else {
+		bundle, err = parseDER(b, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(bundle.Chain) == 0 && bundle.Key == nil {
+		return nil, errors.New("no certificates or private key found")
+	}
+	if bundle.Key != nil {
+		bundle.Chain = keyCertFirst(bundle.Chain, bundle.Key)
+	}
+	return bundle, nil
+}
+
+func parsePEM(b []byte, passphrase string) (*Bundle, error) {
+	bundle := &Bundle{Format: FormatPEM}
+	rest := b
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE", "X509 CERTIFICATE":
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse certificate: %v", err)
+			}
+			bundle.Chain = append(bundle.Chain, c)
+		case "PKCS7":
+			certs, err := parsePKCS7(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			bundle.Chain = append(bundle.Chain, certs...)
+		case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
+			if bundle.Key != nil {
+				return nil, errors.New("more than one private key found")
+			}
+			k, err := decodeKeyBlock(block, passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("could not load private key: %v", err)
+			}
+			bundle.Key = k
+		}
+	}
+	return bundle, nil
+}
+
+func parseDER(b []byte, passphrase string) (*Bundle, error) {
+	if len(b) > 4 && binary.BigEndian.Uint32(b) == jksMagic {
+		certs, err := DecodeJKSTrustStore(b, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return &Bundle{Format: FormatJKS, Chain: certs}, nil
+	}
+	if certs, err := x509.ParseCertificates(b); err == nil && len(certs) > 0 {
+		return &Bundle{Format: FormatDER, Chain: certs}, nil
+	}
+	if certs, err := parsePKCS7(b); err == nil {
+		return &Bundle{Format: FormatPKCS7, Chain: certs}, nil
+	}
+	var pfx pfxPDU
+	if rest, err := asn1.Unmarshal(b, &pfx); err == nil && len(rest) == 0 && pfx.Version == 3 {
+		return parsePKCS12(b, passphrase)
+	}
+	var epki encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(b, &epki); err == nil && len(rest) == 0 && epki.Algo.Algorithm.Equal(oidPBES2) {
+		k, err := decodeKeyBlock(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: b}, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("could not load private key: %v", err)
+		}
+		return &Bundle{Format: FormatDER, Key: k}, nil
+	}
+	for _, t := range []string{"PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY"} {
+		if k, err := parsePrivateKey(t, b); err == nil {
+			return &Bundle{Format: FormatDER, Key: k}, nil
+		}
+	}
+	return nil, errors.New("unrecognised certificate or private key format")
+}
+
+// parsePKCS7 returns the certificates held in a DER encoded PKCS#7 SignedData structure.
+func parsePKCS7(b []byte) ([]*x509.Certificate, error) {
+	var ci PKCS7ContentInfo
+	if _, err := asn1.Unmarshal(b, &ci); err != nil {
+		return nil, fmt.Errorf("could not parse PKCS#7 content info: %v", err)
+	}
+	if !ci.ContentType.Equal(OIDPKCS7SignedData) {
+		return nil, fmt.Errorf("unsupported PKCS#7 content type: %v", ci.ContentType)
+	}
+	var sd PKCS7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("could not parse PKCS#7 signed data: %v", err)
+	}
+	if len(sd.Certificates.Bytes) == 0 {
+		return nil, nil
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse PKCS#7 certificates: %v", err)
+	}
+	return certs, nil
+}
+
+func parsePKCS12(b []byte, passphrase string) (*Bundle, error) {
+	key, crt, chain, err := DecodePKCS12(b, passphrase)
+	if err != nil {
+		// PKCS#12 trust stores hold certificates without a private key.
+		certs, terr := pkcs12.DecodeTrustStore(b, passphrase)
+		if terr != nil {
+			return nil, err
+		}
+		return &Bundle{Format: FormatPKCS12, Chain: certs}, nil
+	}
+	return &Bundle{Format: FormatPKCS12, Chain: append([]*x509.Certificate{crt}, chain...), Key: key}, nil
+}
+
+// keyCertFirst moves the certificate of the private key to the start of the chain.
+func keyCertFirst(chain []*x509.Certificate, key crypto.Signer) []*x509.Certificate {
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return chain
+	}
+	for i, c := range chain {
+		if pub.Equal(c.PublicKey) {
+			ordered := append([]*x509.Certificate{c}, chain[:i]...)
+			return append(ordered, chain[i+1:]...)
+		}
+	}
+	return chain
+}
@@ -0,0 +1,158 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/internal/testpki"
+	"github.com/stretchr/testify/assert"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+type testChain struct {
+	caCert   *x509.Certificate
+	caKey    crypto.Signer
+	leafCert *x509.Certificate
+	leafKey  crypto.Signer
+}
+
+func newTestChain(t *testing.T, alg csr.KeyAlgorithm) testChain {
+	caCert, caKey := testpki.CA(t, "Test CA", csr.ECDSAP256)
+	r, key := testpki.CSR(t, "host.test.local", alg)
+	return testChain{caCert: caCert, caKey: caKey, leafCert: testpki.Sign(t, r, caCert, caKey), leafKey: key}
+}
+
+// pkcs7Bundle returns a degenerate PKCS#7 SignedData structure holding the certificates, as produced by openssl crl2pkcs7.
+func pkcs7Bundle(t *testing.T, certs ...*x509.Certificate) []byte {
+	var raw []byte
+	for _, c := range certs {
+		raw = append(raw, c.Raw...)
+	}
+	empty := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	data, _ := asn1.Marshal(struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}})
+	sd, err := asn1.Marshal(PKCS7SignedData{
+		Version:          1,
+		DigestAlgorithms: empty,
+		ContentInfo:      asn1.RawValue{FullBytes: data},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      empty,
+	})
+	if err != nil {
+		t.Fatalf("error marshaling signed data: %v", err)
+	}
+	b, err := asn1.Marshal(PKCS7ContentInfo{
+		ContentType: OIDPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+	if err != nil {
+		t.Fatalf("error marshaling content info: %v", err)
+	}
+	return b
+}
+
+func TestParse(t *testing.T) {
+	tc := newTestChain(t, csr.ECDSAP256)
+	sec1, err := x509.MarshalECPrivateKey(tc.leafKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(tc.leafKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encPEM, err := PEMEncodeEncryptedPrivateKey(tc.leafKey, "passphrase", PBKDF2, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encBlock, _ := pem.Decode(encPEM)
+	p7 := pkcs7Bundle(t, tc.leafCert, tc.caCert)
+	pfx, err := pkcs12.Modern.Encode(tc.leafKey, tc.leafCert, []*x509.Certificate{tc.caCert}, "passphrase")
+	if err != nil {
+		t.Fatalf("error encoding PKCS#12: %v", err)
+	}
+	trustStore, err := pkcs12.Modern.EncodeTrustStore([]*x509.Certificate{tc.caCert}, "passphrase")
+	if err != nil {
+		t.Fatalf("error encoding PKCS#12 trust store: %v", err)
+	}
+
+	var multiPEM bytes.Buffer
+	// CA certificate first and key in between to check the key's certificate is placed first.
+	WriteCert(tc.caCert, &multiPEM)
+	multiPEM.Write(encPEM)
+	WriteCert(tc.leafCert, &multiPEM)
+
+	var tests = []struct {
+		name   string
+		b      []byte
+		format Format
+		certs  int
+		key    bool
+	}{
+		{"PEM certificate", PEMEncode(tc.leafCert), FormatPEM, 1, false},
+		{"PEM chain and encrypted key", multiPEM.Bytes(), FormatPEM, 2, true},
+		{"PEM SEC 1 key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}), FormatPEM, 0, true},
+		{"PEM PKCS#7", pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: p7}), FormatPEM, 2, false},
+		{"DER certificate", tc.leafCert.Raw, FormatDER, 1, false},
+		{"DER certificates", append(append([]byte{}, tc.leafCert.Raw...), tc.caCert.Raw...), FormatDER, 2, false},
+		{"DER SEC 1 key", sec1, FormatDER, 0, true},
+		{"DER PKCS#8 key", pkcs8, FormatDER, 0, true},
+		{"DER encrypted PKCS#8 key", encBlock.Bytes, FormatDER, 0, true},
+		{"PKCS#7", p7, FormatPKCS7, 2, false},
+		{"PKCS#12", pfx, FormatPKCS12, 2, true},
+		{"PKCS#12 trust store", trustStore, FormatPKCS12, 1, false},
+	}
+	for _, test := range tests {
+		b, err := Parse(test.b, "passphrase")
+		if err != nil {
+			t.Errorf("%s: error parsing: %v", test.name, err)
+			continue
+		}
+		assert.Equal(t, test.format, b.Format, "%s: format not as expected", test.name)
+		assert.Len(t, b.Chain, test.certs, "%s: number of certificates not as expected", test.name)
+		if test.key {
+			assert.True(t, tc.leafKey.(interface{ Equal(crypto.PrivateKey) bool }).Equal(b.Key), "%s: key not as expected", test.name)
+		} else {
+			assert.Nil(t, b.Key, "%s: key not expected", test.name)
+		}
+		if test.certs == 2 {
+			assert.True(t, b.Leaf().Equal(tc.leafCert), "%s: leaf certificate not as expected", test.name)
+		}
+	}
+
+	_, err = Parse(pfx, "wrong")
+	assert.Error(t, err, "parsing PKCS#12 with the wrong passphrase should error")
+	_, err = Parse([]byte("not a certificate"), "")
+	assert.Error(t, err, "parsing garbage should error")
+	_, err = Parse(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: []byte{}}), "")
+	assert.Error(t, err, "parsing PEM without certificates or keys should error")
+	assert.True(t, IsEncryptedKey(multiPEM.Bytes()), "PEM with encrypted key should be reported as encrypted")
+	assert.True(t, IsEncryptedKey(pfx), "PKCS#12 should be reported as encrypted")
+	assert.False(t, IsEncryptedKey(pkcs8), "DER PKCS#8 key should not be reported as encrypted")
+}
+
+func TestParse_SignWithLoadedCA(t *testing.T) {
+	tc := newTestChain(t, csr.RSA2048)
+	pfx, err := pkcs12.Modern.Encode(tc.caKey, tc.caCert, nil, "passphrase")
+	if err != nil {
+		t.Fatalf("error encoding PKCS#12: %v", err)
+	}
+	b, err := Parse(pfx, "passphrase")
+	if err != nil {
+		t.Fatalf("error parsing PKCS#12: %v", err)
+	}
+	r, _ := testpki.CSR(t, "other.test.local", csr.RSA2048)
+	crt, err := ca.Sign(r, b.Leaf(), b.Key, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing with loaded CA: %v", err)
+	}
+	assert.NoError(t, crt.CheckSignatureFrom(tc.caCert), "signature not valid")
+}
@@ -0,0 +1,84 @@
+package certificate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ParseKDF returns the key derivation function for the name provided (pbkdf2 or scrypt).
+func ParseKDF(s string) (KDF, error) {
+	switch strings.ToLower(s) {
+	case "pbkdf2":
+		return PBKDF2, nil
+	case "scrypt":
+		return Scrypt, nil
+	default:
+		return 0, fmt.Errorf("unknown key derivation function: %s", s)
+	}
+}
+
+func (k KDF) String() string {
+	switch k {
+	case PBKDF2:
+		return "pbkdf2"
+	case Scrypt:
+		return "scrypt"
+	default:
+		return fmt.Sprintf("KDF(%d)", int(k))
+	}
+}
+
+// ReadPassphrase returns the passphrase held on the first line of the file provided or, if file is empty, prompts for
+// it on the terminal. When confirm is true the passphrase must be entered twice at the prompt.
+func ReadPassphrase(file, prompt string, confirm bool) (string, error) {
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("could not read passphrase file: %v", err)
+		}
+		if i := bytes.IndexAny(b, "\r\n"); i >= 0 {
+			b = b[:i]
+		}
+		if len(b) == 0 {
+			return "", errors.New("passphrase file is empty")
+		}
+		return string(b), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("no passphrase file provided and not running in a terminal to prompt for one")
+	}
+	p, err := readTerminal(fd, prompt)
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm {
+		c, err := readTerminal(fd, "Confirm "+strings.ToLower(prompt[:1])+prompt[1:])
+		if err != nil {
+			return "", err
+		}
+		if c != p {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return p, nil
+}
+
+func readTerminal(fd int, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("could not read passphrase: %v", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,81 @@
+package certificate
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// PKCS12Encryption selects the algorithms used to protect a PKCS#12 file.
+type PKCS12Encryption int
+
+const (
+	// PKCS12Modern uses AES-256-CBC with PBKDF2 and a SHA-256 MAC. It is supported by Java 8u301 and later,
+	// Windows 10 1709 and later and OpenSSL 1.1.1 and later.
+	PKCS12Modern PKCS12Encryption = iota
+	// PKCS12Legacy uses 3DES with a SHA-1 MAC for older Java and Windows consumers.
+	PKCS12Legacy
+)
+
+func (e PKCS12Encryption) encoder(rnd io.Reader) (*pkcs12.Encoder, error) {
+	switch e {
+	case PKCS12Modern:
+		return pkcs12.Modern2023.WithRand(rnd), nil
+	case PKCS12Legacy:
+		return pkcs12.LegacyDES.WithRand(rnd), nil
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#12 encryption: %d", e)
+	}
+}
+
+// EncodePKCS12 returns a PKCS#12 (PFX) file holding the certificate, its private key and the chain of CA certificates
+// protected by the password.
+func EncodePKCS12(key crypto.Signer, crt *x509.Certificate, chain []*x509.Certificate, password string, enc PKCS12Encryption, rnd io.Reader) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("password required to protect PKCS#12")
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); ok && !pub.Equal(crt.PublicKey) {
+		return nil, errors.New("private key does not match the certificate")
+	}
+	e, err := enc.encoder(rnd)
+	if err != nil {
+		return nil, err
+	}
+	b, err := e.Encode(key, crt, chain, password)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode PKCS#12: %v", err)
+	}
+	return b, nil
+}
+
+// DecodePKCS12 returns the private key, certificate and chain of CA certificates held in the PKCS#12 (PFX) file.
+func DecodePKCS12(b []byte, password string) (crypto.Signer, *x509.Certificate, []*x509.Certificate, error) {
+	k, crt, chain, err := pkcs12.DecodeChain(b, password)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("could not decode PKCS#12: %v", err)
+	}
+	key, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("unsupported private key type: %T", k)
+	}
+	return key, crt, chain, nil
+}
+
+// WritePKCS12File writes the certificate, its private key and the chain of CA certificates to the file provided as
+// PKCS#12 protected by the password.
+func WritePKCS12File(key crypto.Signer, crt *x509.Certificate, chain []*x509.Certificate, password string, enc PKCS12Encryption, out string, rnd io.Reader) error {
+	b, err := EncodePKCS12(key, crt, chain, password, enc, rnd)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(out, b, 0600)
+	if err != nil {
+		return fmt.Errorf("could not write PKCS#12 file: %v", err)
+	}
+	return nil
+}
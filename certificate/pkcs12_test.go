@@ -0,0 +1,39 @@
+package certificate
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+
+	"github.com/jcmturner/pki/csr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodePKCS12(t *testing.T) {
+	for _, alg := range []csr.KeyAlgorithm{csr.RSA2048, csr.ECDSAP256} {
+		tc := newTestChain(t, alg)
+		for _, enc := range []PKCS12Encryption{PKCS12Modern, PKCS12Legacy} {
+			b, err := EncodePKCS12(tc.leafKey, tc.leafCert, []*x509.Certificate{tc.caCert}, "changeit", enc, rand.Reader)
+			if err != nil {
+				t.Fatalf("error encoding %v PKCS#12: %v", alg, err)
+			}
+			key, crt, chain, err := DecodePKCS12(b, "changeit")
+			if err != nil {
+				t.Fatalf("error decoding %v PKCS#12: %v", alg, err)
+			}
+			assert.True(t, tc.leafKey.(interface{ Equal(crypto.PrivateKey) bool }).Equal(key), "key not as expected")
+			assert.True(t, crt.Equal(tc.leafCert), "certificate not as expected")
+			if assert.Len(t, chain, 1, "chain length not as expected") {
+				assert.True(t, chain[0].Equal(tc.caCert), "chain certificate not as expected")
+			}
+			_, _, _, err = DecodePKCS12(b, "wrong")
+			assert.Error(t, err, "decoding with the wrong password should error")
+		}
+	}
+	tc := newTestChain(t, csr.ECDSAP256)
+	_, err := EncodePKCS12(tc.caKey, tc.leafCert, nil, "changeit", PKCS12Modern, rand.Reader)
+	assert.Error(t, err, "encoding with a key not matching the certificate should error")
+	_, err = EncodePKCS12(tc.leafKey, tc.leafCert, nil, "", PKCS12Modern, rand.Reader)
+	assert.Error(t, err, "encoding without a password should error")
+}
@@ -0,0 +1,57 @@
+package certificate
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+)
+
+// PKCS#7 content types of RFC 2315 section 14.
+var (
+	OIDPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	OIDPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// PKCS7ContentInfo is the PKCS#7 ContentInfo structure of RFC 2315 section 7.
+type PKCS7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// PKCS7SignedData is the PKCS#7 SignedData structure of RFC 2315 section 9.1. The digest algorithms, content info and
+// signer infos are left encoded for callers that sign or verify to parse.
+type PKCS7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// EncodePKCS7 returns the certificates as a DER encoded degenerate PKCS#7 SignedData structure, with no signers, as
+// used for P7B bundles and certs-only responses.
+func EncodePKCS7(certs []*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, c := range certs {
+		raw = append(raw, c.Raw...)
+	}
+	empty := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	data, err := asn1.Marshal(struct{ ContentType asn1.ObjectIdentifier }{OIDPKCS7Data})
+	if err != nil {
+		return nil, err
+	}
+	sd, err := asn1.Marshal(PKCS7SignedData{
+		Version:          1,
+		DigestAlgorithms: empty,
+		ContentInfo:      asn1.RawValue{FullBytes: data},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      empty,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(PKCS7ContentInfo{
+		ContentType: OIDPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
@@ -0,0 +1,257 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF is the key derivation function used to derive the encryption key of an encrypted PKCS#8 private key from the passphrase.
+type KDF int
+
+const (
+	// PBKDF2 with HMAC-SHA256 as described in RFC 8018.
+	PBKDF2 KDF = iota
+	// Scrypt as described in RFC 7914.
+	Scrypt
+)
+
+const (
+	pbkdf2Iterations = 100000
+	scryptN          = 1 << 14
+	scryptR          = 8
+	scryptP          = 1
+	saltLen          = 16
+
+	// Upper bounds on the key derivation parameters read from an encrypted key so a crafted file cannot exhaust CPU or
+	// memory when loaded.
+	maxPBKDF2Iterations = 10000000
+	maxScryptMemory     = 1 << 30
+	maxScryptP          = 16
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo is the EncryptedPrivateKeyInfo structure of RFC 5208 section 6.
+type encryptedPrivateKeyInfo struct {
+	Algo          pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// EncryptPKCS8PrivateKey encrypts the DER encoded PKCS#8 private key with PBES2 and AES-256-CBC using a key derived from
+// the passphrase with the KDF provided. It returns an "ENCRYPTED PRIVATE KEY" PEM block.
+func EncryptPKCS8PrivateKey(rnd io.Reader, der, passphrase []byte, kdf KDF) (*pem.Block, error) {
+	salt := make([]byte, saltLen)
+	if _, err := io.ReadFull(rnd, salt); err != nil {
+		return nil, fmt.Errorf("could not generate salt: %v", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rnd, iv); err != nil {
+		return nil, fmt.Errorf("could not generate IV: %v", err)
+	}
+	kdfAlgo := pkix.AlgorithmIdentifier{}
+	var key []byte
+	switch kdf {
+	case PBKDF2:
+		params, err := asn1.Marshal(pbkdf2Params{
+			Salt:           salt,
+			IterationCount: pbkdf2Iterations,
+			PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+		})
+		if err != nil {
+			return nil, err
+		}
+		kdfAlgo.Algorithm = oidPBKDF2
+		kdfAlgo.Parameters.FullBytes = params
+		key = pbkdf2.Key(passphrase, salt, pbkdf2Iterations, 32, sha256.New)
+	case Scrypt:
+		params, err := asn1.Marshal(scryptParams{
+			Salt:                     salt,
+			CostParameter:            scryptN,
+			BlockSize:                scryptR,
+			ParallelizationParameter: scryptP,
+		})
+		if err != nil {
+			return nil, err
+		}
+		kdfAlgo.Algorithm = oidScrypt
+		kdfAlgo.Parameters.FullBytes = params
+		key, err = scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function: %d", kdf)
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: kdfAlgo,
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(der)%aes.BlockSize
+	enc := make([]byte, len(der), len(der)+pad)
+	copy(enc, der)
+	enc = append(enc, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(enc, enc)
+
+	b, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algo:          pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: enc,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: b}, nil
+}
+
+// DecryptPKCS8PrivateKey decrypts the DER encoded PBES2 encrypted PKCS#8 private key with the passphrase provided
+// returning the DER encoded PKCS#8 private key. PBKDF2 and scrypt key derivation with AES-CBC encryption are supported.
+func DecryptPKCS8PrivateKey(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("could not parse encrypted private key: %v", err)
+	}
+	if !info.Algo.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption algorithm: %v", info.Algo.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("could not parse PBES2 parameters: %v", err)
+	}
+
+	var keyLen int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLen = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, fmt.Errorf("unsupported private key encryption scheme: %v", params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New("invalid encryption scheme IV")
+	}
+
+	var key []byte
+	switch {
+	case params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2):
+		var kp pbkdf2Params
+		if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kp); err != nil {
+			return nil, fmt.Errorf("could not parse PBKDF2 parameters: %v", err)
+		}
+		if kp.IterationCount < 1 || kp.IterationCount > maxPBKDF2Iterations {
+			return nil, fmt.Errorf("PBKDF2 iteration count %d out of range", kp.IterationCount)
+		}
+		h, err := prfHash(kp.PRF.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		key = pbkdf2.Key(passphrase, kp.Salt, kp.IterationCount, keyLen, h)
+	case params.KeyDerivationFunc.Algorithm.Equal(oidScrypt):
+		var sp scryptParams
+		if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &sp); err != nil {
+			return nil, fmt.Errorf("could not parse scrypt parameters: %v", err)
+		}
+		// scrypt uses 128*N*r bytes of memory.
+		if sp.CostParameter < 2 || sp.BlockSize < 1 || sp.ParallelizationParameter < 1 || sp.ParallelizationParameter > maxScryptP ||
+			int64(sp.CostParameter) > maxScryptMemory/128/int64(sp.BlockSize) {
+			return nil, fmt.Errorf("scrypt parameters N=%d r=%d p=%d out of range", sp.CostParameter, sp.BlockSize, sp.ParallelizationParameter)
+		}
+		var err error
+		key, err = scrypt.Key(passphrase, sp.Salt, sp.CostParameter, sp.BlockSize, sp.ParallelizationParameter, keyLen)
+		if err != nil {
+			return nil, fmt.Errorf("could not derive key: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function: %v", params.KeyDerivationFunc.Algorithm)
+	}
+
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted private key data is not a multiple of the block size")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	dec := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(dec, info.EncryptedData)
+	pad := int(dec[len(dec)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(dec[len(dec)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errors.New("could not decrypt private key: incorrect passphrase")
+	}
+	return dec[:len(dec)-pad], nil
+}
+
+// prfHash returns the hash function of the PBKDF2 pseudorandom function. The default is HMAC-SHA1.
+func prfHash(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case len(oid) == 0, oid.Equal(oidHMACWithSHA1):
+		return sha1.New, nil
+	case oid.Equal(oidHMACWithSHA256):
+		return sha256.New, nil
+	case oid.Equal(oidHMACWithSHA384):
+		return sha512.New384, nil
+	case oid.Equal(oidHMACWithSHA512):
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 pseudorandom function: %v", oid)
+	}
+}
@@ -0,0 +1,282 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// TrustStoreFormat is the format of a Java trust store.
+type TrustStoreFormat int
+
+const (
+	TrustStoreJKS TrustStoreFormat = iota
+	TrustStorePKCS12
+)
+
+// ParseTrustStoreFormat returns the trust store format for the name provided (jks, p12 or pkcs12).
+func ParseTrustStoreFormat(s string) (TrustStoreFormat, error) {
+	switch strings.ToLower(s) {
+	case "jks":
+		return TrustStoreJKS, nil
+	case "p12", "pkcs12", "pfx":
+		return TrustStorePKCS12, nil
+	default:
+		return 0, fmt.Errorf("unknown trust store format: %s", s)
+	}
+}
+
+const (
+	jksMagic        = 0xFEEDFEED
+	jksVersion      = 2
+	jksTrustedEntry = 2
+	jksPrivateEntry = 1
+	// jksWhitener is mixed into the JKS integrity digest by the Java implementation.
+	jksWhitener = "Mighty Aphrodite"
+)
+
+// EncodeTrustStore returns a trust store in the format specified holding the certificates as trusted entries.
+func EncodeTrustStore(certs []*x509.Certificate, format TrustStoreFormat, password string, rnd io.Reader) ([]byte, error) {
+	switch format {
+	case TrustStoreJKS:
+		return EncodeJKSTrustStore(certs, password)
+	case TrustStorePKCS12:
+		return EncodePKCS12TrustStore(certs, password, rnd)
+	default:
+		return nil, fmt.Errorf("unsupported trust store format: %d", format)
+	}
+}
+
+// WriteTrustStoreFile writes the certificates to the file provided as a trust store in the format specified.
+func WriteTrustStoreFile(certs []*x509.Certificate, format TrustStoreFormat, password, out string, rnd io.Reader) error {
+	b, err := EncodeTrustStore(certs, format, password, rnd)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(out, b, 0644)
+	if err != nil {
+		return fmt.Errorf("could not write trust store file: %v", err)
+	}
+	return nil
+}
+
+// EncodePKCS12TrustStore returns a PKCS#12 trust store holding the certificates marked as trusted for Java 8 and later.
+func EncodePKCS12TrustStore(certs []*x509.Certificate, password string, rnd io.Reader) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates for trust store")
+	}
+	b, err := pkcs12.Modern2023.WithRand(rnd).EncodeTrustStore(certs, password)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode PKCS#12 trust store: %v", err)
+	}
+	return b, nil
+}
+
+// EncodeJKSTrustStore returns a Java KeyStore (JKS) holding the certificates as trusted certificate entries.
+// Entries are aliased by the lower cased common name, or organisation, of the certificate subject.
+// The password protects the integrity of the key store.
+func EncodeJKSTrustStore(certs []*x509.Certificate, password string) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates for trust store")
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(jksMagic))
+	binary.Write(&b, binary.BigEndian, uint32(jksVersion))
+	binary.Write(&b, binary.BigEndian, uint32(len(certs)))
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	aliases := make(map[string]bool)
+	for i, c := range certs {
+		alias := jksAlias(c, i, aliases)
+		binary.Write(&b, binary.BigEndian, uint32(jksTrustedEntry))
+		if err := writeJavaUTF(&b, alias); err != nil {
+			return nil, err
+		}
+		binary.Write(&b, binary.BigEndian, now)
+		writeJavaUTF(&b, "X.509")
+		binary.Write(&b, binary.BigEndian, uint32(len(c.Raw)))
+		b.Write(c.Raw)
+	}
+	b.Write(jksDigest(b.Bytes(), password))
+	return b.Bytes(), nil
+}
+
+// DecodeJKSTrustStore returns the trusted certificates held in the Java KeyStore (JKS), verifying its integrity with
+// the password. As with Java, the integrity check is skipped if the password is empty. Private key entries are skipped.
+func DecodeJKSTrustStore(b []byte, password string) ([]*x509.Certificate, error) {
+	if len(b) < 12+sha1.Size {
+		return nil, errors.New("data too short to be a JKS")
+	}
+	body := b[:len(b)-sha1.Size]
+	if password != "" && !bytes.Equal(jksDigest(body, password), b[len(body):]) {
+		return nil, errors.New("JKS integrity check failed: incorrect password or corrupt key store")
+	}
+	r := bytes.NewReader(body)
+	var hdr struct{ Magic, Version, Count uint32 }
+	if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("could not read JKS header: %v", err)
+	}
+	if hdr.Magic != jksMagic || hdr.Version != jksVersion {
+		return nil, errors.New("not a version 2 JKS")
+	}
+	var certs []*x509.Certificate
+	for i := uint32(0); i < hdr.Count; i++ {
+		var tag uint32
+		if err := binary.Read(r, binary.BigEndian, &tag); err != nil {
+			return nil, fmt.Errorf("could not read JKS entry: %v", err)
+		}
+		if _, err := readJavaUTF(r); err != nil {
+			return nil, fmt.Errorf("could not read JKS entry alias: %v", err)
+		}
+		var ts int64
+		if err := binary.Read(r, binary.BigEndian, &ts); err != nil {
+			return nil, fmt.Errorf("could not read JKS entry: %v", err)
+		}
+		switch tag {
+		case jksTrustedEntry:
+			c, err := readJKSCert(r)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, c)
+		case jksPrivateEntry:
+			// Skip the protected key and its certificate chain.
+			if _, err := readJKSBytes(r); err != nil {
+				return nil, err
+			}
+			var n uint32
+			if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+				return nil, fmt.Errorf("could not read JKS entry: %v", err)
+			}
+			for j := uint32(0); j < n; j++ {
+				if _, err := readJKSCert(r); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported JKS entry type: %d", tag)
+		}
+	}
+	return certs, nil
+}
+
+func readJKSCert(r *bytes.Reader) (*x509.Certificate, error) {
+	t, err := readJavaUTF(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read JKS certificate type: %v", err)
+	}
+	if t != "X.509" {
+		return nil, fmt.Errorf("unsupported JKS certificate type: %s", t)
+	}
+	der, err := readJKSBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse JKS certificate: %v", err)
+	}
+	return c, nil
+}
+
+func readJKSBytes(r *bytes.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, fmt.Errorf("could not read JKS entry: %v", err)
+	}
+	if int64(n) > int64(r.Len()) {
+		return nil, errors.New("JKS entry length exceeds data")
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+// jksDigest returns the SHA-1 integrity digest of the JKS data as calculated by the Java implementation.
+func jksDigest(data []byte, password string) []byte {
+	h := sha1.New()
+	for _, c := range utf16.Encode([]rune(password)) {
+		h.Write([]byte{byte(c >> 8), byte(c)})
+	}
+	h.Write([]byte(jksWhitener))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// jksAlias returns a unique alias for the certificate. Java lower cases JKS aliases.
+func jksAlias(c *x509.Certificate, i int, used map[string]bool) string {
+	alias := c.Subject.CommonName
+	if alias == "" && len(c.Subject.Organization) > 0 {
+		alias = c.Subject.Organization[0]
+	}
+	if alias == "" {
+		alias = "cert"
+	}
+	alias = strings.ToLower(alias)
+	if used[alias] {
+		alias = alias + "-" + strconv.Itoa(i)
+	}
+	used[alias] = true
+	return alias
+}
+
+// writeJavaUTF writes the string in the length prefixed modified UTF-8 encoding of Java's DataOutput.writeUTF.
+func writeJavaUTF(w *bytes.Buffer, s string) error {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		switch {
+		case c >= 0x01 && c <= 0x7f:
+			b = append(b, byte(c))
+		case c <= 0x7ff:
+			b = append(b, byte(0xc0|c>>6), byte(0x80|c&0x3f))
+		default:
+			b = append(b, byte(0xe0|c>>12), byte(0x80|(c>>6)&0x3f), byte(0x80|c&0x3f))
+		}
+	}
+	if len(b) > 0xffff {
+		return errors.New("string too long for JKS")
+	}
+	binary.Write(w, binary.BigEndian, uint16(len(b)))
+	w.Write(b)
+	return nil
+}
+
+// readJavaUTF reads a string in the length prefixed modified UTF-8 encoding of Java's DataInput.readUTF.
+func readJavaUTF(r *bytes.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	if int(n) > r.Len() {
+		return "", errors.New("string length exceeds data")
+	}
+	b := make([]byte, n)
+	io.ReadFull(r, b)
+	var u []uint16
+	for i := 0; i < len(b); {
+		switch {
+		case b[i] < 0x80:
+			u = append(u, uint16(b[i]))
+			i++
+		case b[i]&0xe0 == 0xc0 && i+1 < len(b):
+			u = append(u, uint16(b[i]&0x1f)<<6|uint16(b[i+1]&0x3f))
+			i += 2
+		case b[i]&0xf0 == 0xe0 && i+2 < len(b):
+			u = append(u, uint16(b[i]&0x0f)<<12|uint16(b[i+1]&0x3f)<<6|uint16(b[i+2]&0x3f))
+			i += 3
+		default:
+			return "", errors.New("invalid modified UTF-8")
+		}
+	}
+	return string(utf16.Decode(u)), nil
+}
@@ -0,0 +1,66 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+
+	"github.com/jcmturner/pki/csr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeTrustStore(t *testing.T) {
+	tc := newTestChain(t, csr.ECDSAP256)
+	certs := []*x509.Certificate{tc.caCert, tc.leafCert}
+
+	jks, err := EncodeTrustStore(certs, TrustStoreJKS, "changeit", rand.Reader)
+	if err != nil {
+		t.Fatalf("error encoding JKS: %v", err)
+	}
+	decoded, err := DecodeJKSTrustStore(jks, "changeit")
+	if err != nil {
+		t.Fatalf("error decoding JKS: %v", err)
+	}
+	if assert.Len(t, decoded, 2, "number of JKS certificates not as expected") {
+		assert.True(t, decoded[0].Equal(tc.caCert), "JKS certificate not as expected")
+		assert.True(t, decoded[1].Equal(tc.leafCert), "JKS certificate not as expected")
+	}
+	_, err = DecodeJKSTrustStore(jks, "wrong")
+	assert.Error(t, err, "decoding JKS with the wrong password should error")
+	b, err := Parse(jks, "")
+	if err != nil {
+		t.Fatalf("error parsing JKS: %v", err)
+	}
+	assert.Equal(t, FormatJKS, b.Format, "format not as expected")
+	assert.Len(t, b.Chain, 2, "number of parsed JKS certificates not as expected")
+
+	p12, err := EncodeTrustStore(certs, TrustStorePKCS12, "changeit", rand.Reader)
+	if err != nil {
+		t.Fatalf("error encoding PKCS#12 trust store: %v", err)
+	}
+	b, err = Parse(p12, "changeit")
+	if err != nil {
+		t.Fatalf("error parsing PKCS#12 trust store: %v", err)
+	}
+	assert.Equal(t, FormatPKCS12, b.Format, "format not as expected")
+	assert.Len(t, b.Chain, 2, "number of PKCS#12 certificates not as expected")
+
+	_, err = EncodeTrustStore(nil, TrustStoreJKS, "changeit", rand.Reader)
+	assert.Error(t, err, "encoding an empty trust store should error")
+}
+
+func TestJavaUTF(t *testing.T) {
+	for _, s := range []string{"ascii alias", "café", "日本", "nul\x00char", "emoji \U0001F512"} {
+		var b bytes.Buffer
+		err := writeJavaUTF(&b, s)
+		if err != nil {
+			t.Fatalf("error writing %q: %v", s, err)
+		}
+		r, err := readJavaUTF(bytes.NewReader(b.Bytes()))
+		if err != nil {
+			t.Fatalf("error reading %q: %v", s, err)
+		}
+		assert.Equal(t, s, r, "round trip of modified UTF-8 not as expected")
+	}
+}
@@ -19,10 +19,30 @@ func main() {
 	ou := flag.String("ou", "", "Organisational unit")
 	l := flag.String("l", "", "Locality or city")
 	s := flag.String("s", "", "State, county, region or province")
-	sns := flag.String("sans", "", "Comma separated list of Subject Alternative Names")
+	sns := flag.String("sans", "", "Comma separated list of Subject Alternative Names (DNS names, IP addresses, email addresses or URIs)")
 	out := flag.String("out", "./", "Output path for certificate and private key")
+	ka := flag.String("keyalg", "rsa2048", "Private key algorithm (rsa2048, rsa3072, rsa4096, p256, p384, p521, ed25519)")
+	encrypt := flag.Bool("encrypt", false, "Write the private key as PKCS#8 encrypted with a passphrase")
+	passfile := flag.String("passfile", "", "File containing the passphrase to encrypt the private key with. If not provided with -encrypt the passphrase is prompted for")
+	kdfn := flag.String("kdf", "pbkdf2", "Key derivation function used to encrypt the private key (pbkdf2, scrypt)")
 	flag.Parse()
 
+	alg, err := csr.ParseKeyAlgorithm(*ka)
+	if err != nil {
+		log.Fatal(err)
+	}
+	kdf, err := certificate.ParseKDF(*kdfn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var passphrase string
+	if *encrypt {
+		passphrase, err = certificate.ReadPassphrase(*passfile, "Private key passphrase: ", true)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	sans := strings.Split(*sns, ",")
 
 	subj := pkix.Name{CommonName: *cn}
@@ -42,12 +62,16 @@ func main() {
 		subj.Province = strings.Split(*s, ",")
 	}
 
-	cr, key, err := csr.New(subj, sans, rand.Reader)
+	cr, key, err := csr.NewWithKeyAlgorithm(subj, sans, alg, rand.Reader)
 	if err != nil {
 		log.Fatalf("error creating CA request: %v\n", err)
 	}
 
-	err = certificate.WriteKeyFile(key, filepath.Clean(*out)+"/"+*cn+".key")
+	if *encrypt {
+		err = certificate.WriteEncryptedKeyFile(key, passphrase, kdf, filepath.Clean(*out)+"/"+*cn+".key", rand.Reader)
+	} else {
+		err = certificate.WriteKeyFile(key, filepath.Clean(*out)+"/"+*cn+".key")
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
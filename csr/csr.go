@@ -1,7 +1,7 @@
 package csr
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
@@ -13,46 +13,69 @@ import (
 )
 
 const (
-	keySize   = 2048
 	pemHeader = "CERTIFICATE REQUEST"
 )
 
-// New creates a new CSR
-func New(subj pkix.Name, SANs []string, rnd io.Reader) (*x509.CertificateRequest, *rsa.PrivateKey, error) {
-	key, err := rsa.GenerateKey(rnd, keySize)
+// New creates a new CSR and 2048 bit RSA private key
+func New(subj pkix.Name, SANs []string, rnd io.Reader) (*x509.CertificateRequest, crypto.Signer, error) {
+	return NewWithKeyAlgorithm(subj, SANs, RSA2048, rnd)
+}
+
+// NewWithKeyAlgorithm creates a new CSR and a private key of the algorithm specified
+func NewWithKeyAlgorithm(subj pkix.Name, SANs []string, alg KeyAlgorithm, rnd io.Reader) (*x509.CertificateRequest, crypto.Signer, error) {
+	key, err := GenerateKey(alg, rnd)
 	if err != nil {
 		return &x509.CertificateRequest{}, key, err
 	}
-	var cn bool
-	for _, n := range SANs {
-		if n == subj.CommonName {
-			cn = true
-			break
-		}
+	csr, err := NewWithSigner(subj, SANs, key, rnd)
+	return csr, key, err
+}
+
+// NewWithSigner creates a new CSR signed by the key provided.
+// Each SAN is classified as an IP address, email address, URI or DNS name as described by ParseSANs.
+func NewWithSigner(subj pkix.Name, SANs []string, key crypto.Signer, rnd io.Reader) (*x509.CertificateRequest, error) {
+	sans, err := ParseSANs(SANs)
+	if err != nil {
+		return &x509.CertificateRequest{}, err
 	}
-	if !cn {
-		SANs = append(SANs, subj.CommonName)
+	return NewWithSANs(subj, sans, key, rnd)
+}
+
+// NewWithSANs creates a new CSR with typed subject alternative names signed by the key provided.
+// The subject common name is added as a subject alternative name if not already present and it is a host name, IP
+// address, email address or URI.
+func NewWithSANs(subj pkix.Name, sans SANs, key crypto.Signer, rnd io.Reader) (*x509.CertificateRequest, error) {
+	if isSANName(subj.CommonName) {
+		err := sans.Add(subj.CommonName)
+		if err != nil {
+			return &x509.CertificateRequest{}, err
+		}
 	}
 	rawSubj := subj.ToRDNSequence()
 	asn1Subj, err := asn1.Marshal(rawSubj)
 	if err != nil {
-		return &x509.CertificateRequest{}, key, err
+		return &x509.CertificateRequest{}, err
 	}
 	template := x509.CertificateRequest{
-		Version:            3,
-		RawSubject:         asn1Subj,
-		DNSNames:           SANs,
-		SignatureAlgorithm: x509.SHA256WithRSA,
+		Version:        3,
+		RawSubject:     asn1Subj,
+		DNSNames:       sans.DNSNames,
+		IPAddresses:    sans.IPAddresses,
+		EmailAddresses: sans.EmailAddresses,
+		URIs:           sans.URIs,
 	}
 	csrBytes, err := x509.CreateCertificateRequest(rnd, &template, key)
+	if err != nil {
+		return &x509.CertificateRequest{}, err
+	}
 	csrType, err := x509.ParseCertificateRequest(csrBytes)
 	if err != nil {
-		return &x509.CertificateRequest{}, key, err
+		return &x509.CertificateRequest{}, err
 	}
 	if err = csrType.CheckSignature(); err != nil {
-		return &x509.CertificateRequest{}, key, err
+		return &x509.CertificateRequest{}, err
 	}
-	return csrType, key, nil
+	return csrType, nil
 }
 
 // Load CSR from PEM encoded bytes.
@@ -0,0 +1,77 @@
+package csr
+
+import (
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSANs(t *testing.T) {
+	s, err := ParseSANs([]string{
+		"www.test.local",
+		"10.1.2.3",
+		"2001:db8::1",
+		"admin@test.local",
+		"spiffe://test.local/ns/default/sa/web",
+		"",
+		"www.test.local",
+	})
+	if err != nil {
+		t.Fatalf("error parsing SANs: %v", err)
+	}
+	assert.Equal(t, []string{"www.test.local"}, s.DNSNames, "DNS names not as expected")
+	assert.Len(t, s.IPAddresses, 2, "number of IP addresses not as expected")
+	assert.True(t, s.IPAddresses[0].Equal(net.ParseIP("10.1.2.3")), "IP address not as expected")
+	assert.Equal(t, []string{"admin@test.local"}, s.EmailAddresses, "email addresses not as expected")
+	if assert.Len(t, s.URIs, 1, "number of URIs not as expected") {
+		assert.Equal(t, "spiffe", s.URIs[0].Scheme, "URI scheme not as expected")
+	}
+}
+
+func TestNew_SANs(t *testing.T) {
+	for _, alg := range []KeyAlgorithm{RSA2048, ECDSAP256, Ed25519} {
+		r, _, err := NewWithKeyAlgorithm(pkix.Name{CommonName: "10.0.0.1"}, []string{"host.test.local", "spiffe://test.local/web"}, alg, rand.Reader)
+		if err != nil {
+			t.Fatalf("error creating CSR with %v key: %v", alg, err)
+		}
+		assert.Equal(t, []string{"host.test.local"}, r.DNSNames, "DNS names not as expected")
+		if assert.Len(t, r.IPAddresses, 1, "common name not added as IP address") {
+			assert.True(t, r.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")), "IP address not as expected")
+		}
+		assert.Len(t, r.URIs, 1, "number of URIs not as expected")
+	}
+}
+
+func TestNew_CommonNameSAN(t *testing.T) {
+	for _, test := range []struct {
+		cn      string
+		dnsName bool
+	}{
+		{"host.test.local", true},
+		{"*.test.local", true},
+		{"localhost", true},
+		{"John Smith", false},
+		{"Test CA", false},
+		{"host..test.local", false},
+		{"-host.test.local", false},
+	} {
+		r, _, err := NewWithKeyAlgorithm(pkix.Name{CommonName: test.cn}, []string{}, ECDSAP256, rand.Reader)
+		if err != nil {
+			t.Fatalf("error creating CSR for %s: %v", test.cn, err)
+		}
+		if test.dnsName {
+			assert.Equal(t, []string{test.cn}, r.DNSNames, "%s: common name not added as a DNS name", test.cn)
+		} else {
+			assert.Empty(t, r.DNSNames, "%s: common name should not be added as a DNS name", test.cn)
+		}
+	}
+	r, _, err := NewWithKeyAlgorithm(pkix.Name{CommonName: "John Smith"}, []string{"john@test.local"}, ECDSAP256, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CSR: %v", err)
+	}
+	assert.Empty(t, r.DNSNames, "common name should not be added as a DNS name")
+	assert.Equal(t, []string{"john@test.local"}, r.EmailAddresses, "email addresses not as expected")
+}
@@ -0,0 +1,75 @@
+package csr
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// KeyAlgorithm identifies the type and size of private key to generate.
+type KeyAlgorithm int
+
+const (
+	RSA2048 KeyAlgorithm = iota
+	RSA3072
+	RSA4096
+	ECDSAP256
+	ECDSAP384
+	ECDSAP521
+	Ed25519
+)
+
+var keyAlgorithmNames = map[KeyAlgorithm]string{
+	RSA2048:   "rsa2048",
+	RSA3072:   "rsa3072",
+	RSA4096:   "rsa4096",
+	ECDSAP256: "p256",
+	ECDSAP384: "p384",
+	ECDSAP521: "p521",
+	Ed25519:   "ed25519",
+}
+
+func (a KeyAlgorithm) String() string {
+	if s, ok := keyAlgorithmNames[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("KeyAlgorithm(%d)", int(a))
+}
+
+// ParseKeyAlgorithm returns the KeyAlgorithm for the name provided (eg rsa2048, p256, ed25519).
+func ParseKeyAlgorithm(s string) (KeyAlgorithm, error) {
+	for a, n := range keyAlgorithmNames {
+		if strings.EqualFold(s, n) {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported key algorithm: %s", s)
+}
+
+// GenerateKey creates a new private key of the algorithm specified.
+func GenerateKey(alg KeyAlgorithm, rnd io.Reader) (crypto.Signer, error) {
+	switch alg {
+	case RSA2048:
+		return rsa.GenerateKey(rnd, 2048)
+	case RSA3072:
+		return rsa.GenerateKey(rnd, 3072)
+	case RSA4096:
+		return rsa.GenerateKey(rnd, 4096)
+	case ECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rnd)
+	case ECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rnd)
+	case ECDSAP521:
+		return ecdsa.GenerateKey(elliptic.P521(), rnd)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rnd)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm: %v", alg)
+	}
+}
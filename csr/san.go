@@ -0,0 +1,112 @@
+package csr
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// SANs holds subject alternative names by type.
+type SANs struct {
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+}
+
+// ParseSANs classifies each name as an IP address, email address, URI or DNS name.
+// Names containing "://" are URIs (eg spiffe://example.org/service), names containing "@" are email addresses
+// and names that parse as an IP address are IP addresses. All others are DNS names. Empty names are ignored.
+func ParseSANs(names []string) (SANs, error) {
+	var s SANs
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		if err := s.Add(n); err != nil {
+			return SANs{}, err
+		}
+	}
+	return s, nil
+}
+
+// Add classifies the name and adds it to the SANs if not already present.
+func (s *SANs) Add(name string) error {
+	switch {
+	case strings.Contains(name, "://"):
+		u, err := url.Parse(name)
+		if err != nil {
+			return fmt.Errorf("invalid URI subject alternative name %s: %v", name, err)
+		}
+		for _, e := range s.URIs {
+			if e.String() == u.String() {
+				return nil
+			}
+		}
+		s.URIs = append(s.URIs, u)
+	case strings.Contains(name, "@"):
+		for _, e := range s.EmailAddresses {
+			if e == name {
+				return nil
+			}
+		}
+		s.EmailAddresses = append(s.EmailAddresses, name)
+	case net.ParseIP(name) != nil:
+		ip := net.ParseIP(name)
+		for _, e := range s.IPAddresses {
+			if e.Equal(ip) {
+				return nil
+			}
+		}
+		s.IPAddresses = append(s.IPAddresses, ip)
+	default:
+		for _, e := range s.DNSNames {
+			if e == name {
+				return nil
+			}
+		}
+		s.DNSNames = append(s.DNSNames, name)
+	}
+	return nil
+}
+
+// isSANName returns if the name is in the form of a subject alternative name: a host name, optionally with a leading
+// "*." wildcard, an IP address, an email address or a URI. Other names, such as a person's name, are not.
+func isSANName(name string) bool {
+	if strings.ContainsAny(name, " \t") {
+		return false
+	}
+	switch {
+	case strings.Contains(name, "://"):
+		u, err := url.Parse(name)
+		return err == nil && u.Scheme != ""
+	case strings.Contains(name, "@"):
+		i := strings.LastIndex(name, "@")
+		return i > 0 && isHostname(name[i+1:])
+	case net.ParseIP(name) != nil:
+		return true
+	default:
+		return isHostname(strings.TrimPrefix(name, "*."))
+	}
+}
+
+// isHostname returns if the name is a syntactically valid DNS host name.
+func isHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, l := range strings.Split(name, ".") {
+		if l == "" || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+			return false
+		}
+		for _, c := range l {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
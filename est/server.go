@@ -0,0 +1,290 @@
+// Package est provides an RFC 7030 Enrollment over Secure Transport (EST) server that issues certificates from the CA.
+package est
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/certificate"
+	"github.com/jcmturner/pki/ledger"
+)
+
+const (
+	// PathPrefix is the well known path under which the EST operations are served.
+	PathPrefix = "/.well-known/est/"
+
+	certsOnlyContentType = "application/pkcs7-mime; smime-type=certs-only"
+	csrAttrsContentType  = "application/csrattrs"
+	pkcs10ContentType    = "application/pkcs10"
+	maxRequestSize       = 65536
+)
+
+// Server is an EST server that signs enrollment requests with the CA key using ca.Sign.
+//
+// Clients authenticate enrollment with a TLS client certificate issued by ClientCAs or, if BasicAuth is set, HTTP
+// basic authentication. Re-enrollment requires the TLS client certificate being renewed. Client certificates are
+// checked with certificate.VerifyClient, which describes the TLS config needed.
+type Server struct {
+	CAcrt *x509.Certificate
+	CAkey crypto.Signer
+	// Duration is the validity of issued certificates.
+	Duration time.Duration
+	// Chain holds the certificates of the CA's issuers returned with the CA certificate by /cacerts.
+	Chain []*x509.Certificate
+	// ClientCAs verifies the TLS client certificates used to authenticate enrollment. If nil client certificates only
+	// authenticate re-enrollment. Any certificate it issues for client authentication may enroll for any subject so it
+	// should be a dedicated client CA rather than the CA.
+	ClientCAs *x509.CertPool
+	// BasicAuth, if set, authenticates enrollment requests made with HTTP basic authentication.
+	BasicAuth func(user, pass string) bool
+	// CSRAttributes are the OIDs returned by /csrattrs that clients should include in their requests.
+	CSRAttributes []asn1.ObjectIdentifier
+	// Ledger, if set, records the certificates enrolled, failing the enrollment if it cannot. A TLS client certificate
+	// issued by the CA must be recorded in it, and not revoked, to authenticate.
+	Ledger ledger.Ledger
+	Logger *log.Logger
+}
+
+// New returns a Server that signs certificates valid for the duration provided with the CA key.
+func New(CAcrt *x509.Certificate, CAkey crypto.Signer, duration time.Duration) *Server {
+	return &Server{
+		CAcrt:    CAcrt,
+		CAkey:    CAkey,
+		Duration: duration,
+	}
+}
+
+// ServeHTTP handles the EST operations under PathPrefix. An optional CA label path segment is ignored.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, PathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	op := path.Base(r.URL.Path)
+	method := http.MethodPost
+	switch op {
+	case "cacerts", "csrattrs":
+		method = http.MethodGet
+	case "simpleenroll", "simplereenroll":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch op {
+	case "cacerts":
+		s.writeCerts(w, append([]*x509.Certificate{s.CAcrt}, s.Chain...))
+	case "csrattrs":
+		s.csrAttrs(w)
+	case "simpleenroll":
+		s.enroll(w, r, false)
+	case "simplereenroll":
+		s.enroll(w, r, true)
+	}
+}
+
+func (s *Server) csrAttrs(w http.ResponseWriter) {
+	if len(s.CSRAttributes) < 1 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	b, err := asn1.Marshal(s.CSRAttributes)
+	if err != nil {
+		s.logf("error encoding CSR attributes: %v", err)
+		http.Error(w, "could not encode CSR attributes", http.StatusInternalServerError)
+		return
+	}
+	s.writeBase64(w, csrAttrsContentType, b)
+}
+
+// enroll signs the CSR of the request. For re-enrollment the CSR must have the subject and subject alternative names
+// of the TLS client certificate being renewed.
+func (s *Server) enroll(w http.ResponseWriter, r *http.Request, renew bool) {
+	crt, err := s.clientCertificate(r, renew)
+	if err != nil {
+		s.logf("TLS client certificate rejected: %v", err)
+	}
+	switch {
+	case crt != nil:
+	case renew:
+		http.Error(w, "re-enrollment requires the TLS client certificate being renewed", http.StatusUnauthorized)
+		return
+	case !s.basicAuth(r):
+		if s.BasicAuth != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="est"`)
+		}
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	if crt != nil {
+		if err := s.checkRevoked(crt); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, pkcs10ContentType) {
+		http.Error(w, "content type must be "+pkcs10ContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, "could not read request", http.StatusBadRequest)
+		return
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(b)), ""))
+	if err != nil {
+		http.Error(w, "request is not base64 encoded", http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		http.Error(w, "could not parse certificate request", http.StatusBadRequest)
+		return
+	}
+	if err := csr.CheckSignature(); err != nil {
+		http.Error(w, "certificate request signature not valid", http.StatusBadRequest)
+		return
+	}
+	if renew {
+		if err := s.checkRenewal(crt, csr); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	issued, err := ca.Sign(csr, s.CAcrt, s.CAkey, s.Duration, rand.Reader)
+	if err != nil {
+		s.logf("error signing certificate for %s: %v", csr.Subject, err)
+		http.Error(w, "could not sign certificate", http.StatusInternalServerError)
+		return
+	}
+	if s.Ledger != nil {
+		if err := s.Ledger.Add(issued, ca.ProfileDefault); err != nil {
+			s.logf("error recording certificate %s in ledger: %v", issued.SerialNumber, err)
+			http.Error(w, "could not record certificate", http.StatusInternalServerError)
+			return
+		}
+	}
+	s.writeCerts(w, []*x509.Certificate{issued})
+}
+
+// clientCertificate returns the verified TLS client certificate of the request or nil if none was presented. For
+// re-enrollment the certificate must have been issued by the CA, otherwise by ClientCAs.
+func (s *Server) clientCertificate(r *http.Request, renew bool) (*x509.Certificate, error) {
+	roots := s.ClientCAs
+	if renew {
+		roots = x509.NewCertPool()
+		roots.AddCert(s.CAcrt)
+	} else if roots == nil {
+		return nil, nil
+	}
+	chains, err := certificate.VerifyClient(r.TLS, roots)
+	if err != nil || chains == nil {
+		return nil, err
+	}
+	return chains[0][0], nil
+}
+
+// checkRevoked checks a TLS client certificate issued by the CA is recorded in the ledger and has not been revoked.
+func (s *Server) checkRevoked(crt *x509.Certificate) error {
+	if s.Ledger == nil || crt.CheckSignatureFrom(s.CAcrt) != nil {
+		return nil
+	}
+	rec, err := s.Ledger.Get(crt.SerialNumber)
+	if err != nil {
+		s.logf("error checking certificate %s in ledger: %v", crt.SerialNumber, err)
+		return errors.New("could not check the TLS client certificate has not been revoked")
+	}
+	if rec.Status == ledger.StatusRevoked {
+		return errors.New("TLS client certificate has been revoked")
+	}
+	return nil
+}
+
+func (s *Server) basicAuth(r *http.Request) bool {
+	if s.BasicAuth == nil {
+		return false
+	}
+	user, pass, ok := r.BasicAuth()
+	return ok && s.BasicAuth(user, pass)
+}
+
+// checkRenewal checks the certificate being renewed has the subject and subject alternative names requested.
+func (s *Server) checkRenewal(crt *x509.Certificate, csr *x509.CertificateRequest) error {
+	if crt.Subject.String() != csr.Subject.String() {
+		return fmt.Errorf("subject %s does not match the certificate being renewed", csr.Subject)
+	}
+	if !equal(sans(crt.DNSNames, crt.IPAddresses, crt.EmailAddresses, crt.URIs),
+		sans(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs)) {
+		return errors.New("subject alternative names do not match the certificate being renewed")
+	}
+	return nil
+}
+
+// sans returns the subject alternative names in string form.
+func sans(dns []string, ips []net.IP, emails []string, uris []*url.URL) []string {
+	s := append([]string{}, dns...)
+	for _, ip := range ips {
+		s = append(s, ip.String())
+	}
+	s = append(s, emails...)
+	for _, u := range uris {
+		s = append(s, u.String())
+	}
+	return s
+}
+
+func (s *Server) writeCerts(w http.ResponseWriter, certs []*x509.Certificate) {
+	b, err := certificate.EncodePKCS7(certs)
+	if err != nil {
+		s.logf("error encoding PKCS#7: %v", err)
+		http.Error(w, "could not encode certificates", http.StatusInternalServerError)
+		return
+	}
+	s.writeBase64(w, certsOnlyContentType, b)
+}
+
+func (s *Server) writeBase64(w http.ResponseWriter, contentType string, b []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(base64.StdEncoding.EncodeToString(b)))
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+func equal(a, b []string) bool {
+	sort.Strings(a)
+	sort.Strings(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
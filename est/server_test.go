@@ -0,0 +1,236 @@
+package est
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/certificate"
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/internal/testpki"
+	"github.com/jcmturner/pki/ledger"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	caCert, caKey := testpki.CA(t, "Test CA", csr.ECDSAP256)
+	s := New(caCert, caKey, time.Hour)
+	s.BasicAuth = func(user, pass string) bool { return user == "device" && pass == "secret" }
+	hs := httptest.NewUnstartedServer(s)
+	hs.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	hs.StartTLS()
+	t.Cleanup(hs.Close)
+	return s, hs
+}
+
+// client returns an HTTP client trusting the test server that presents the client certificate, if not nil.
+func client(hs *httptest.Server, crt *x509.Certificate, key crypto.Signer) *http.Client {
+	cl := hs.Client()
+	if crt != nil {
+		tr := cl.Transport.(*http.Transport).Clone()
+		tr.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{crt.Raw}, PrivateKey: key}}
+		cl = &http.Client{Transport: tr}
+	}
+	return cl
+}
+
+func enroll(t *testing.T, cl *http.Client, url string, r *x509.CertificateRequest, user, pass string) (*http.Response, []*x509.Certificate) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader([]byte(base64.StdEncoding.EncodeToString(r.Raw))))
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", pkcs10ContentType)
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		t.Fatalf("error posting to %s: %v", url, err)
+	}
+	return resp, readCerts(t, resp)
+}
+
+// readCerts returns the certificates of a successful certs-only response.
+func readCerts(t *testing.T, resp *http.Response) []*x509.Certificate {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	assert.Equal(t, certsOnlyContentType, resp.Header.Get("Content-Type"), "content type not as expected")
+	b, _ := ioutil.ReadAll(resp.Body)
+	der, err := base64.StdEncoding.DecodeString(string(b))
+	if err != nil {
+		t.Fatalf("response not base64 encoded: %v", err)
+	}
+	bundle, err := certificate.Parse(der, "")
+	if err != nil {
+		t.Fatalf("error parsing PKCS#7 response: %v", err)
+	}
+	assert.Equal(t, certificate.FormatPKCS7, bundle.Format, "response format not as expected")
+	return bundle.Chain
+}
+
+func TestServer_CACerts(t *testing.T) {
+	s, hs := newTestServer(t)
+	resp, err := hs.Client().Get(hs.URL + PathPrefix + "cacerts")
+	if err != nil {
+		t.Fatalf("error getting CA certificates: %v", err)
+	}
+	certs := readCerts(t, resp)
+	if assert.Len(t, certs, 1, "CA certificates not as expected") {
+		assert.True(t, certs[0].Equal(s.CAcrt), "CA certificate not as expected")
+	}
+
+	resp, err = hs.Client().Post(hs.URL+PathPrefix+"cacerts", pkcs10ContentType, nil)
+	if err != nil {
+		t.Fatalf("error posting: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode, "status not as expected")
+}
+
+func TestServer_CSRAttrs(t *testing.T) {
+	s, hs := newTestServer(t)
+	resp, err := hs.Client().Get(hs.URL + PathPrefix + "csrattrs")
+	if err != nil {
+		t.Fatalf("error getting CSR attributes: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode, "status without attributes not as expected")
+
+	challengePassword := asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+	s.CSRAttributes = []asn1.ObjectIdentifier{challengePassword}
+	resp, err = hs.Client().Get(hs.URL + PathPrefix + "csrattrs")
+	if err != nil {
+		t.Fatalf("error getting CSR attributes: %v", err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, csrAttrsContentType, resp.Header.Get("Content-Type"), "content type not as expected")
+	b, _ := ioutil.ReadAll(resp.Body)
+	der, err := base64.StdEncoding.DecodeString(string(b))
+	if err != nil {
+		t.Fatalf("response not base64 encoded: %v", err)
+	}
+	var oids []asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(der, &oids); err != nil {
+		t.Fatalf("error decoding CSR attributes: %v", err)
+	}
+	assert.Equal(t, s.CSRAttributes, oids, "CSR attributes not as expected")
+}
+
+func TestServer_Enroll(t *testing.T) {
+	s, hs := newTestServer(t)
+	dir, err := ioutil.TempDir("", "est")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := ledger.Open(filepath.Join(dir, "ledger.jsonl"))
+	if err != nil {
+		t.Fatalf("error opening ledger: %v", err)
+	}
+	s.Ledger = l
+	url := hs.URL + PathPrefix + "simpleenroll"
+
+	r, key := testpki.CSR(t, "device.test.local", csr.ECDSAP256, "10.0.0.1")
+	resp, _ := enroll(t, hs.Client(), url, r, "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "enrollment without authentication should be refused")
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Basic", "authentication challenge not as expected")
+	resp, _ = enroll(t, hs.Client(), url, r, "device", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "enrollment with bad credentials should be refused")
+
+	resp, certs := enroll(t, hs.Client(), url, r, "device", "secret")
+	if !assert.Len(t, certs, 1, "enrolled certificate not returned: %d", resp.StatusCode) {
+		t.FailNow()
+	}
+	crt := certs[0]
+	assert.Equal(t, "CN=device.test.local", crt.Subject.String(), "subject not as expected")
+	assert.NoError(t, crt.CheckSignatureFrom(s.CAcrt), "certificate not signed by the CA")
+	_, err = l.Get(crt.SerialNumber)
+	assert.NoError(t, err, "certificate not recorded in ledger")
+
+	// Client certificates only authenticate enrollment if issued by ClientCAs.
+	other, _ := testpki.CSR(t, "other.test.local", csr.ECDSAP256)
+	resp, _ = enroll(t, client(hs, crt, key), url, other, "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "enrollment with a CA issued certificate should be refused without ClientCAs")
+	clientCAcrt, clientCAkey := testpki.CA(t, "Test Client CA", csr.ECDSAP256)
+	s.ClientCAs = x509.NewCertPool()
+	s.ClientCAs.AddCert(clientCAcrt)
+	ccrt, ckey := testpki.Issue(t, clientCAcrt, clientCAkey, "enroller.test.local")
+	_, certs = enroll(t, client(hs, ccrt, ckey), url, other, "", "")
+	assert.Len(t, certs, 1, "enrollment with a ClientCAs issued certificate should succeed")
+	resp, _ = enroll(t, client(hs, crt, key), url, other, "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "enrollment with a CA issued certificate should be refused with ClientCAs")
+
+	// Re-enrollment requires the certificate being renewed and a request for the same names.
+	reurl := hs.URL + PathPrefix + "simplereenroll"
+	renewal, renewalKey := testpki.CSR(t, "device.test.local", csr.ECDSAP256, "10.0.0.1")
+	resp, _ = enroll(t, hs.Client(), reurl, renewal, "device", "secret")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "re-enrollment without client certificate should be refused")
+	resp, _ = enroll(t, client(hs, crt, key), reurl, other, "", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "re-enrollment for a different subject should be refused")
+	changed, _ := testpki.CSR(t, "device.test.local", csr.ECDSAP256, "10.0.0.2")
+	resp, _ = enroll(t, client(hs, crt, key), reurl, changed, "", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "re-enrollment for different SANs should be refused")
+	_, certs = enroll(t, client(hs, crt, key), reurl, renewal, "", "")
+	if assert.Len(t, certs, 1, "renewed certificate not returned") {
+		assert.Equal(t, crt.Subject.String(), certs[0].Subject.String(), "renewed subject not as expected")
+		assert.NotEqual(t, crt.SerialNumber, certs[0].SerialNumber, "renewed certificate should be new")
+	}
+
+	if err := l.Revoke(crt.SerialNumber, ca.ReasonKeyCompromise, time.Now()); err != nil {
+		t.Fatalf("error revoking certificate: %v", err)
+	}
+	resp, _ = enroll(t, client(hs, crt, key), reurl, renewal, "", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "re-enrollment of a revoked certificate should be refused")
+
+	// Certificates issued by the CA but not recorded in the ledger are not accepted.
+	unrecorded := testpki.Sign(t, renewal, s.CAcrt, s.CAkey)
+	resp, _ = enroll(t, client(hs, unrecorded, renewalKey), reurl, renewal, "", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "re-enrollment of an unrecorded certificate should be refused")
+
+	// Client certificates issued by the CA are checked against the ledger for enrollment too.
+	s.ClientCAs.AddCert(s.CAcrt)
+	resp, _ = enroll(t, client(hs, crt, key), url, other, "", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "enrollment with a revoked certificate should be refused")
+
+	// Certificates not issued by the CA are not accepted.
+	self, selfKey := testpki.CSR(t, "device.test.local", csr.ECDSAP256, "10.0.0.1")
+	selfCert, err := ca.New(self, selfKey, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating self-signed certificate: %v", err)
+	}
+	resp, _ = enroll(t, client(hs, selfCert, selfKey), reurl, renewal, "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "re-enrollment with a foreign certificate should be refused")
+}
+
+// failingLedger is a ledger that cannot record certificates.
+type failingLedger struct {
+	ledger.Ledger
+}
+
+func (failingLedger) Add(crt *x509.Certificate, profile string) error {
+	return errors.New("ledger unavailable")
+}
+
+func TestServer_EnrollLedgerError(t *testing.T) {
+	s, hs := newTestServer(t)
+	s.Ledger = failingLedger{}
+	r, _ := testpki.CSR(t, "device.test.local", csr.ECDSAP256)
+	resp, certs := enroll(t, hs.Client(), hs.URL+PathPrefix+"simpleenroll", r, "device", "secret")
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "enrollment status not as expected")
+	assert.Empty(t, certs, "certificate not recorded in the ledger should not be returned")
+}
@@ -1,5 +1,21 @@
 module github.com/jcmturner/pki
 
-go 1.13
+go 1.21
 
-require github.com/aws/aws-sdk-go-v2 v0.15.0
+require (
+	github.com/aws/aws-sdk-go-v2 v0.15.0
+	github.com/stretchr/testify v1.8.4
+	golang.org/x/crypto v0.21.0
+	golang.org/x/net v0.21.0
+	golang.org/x/term v0.18.0
+	gopkg.in/yaml.v3 v3.0.1
+	software.sslmate.com/src/go-pkcs12 v0.5.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/jcmturner/pki/inspect"
+)
+
+func main() {
+	in := flag.String("in", "-", "PEM or DER file of certificates, certificate signing requests or CRLs to inspect. Use - for stdin")
+	j := flag.Bool("json", false, "Output JSON instead of text")
+	flag.Parse()
+
+	var (
+		b   []byte
+		err error
+	)
+	if *in == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(*in)
+	}
+	if err != nil {
+		log.Fatalf("could not read input: %v", err)
+	}
+	infos, err := inspect.Parse(b)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *j {
+		err = inspect.WriteJSON(infos, os.Stdout)
+	} else {
+		err = inspect.WriteText(infos, os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
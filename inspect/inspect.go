@@ -0,0 +1,390 @@
+// Package inspect decodes certificates, certificate signing requests (CSRs) and certificate revocation lists (CRLs)
+// into human readable text and JSON.
+package inspect
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+)
+
+// Object types described.
+const (
+	TypeCertificate    = "certificate"
+	TypeRequest        = "certificate request"
+	TypeRevocationList = "revocation list"
+)
+
+// Info describes a certificate, CSR or CRL. Fields not applicable to the type are empty.
+type Info struct {
+	Type               string            `json:"type"`
+	Version            int               `json:"version"`
+	SerialNumber       string            `json:"serial_number,omitempty"`
+	Subject            string            `json:"subject,omitempty"`
+	Issuer             string            `json:"issuer,omitempty"`
+	NotBefore          *time.Time        `json:"not_before,omitempty"`
+	NotAfter           *time.Time        `json:"not_after,omitempty"`
+	SANs               *SANs             `json:"sans,omitempty"`
+	PublicKey          *PublicKey        `json:"public_key,omitempty"`
+	SignatureAlgorithm string            `json:"signature_algorithm"`
+	KeyUsage           []string          `json:"key_usage,omitempty"`
+	ExtKeyUsage        []string          `json:"ext_key_usage,omitempty"`
+	BasicConstraints   *BasicConstraints `json:"basic_constraints,omitempty"`
+	SubjectKeyID       string            `json:"subject_key_id,omitempty"`
+	AuthorityKeyID     string            `json:"authority_key_id,omitempty"`
+	OCSPServers        []string          `json:"ocsp_servers,omitempty"`
+	IssuingCertURLs    []string          `json:"issuing_certificate_urls,omitempty"`
+	CRLDistPoints      []string          `json:"crl_distribution_points,omitempty"`
+	ThisUpdate         *time.Time        `json:"this_update,omitempty"`
+	NextUpdate         *time.Time        `json:"next_update,omitempty"`
+	CRLNumber          string            `json:"crl_number,omitempty"`
+	Revoked            []Revoked         `json:"revoked,omitempty"`
+	Extensions         []Extension       `json:"extensions,omitempty"`
+	Fingerprints       Fingerprints      `json:"fingerprints"`
+}
+
+// SANs are the subject alternative names.
+type SANs struct {
+	DNSNames       []string `json:"dns_names,omitempty"`
+	IPAddresses    []string `json:"ip_addresses,omitempty"`
+	EmailAddresses []string `json:"email_addresses,omitempty"`
+	URIs           []string `json:"uris,omitempty"`
+}
+
+// PublicKey describes the subject public key.
+type PublicKey struct {
+	Algorithm string `json:"algorithm"`
+	Size      int    `json:"size"`
+	Curve     string `json:"curve,omitempty"`
+}
+
+// BasicConstraints extension values. MaxPathLen is nil if unconstrained.
+type BasicConstraints struct {
+	IsCA       bool `json:"is_ca"`
+	MaxPathLen *int `json:"max_path_len,omitempty"`
+}
+
+// Revoked is an entry of a CRL.
+type Revoked struct {
+	SerialNumber   string    `json:"serial_number"`
+	RevocationTime time.Time `json:"revocation_time"`
+	Reason         string    `json:"reason,omitempty"`
+}
+
+// Extension is an X.509 extension. Value is the hex encoded DER value.
+type Extension struct {
+	OID      string `json:"oid"`
+	Name     string `json:"name,omitempty"`
+	Critical bool   `json:"critical"`
+	Value    string `json:"value"`
+}
+
+// Fingerprints are digests of the DER encoding.
+type Fingerprints struct {
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+}
+
+var extensionNames = map[string]string{
+	"2.5.29.14":               "Subject Key Identifier",
+	"2.5.29.15":               "Key Usage",
+	"2.5.29.17":               "Subject Alternative Name",
+	"2.5.29.18":               "Issuer Alternative Name",
+	"2.5.29.19":               "Basic Constraints",
+	"2.5.29.20":               "CRL Number",
+	"2.5.29.21":               "CRL Reason",
+	"2.5.29.27":               "Delta CRL Indicator",
+	"2.5.29.30":               "Name Constraints",
+	"2.5.29.31":               "CRL Distribution Points",
+	"2.5.29.32":               "Certificate Policies",
+	"2.5.29.35":               "Authority Key Identifier",
+	"2.5.29.37":               "Extended Key Usage",
+	"1.3.6.1.5.5.7.1.1":       "Authority Information Access",
+	"1.3.6.1.5.5.7.48.1.5":    "OCSP No Check",
+	"1.3.6.1.4.1.11129.2.4.2": "CT Precertificate SCTs",
+}
+
+var keyUsageNames = []struct {
+	ku   x509.KeyUsage
+	name string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Content Commitment"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:                            "Any",
+	x509.ExtKeyUsageServerAuth:                     "Server Authentication",
+	x509.ExtKeyUsageClientAuth:                     "Client Authentication",
+	x509.ExtKeyUsageCodeSigning:                    "Code Signing",
+	x509.ExtKeyUsageEmailProtection:                "Email Protection",
+	x509.ExtKeyUsageIPSECEndSystem:                 "IPSec End System",
+	x509.ExtKeyUsageIPSECTunnel:                    "IPSec Tunnel",
+	x509.ExtKeyUsageIPSECUser:                      "IPSec User",
+	x509.ExtKeyUsageTimeStamping:                   "Time Stamping",
+	x509.ExtKeyUsageOCSPSigning:                    "OCSP Signing",
+	x509.ExtKeyUsageMicrosoftServerGatedCrypto:     "Microsoft Server Gated Crypto",
+	x509.ExtKeyUsageNetscapeServerGatedCrypto:      "Netscape Server Gated Crypto",
+	x509.ExtKeyUsageMicrosoftCommercialCodeSigning: "Microsoft Commercial Code Signing",
+	x509.ExtKeyUsageMicrosoftKernelCodeSigning:     "Microsoft Kernel Code Signing",
+}
+
+// Certificate returns the description of the certificate.
+func Certificate(c *x509.Certificate) *Info {
+	i := &Info{
+		Type:               TypeCertificate,
+		Version:            c.Version,
+		SerialNumber:       hexSerial(c.SerialNumber),
+		Subject:            c.Subject.String(),
+		Issuer:             c.Issuer.String(),
+		NotBefore:          timePtr(c.NotBefore),
+		NotAfter:           timePtr(c.NotAfter),
+		SANs:               sans(c.DNSNames, c.IPAddresses, c.EmailAddresses, c.URIs),
+		PublicKey:          publicKey(c.PublicKey),
+		SignatureAlgorithm: c.SignatureAlgorithm.String(),
+		KeyUsage:           keyUsage(c.KeyUsage),
+		ExtKeyUsage:        extKeyUsage(c.ExtKeyUsage, c.UnknownExtKeyUsage),
+		SubjectKeyID:       colonHex(c.SubjectKeyId),
+		AuthorityKeyID:     colonHex(c.AuthorityKeyId),
+		OCSPServers:        c.OCSPServer,
+		IssuingCertURLs:    c.IssuingCertificateURL,
+		CRLDistPoints:      c.CRLDistributionPoints,
+		Extensions:         extensions(c.Extensions),
+		Fingerprints:       fingerprints(c.Raw),
+	}
+	if c.BasicConstraintsValid {
+		i.BasicConstraints = &BasicConstraints{IsCA: c.IsCA}
+		if c.MaxPathLen > 0 || c.MaxPathLenZero {
+			l := c.MaxPathLen
+			i.BasicConstraints.MaxPathLen = &l
+		}
+	}
+	return i
+}
+
+// Request returns the description of the certificate signing request.
+func Request(r *x509.CertificateRequest) *Info {
+	return &Info{
+		Type:               TypeRequest,
+		Version:            r.Version + 1,
+		Subject:            r.Subject.String(),
+		SANs:               sans(r.DNSNames, r.IPAddresses, r.EmailAddresses, r.URIs),
+		PublicKey:          publicKey(r.PublicKey),
+		SignatureAlgorithm: r.SignatureAlgorithm.String(),
+		Extensions:         extensions(r.Extensions),
+		Fingerprints:       fingerprints(r.Raw),
+	}
+}
+
+// RevocationList returns the description of the certificate revocation list.
+func RevocationList(crl *x509.RevocationList) *Info {
+	i := &Info{
+		Type:               TypeRevocationList,
+		Version:            2,
+		Issuer:             crl.Issuer.String(),
+		SignatureAlgorithm: crl.SignatureAlgorithm.String(),
+		AuthorityKeyID:     colonHex(crl.AuthorityKeyId),
+		ThisUpdate:         timePtr(crl.ThisUpdate),
+		Extensions:         extensions(crl.Extensions),
+		Fingerprints:       fingerprints(crl.Raw),
+	}
+	if !crl.NextUpdate.IsZero() {
+		i.NextUpdate = timePtr(crl.NextUpdate)
+	}
+	if crl.Number != nil {
+		i.CRLNumber = crl.Number.String()
+	}
+	for _, r := range crl.RevokedCertificateEntries {
+		e := Revoked{SerialNumber: hexSerial(r.SerialNumber), RevocationTime: r.RevocationTime}
+		if r.ReasonCode != 0 {
+			e.Reason = ca.RevocationReason(r.ReasonCode).String()
+		}
+		i.Revoked = append(i.Revoked, e)
+	}
+	return i
+}
+
+// Parse returns the descriptions of the certificates, CSRs and CRLs in the PEM or DER encoded bytes.
+func Parse(b []byte) ([]*Info, error) {
+	if !bytes.Contains(b, []byte("-----BEGIN ")) {
+		i, err := parseDER(b)
+		if err != nil {
+			return nil, err
+		}
+		return []*Info{i}, nil
+	}
+	var infos []*Info
+	rest := b
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		var (
+			i   *Info
+			err error
+		)
+		switch block.Type {
+		case "CERTIFICATE", "X509 CERTIFICATE", "TRUSTED CERTIFICATE":
+			var c *x509.Certificate
+			c, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				i = Certificate(c)
+			}
+		case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+			var r *x509.CertificateRequest
+			r, err = x509.ParseCertificateRequest(block.Bytes)
+			if err == nil {
+				i = Request(r)
+			}
+		case "X509 CRL":
+			var crl *x509.RevocationList
+			crl, err = x509.ParseRevocationList(block.Bytes)
+			if err == nil {
+				i = RevocationList(crl)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %v", strings.ToLower(block.Type), err)
+		}
+		infos = append(infos, i)
+	}
+	if len(infos) == 0 {
+		return nil, errors.New("no certificates, certificate requests or revocation lists found")
+	}
+	return infos, nil
+}
+
+func parseDER(b []byte) (*Info, error) {
+	if c, err := x509.ParseCertificate(b); err == nil {
+		return Certificate(c), nil
+	}
+	if r, err := x509.ParseCertificateRequest(b); err == nil {
+		return Request(r), nil
+	}
+	if crl, err := x509.ParseRevocationList(b); err == nil {
+		return RevocationList(crl), nil
+	}
+	return nil, errors.New("not a DER encoded certificate, certificate request or revocation list")
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+// colonHex returns the bytes as upper case hex separated by colons as displayed by OpenSSL.
+func colonHex(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	s := make([]string, len(b))
+	for i, c := range b {
+		s[i] = fmt.Sprintf("%02X", c)
+	}
+	return strings.Join(s, ":")
+}
+
+func hexSerial(n *big.Int) string {
+	if n == nil {
+		return ""
+	}
+	b := n.Bytes()
+	if len(b) == 0 {
+		b = []byte{0}
+	}
+	return colonHex(b)
+}
+
+func sans(dns []string, ips []net.IP, emails []string, uris []*url.URL) *SANs {
+	if len(dns)+len(ips)+len(emails)+len(uris) == 0 {
+		return nil
+	}
+	s := &SANs{DNSNames: dns, EmailAddresses: emails}
+	for _, ip := range ips {
+		s.IPAddresses = append(s.IPAddresses, ip.String())
+	}
+	for _, u := range uris {
+		s.URIs = append(s.URIs, u.String())
+	}
+	return s
+}
+
+func publicKey(pub interface{}) *PublicKey {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return &PublicKey{Algorithm: "RSA", Size: k.N.BitLen()}
+	case *ecdsa.PublicKey:
+		return &PublicKey{Algorithm: "ECDSA", Size: k.Curve.Params().BitSize, Curve: k.Curve.Params().Name}
+	case ed25519.PublicKey:
+		return &PublicKey{Algorithm: "Ed25519", Size: 256}
+	default:
+		return &PublicKey{Algorithm: fmt.Sprintf("%T", pub)}
+	}
+}
+
+func keyUsage(ku x509.KeyUsage) []string {
+	var s []string
+	for _, n := range keyUsageNames {
+		if ku&n.ku != 0 {
+			s = append(s, n.name)
+		}
+	}
+	return s
+}
+
+func extKeyUsage(eku []x509.ExtKeyUsage, unknown []asn1.ObjectIdentifier) []string {
+	var s []string
+	for _, u := range eku {
+		if n, ok := extKeyUsageNames[u]; ok {
+			s = append(s, n)
+		} else {
+			s = append(s, fmt.Sprintf("ExtKeyUsage(%d)", u))
+		}
+	}
+	for _, oid := range unknown {
+		s = append(s, oid.String())
+	}
+	return s
+}
+
+func extensions(exts []pkix.Extension) []Extension {
+	var s []Extension
+	for _, e := range exts {
+		oid := e.Id.String()
+		s = append(s, Extension{OID: oid, Name: extensionNames[oid], Critical: e.Critical, Value: hex.EncodeToString(e.Value)})
+	}
+	return s
+}
+
+func fingerprints(der []byte) Fingerprints {
+	s1 := sha1.Sum(der)
+	s256 := sha256.Sum256(der)
+	return Fingerprints{SHA1: colonHex(s1[:]), SHA256: colonHex(s256[:])}
+}
@@ -0,0 +1,153 @@
+package inspect
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/certificate"
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/internal/testpki"
+	"github.com/stretchr/testify/assert"
+)
+
+type testObjects struct {
+	caCert *x509.Certificate
+	cert   *x509.Certificate
+	req    *x509.CertificateRequest
+	crl    *x509.RevocationList
+}
+
+func newTestObjects(t *testing.T) testObjects {
+	caCert, caKey := testpki.CA(t, "Test CA", csr.RSA2048)
+	r, _ := testpki.CSR(t, "host.test.local", csr.RSA2048, "host.test.local", "10.0.0.1")
+	crt := testpki.Sign(t, r, caCert, caKey)
+	revoked := []ca.Revocation{{SerialNumber: crt.SerialNumber, RevokedAt: time.Now(), Reason: ca.ReasonKeyCompromise}}
+	crl, err := ca.CreateCRL(caCert, caKey, revoked, big.NewInt(7), time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CRL: %v", err)
+	}
+	return testObjects{caCert: caCert, cert: crt, req: r, crl: crl}
+}
+
+func TestCertificate(t *testing.T) {
+	o := newTestObjects(t)
+	i := Certificate(o.cert)
+	assert.Equal(t, TypeCertificate, i.Type, "type not as expected")
+	assert.Equal(t, 3, i.Version, "version not as expected")
+	assert.Equal(t, "CN=host.test.local", i.Subject, "subject not as expected")
+	assert.Equal(t, "CN=Test CA", i.Issuer, "issuer not as expected")
+	assert.Equal(t, o.cert.NotAfter, *i.NotAfter, "not after not as expected")
+	assert.Equal(t, []string{"host.test.local"}, i.SANs.DNSNames, "DNS names not as expected")
+	assert.Equal(t, []string{"10.0.0.1"}, i.SANs.IPAddresses, "IP addresses not as expected")
+	assert.Equal(t, "RSA", i.PublicKey.Algorithm, "key algorithm not as expected")
+	assert.Equal(t, 2048, i.PublicKey.Size, "key size not as expected")
+	assert.Contains(t, i.KeyUsage, "Digital Signature", "key usage not as expected")
+	assert.Contains(t, i.ExtKeyUsage, "Server Authentication", "extended key usage not as expected")
+	assert.Equal(t, Certificate(o.caCert).SubjectKeyID, i.AuthorityKeyID, "AKI should match the CA SKI")
+	assert.Len(t, strings.Split(i.Fingerprints.SHA256, ":"), 32, "SHA256 fingerprint not as expected")
+	assert.Equal(t, strings.ToUpper(strings.Join(splitHex(o.cert.SerialNumber.Text(16)), ":")), i.SerialNumber, "serial not as expected")
+
+	caInfo := Certificate(o.caCert)
+	if assert.NotNil(t, caInfo.BasicConstraints, "CA basic constraints missing") {
+		assert.True(t, caInfo.BasicConstraints.IsCA, "CA should be marked as a CA")
+	}
+	assert.Contains(t, caInfo.KeyUsage, "Certificate Sign", "CA key usage not as expected")
+
+	var names []string
+	for _, e := range i.Extensions {
+		names = append(names, e.Name)
+	}
+	assert.Contains(t, names, "Subject Alternative Name", "extensions not as expected")
+	assert.Contains(t, names, "Authority Key Identifier", "extensions not as expected")
+}
+
+// splitHex splits the hex string into pairs, left padding to an even length.
+func splitHex(s string) []string {
+	if len(s)%2 != 0 {
+		s = "0" + s
+	}
+	var p []string
+	for i := 0; i < len(s); i += 2 {
+		p = append(p, s[i:i+2])
+	}
+	return p
+}
+
+func TestRequest(t *testing.T) {
+	o := newTestObjects(t)
+	i := Request(o.req)
+	assert.Equal(t, TypeRequest, i.Type, "type not as expected")
+	assert.Equal(t, "CN=host.test.local", i.Subject, "subject not as expected")
+	assert.Equal(t, []string{"host.test.local"}, i.SANs.DNSNames, "DNS names not as expected")
+	assert.Equal(t, "SHA256-RSA", i.SignatureAlgorithm, "signature algorithm not as expected")
+	assert.Nil(t, i.NotAfter, "CSR should not have validity")
+}
+
+func TestRevocationList(t *testing.T) {
+	o := newTestObjects(t)
+	i := RevocationList(o.crl)
+	assert.Equal(t, TypeRevocationList, i.Type, "type not as expected")
+	assert.Equal(t, "CN=Test CA", i.Issuer, "issuer not as expected")
+	assert.Equal(t, "7", i.CRLNumber, "CRL number not as expected")
+	assert.NotNil(t, i.NextUpdate, "next update missing")
+	if assert.Len(t, i.Revoked, 1, "revoked entries not as expected") {
+		assert.Equal(t, Certificate(o.cert).SerialNumber, i.Revoked[0].SerialNumber, "revoked serial not as expected")
+		assert.Equal(t, "keyCompromise", i.Revoked[0].Reason, "revocation reason not as expected")
+	}
+}
+
+func TestParse(t *testing.T) {
+	o := newTestObjects(t)
+	var pemIn bytes.Buffer
+	pemIn.Write(certificate.PEMEncode(o.cert))
+	pemIn.Write(csr.PEMEncode(o.req))
+	pemIn.Write(ca.PEMEncodeCRL(o.crl))
+
+	infos, err := Parse(pemIn.Bytes())
+	if err != nil {
+		t.Fatalf("error parsing PEM: %v", err)
+	}
+	if assert.Len(t, infos, 3, "number of objects not as expected") {
+		assert.Equal(t, TypeCertificate, infos[0].Type, "first object type not as expected")
+		assert.Equal(t, TypeRequest, infos[1].Type, "second object type not as expected")
+		assert.Equal(t, TypeRevocationList, infos[2].Type, "third object type not as expected")
+	}
+
+	for _, der := range [][]byte{o.cert.Raw, o.req.Raw, o.crl.Raw} {
+		infos, err := Parse(der)
+		if err != nil {
+			t.Errorf("error parsing DER: %v", err)
+			continue
+		}
+		assert.Len(t, infos, 1, "number of objects not as expected")
+	}
+
+	_, err = Parse([]byte("not a certificate"))
+	assert.Error(t, err, "parsing garbage should error")
+
+	var text bytes.Buffer
+	if err := WriteText(infos, &text); err != nil {
+		t.Fatalf("error writing text: %v", err)
+	}
+	for _, s := range []string{"Certificate:", "Certificate Request:", "Certificate Revocation List:", "Subject: CN=host.test.local",
+		"DNS: host.test.local", "IP Address: 10.0.0.1", "Not After :", "Key Usage: Digital Signature", "Reason: keyCompromise", "SHA256: "} {
+		assert.Contains(t, text.String(), s, "text output not as expected")
+	}
+
+	var js bytes.Buffer
+	if err := WriteJSON(infos, &js); err != nil {
+		t.Fatalf("error writing JSON: %v", err)
+	}
+	var decoded []*Info
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("error decoding JSON: %v", err)
+	}
+	assert.Equal(t, infos[0].Fingerprints, decoded[0].Fingerprints, "JSON fingerprints not as expected")
+}
@@ -0,0 +1,148 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// timeFormat matches that used by OpenSSL when displaying validity.
+const timeFormat = "Jan _2 15:04:05 2006 MST"
+
+var titles = map[string]string{
+	TypeCertificate:    "Certificate",
+	TypeRequest:        "Certificate Request",
+	TypeRevocationList: "Certificate Revocation List",
+}
+
+// String returns the description as text similar to that of openssl x509 -text.
+func (i *Info) String() string {
+	var b bytes.Buffer
+	i.WriteText(&b)
+	return b.String()
+}
+
+// WriteText writes the description as text similar to that of openssl x509 -text.
+func (i *Info) WriteText(w io.Writer) error {
+	t := &textWriter{w: w}
+	t.line(0, "%s:", titles[i.Type])
+	t.line(1, "Version: %d", i.Version)
+	t.field(1, "Serial Number", i.SerialNumber)
+	t.field(1, "Signature Algorithm", i.SignatureAlgorithm)
+	t.field(1, "Issuer", i.Issuer)
+	if i.NotBefore != nil {
+		t.line(1, "Validity:")
+		t.line(2, "Not Before: %s", i.NotBefore.UTC().Format(timeFormat))
+		t.line(2, "Not After : %s", i.NotAfter.UTC().Format(timeFormat))
+	}
+	if i.ThisUpdate != nil {
+		t.line(1, "Last Update: %s", i.ThisUpdate.UTC().Format(timeFormat))
+	}
+	if i.NextUpdate != nil {
+		t.line(1, "Next Update: %s", i.NextUpdate.UTC().Format(timeFormat))
+	}
+	t.field(1, "CRL Number", i.CRLNumber)
+	t.field(1, "Subject", i.Subject)
+	if i.PublicKey != nil {
+		k := fmt.Sprintf("%s %d bit", i.PublicKey.Algorithm, i.PublicKey.Size)
+		if i.PublicKey.Curve != "" {
+			k += " (" + i.PublicKey.Curve + ")"
+		}
+		t.line(1, "Public Key: %s", k)
+	}
+	if i.SANs != nil {
+		t.line(1, "Subject Alternative Names:")
+		t.list(2, "DNS", i.SANs.DNSNames)
+		t.list(2, "IP Address", i.SANs.IPAddresses)
+		t.list(2, "Email", i.SANs.EmailAddresses)
+		t.list(2, "URI", i.SANs.URIs)
+	}
+	t.list(1, "Key Usage", []string{strings.Join(i.KeyUsage, ", ")})
+	t.list(1, "Extended Key Usage", []string{strings.Join(i.ExtKeyUsage, ", ")})
+	if i.BasicConstraints != nil {
+		bc := fmt.Sprintf("CA:%s", strings.ToUpper(fmt.Sprint(i.BasicConstraints.IsCA)))
+		if i.BasicConstraints.MaxPathLen != nil {
+			bc += fmt.Sprintf(", pathlen:%d", *i.BasicConstraints.MaxPathLen)
+		}
+		t.line(1, "Basic Constraints: %s", bc)
+	}
+	t.field(1, "Subject Key Identifier", i.SubjectKeyID)
+	t.field(1, "Authority Key Identifier", i.AuthorityKeyID)
+	t.list(1, "OCSP", i.OCSPServers)
+	t.list(1, "CA Issuers", i.IssuingCertURLs)
+	t.list(1, "CRL Distribution Point", i.CRLDistPoints)
+	if len(i.Extensions) > 0 {
+		t.line(1, "Extensions:")
+		for _, e := range i.Extensions {
+			name := e.OID
+			if e.Name != "" {
+				name = e.Name + " (" + e.OID + ")"
+			}
+			if e.Critical {
+				name += " critical"
+			}
+			t.line(2, "%s", name)
+		}
+	}
+	if len(i.Revoked) > 0 {
+		t.line(1, "Revoked Certificates:")
+		for _, r := range i.Revoked {
+			t.line(2, "Serial Number: %s", r.SerialNumber)
+			t.line(3, "Revocation Date: %s", r.RevocationTime.UTC().Format(timeFormat))
+			t.field(3, "Reason", r.Reason)
+		}
+	}
+	t.line(1, "Fingerprints:")
+	t.line(2, "SHA1: %s", i.Fingerprints.SHA1)
+	t.line(2, "SHA256: %s", i.Fingerprints.SHA256)
+	return t.err
+}
+
+// WriteText writes the descriptions as text separated by blank lines.
+func WriteText(infos []*Info, w io.Writer) error {
+	for n, i := range infos {
+		if n > 0 {
+			fmt.Fprintln(w)
+		}
+		if err := i.WriteText(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the descriptions as a JSON array.
+func WriteJSON(infos []*Info, w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(infos)
+}
+
+// textWriter writes indented lines, retaining the first error.
+type textWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *textWriter) line(indent int, format string, a ...interface{}) {
+	if t.err != nil {
+		return
+	}
+	_, t.err = fmt.Fprintf(t.w, strings.Repeat("    ", indent)+format+"\n", a...)
+}
+
+// field writes the named value if it is not empty.
+func (t *textWriter) field(indent int, name, value string) {
+	if value != "" {
+		t.line(indent, "%s: %s", name, value)
+	}
+}
+
+// list writes each non empty value with the name.
+func (t *textWriter) list(indent int, name string, values []string) {
+	for _, v := range values {
+		t.field(indent, name, v)
+	}
+}
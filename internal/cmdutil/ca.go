@@ -0,0 +1,75 @@
+// Package cmdutil holds the loading shared by the commands that sign with a CA.
+package cmdutil
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/jcmturner/pki/certificate"
+	"github.com/jcmturner/pki/kmssigner"
+)
+
+// LoadCA returns the CA certificate file as a bundle, with the CA certificate first, holding the CA signing key.
+//
+// The key is the AWS KMS key of kmsARN if set, otherwise it is loaded from keyPath or, if empty, from the certificate
+// file. The passphrase of an encrypted file is read from passfile or, if empty, prompted for.
+func LoadCA(certPath, keyPath, passfile, kmsARN string) (*certificate.Bundle, error) {
+	cb, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA certificate file: %v", err)
+	}
+	var passphrase string
+	if certificate.IsEncryptedKey(cb) {
+		passphrase, err = certificate.ReadPassphrase(passfile, "CA passphrase: ", false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cab, err := certificate.Parse(cb, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("could not load CA certificate: %v", err)
+	}
+	if cab.Leaf() == nil {
+		return nil, errors.New("no certificate found in CA certificate file")
+	}
+
+	var key crypto.Signer
+	switch {
+	case kmsARN != "":
+		a, err := arn.Parse(kmsARN)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KMS key ARN: %v", err)
+		}
+		key, err = kmssigner.GetSigner(http.DefaultClient, a)
+		if err != nil {
+			return nil, err
+		}
+	case keyPath == "" && cab.Key != nil:
+		key = cab.Key
+	default:
+		kb, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA key file: %v", err)
+		}
+		if passphrase == "" && certificate.IsEncryptedKey(kb) {
+			passphrase, err = certificate.ReadPassphrase(passfile, "CA private key passphrase: ", false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		kbd, err := certificate.Parse(kb, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("could not load CA key: %v", err)
+		}
+		if kbd.Key == nil {
+			return nil, errors.New("no private key found in CA key file")
+		}
+		key = kbd.Key
+	}
+	cab.Key = key
+	return cab, nil
+}
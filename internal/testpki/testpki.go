@@ -0,0 +1,54 @@
+// Package testpki creates the throwaway CAs, requests and certificates used by tests.
+package testpki
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/csr"
+)
+
+// CA returns a self signed CA certificate, valid for a day, and its new key of the algorithm provided.
+func CA(t testing.TB, cn string, alg csr.KeyAlgorithm) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	r, key := CSR(t, cn, alg)
+	crt, err := ca.New(r, key, time.Hour*24, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CA %s: %v", cn, err)
+	}
+	return crt, key
+}
+
+// CSR returns a certificate request, and its new key of the algorithm provided, for the common name and subject
+// alternative names.
+func CSR(t testing.TB, cn string, alg csr.KeyAlgorithm, sans ...string) (*x509.CertificateRequest, crypto.Signer) {
+	t.Helper()
+	r, key, err := csr.NewWithKeyAlgorithm(pkix.Name{CommonName: cn}, append([]string{}, sans...), alg, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CSR for %s: %v", cn, err)
+	}
+	return r, key
+}
+
+// Sign returns the certificate for the request signed by the CA and valid for an hour.
+func Sign(t testing.TB, r *x509.CertificateRequest, caCert *x509.Certificate, caKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+	crt, err := ca.Sign(r, caCert, caKey, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing certificate for %s: %v", r.Subject, err)
+	}
+	return crt
+}
+
+// Issue returns a certificate signed by the CA, valid for an hour, for the common name and subject alternative names
+// with its new ECDSA P-256 key.
+func Issue(t testing.TB, caCert *x509.Certificate, caKey crypto.Signer, cn string, sans ...string) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	r, key := CSR(t, cn, csr.ECDSAP256, sans...)
+	return Sign(t, r, caCert, caKey), key
+}
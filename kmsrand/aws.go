@@ -98,6 +98,7 @@ func (k MockKMS) GenerateRandomRequest(i *kms.GenerateRandomInput) kms.GenerateR
 	}
 	return kms.GenerateRandomRequest{
 		Request: &aws.Request{
+			HTTPRequest: &http.Request{},
 			Data: &kms.GenerateRandomOutput{
 				Plaintext: b,
 			},
@@ -0,0 +1,142 @@
+package kmssigner
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// The version of aws-sdk-go-v2 in use predates the KMS asymmetric key operations so the Sign and GetPublicKey
+// request and response shapes are defined here and sent using the KMS service client's request handlers.
+
+const (
+	opSign         = "Sign"
+	opGetPublicKey = "GetPublicKey"
+
+	messageTypeDigest = "DIGEST"
+)
+
+// SigningAlgorithmSpec is the KMS name for a signing algorithm.
+type SigningAlgorithmSpec string
+
+const (
+	RSASSA_PSS_SHA_256        SigningAlgorithmSpec = "RSASSA_PSS_SHA_256"
+	RSASSA_PSS_SHA_384        SigningAlgorithmSpec = "RSASSA_PSS_SHA_384"
+	RSASSA_PSS_SHA_512        SigningAlgorithmSpec = "RSASSA_PSS_SHA_512"
+	RSASSA_PKCS1_V1_5_SHA_256 SigningAlgorithmSpec = "RSASSA_PKCS1_V1_5_SHA_256"
+	RSASSA_PKCS1_V1_5_SHA_384 SigningAlgorithmSpec = "RSASSA_PKCS1_V1_5_SHA_384"
+	RSASSA_PKCS1_V1_5_SHA_512 SigningAlgorithmSpec = "RSASSA_PKCS1_V1_5_SHA_512"
+	ECDSA_SHA_256             SigningAlgorithmSpec = "ECDSA_SHA_256"
+	ECDSA_SHA_384             SigningAlgorithmSpec = "ECDSA_SHA_384"
+	ECDSA_SHA_512             SigningAlgorithmSpec = "ECDSA_SHA_512"
+)
+
+// SignInput is the input to the KMS Sign operation.
+type SignInput struct {
+	_ struct{} `type:"structure"`
+
+	KeyId            *string              `min:"1" type:"string" required:"true"`
+	Message          []byte               `min:"1" type:"blob" required:"true" sensitive:"true"`
+	MessageType      string               `type:"string" enum:"true"`
+	SigningAlgorithm SigningAlgorithmSpec `type:"string" required:"true" enum:"true"`
+}
+
+// SignOutput is the output of the KMS Sign operation.
+type SignOutput struct {
+	_ struct{} `type:"structure"`
+
+	KeyId            *string              `min:"1" type:"string"`
+	Signature        []byte               `min:"1" type:"blob"`
+	SigningAlgorithm SigningAlgorithmSpec `type:"string" enum:"true"`
+}
+
+// GetPublicKeyInput is the input to the KMS GetPublicKey operation.
+type GetPublicKeyInput struct {
+	_ struct{} `type:"structure"`
+
+	KeyId *string `min:"1" type:"string" required:"true"`
+}
+
+// GetPublicKeyOutput is the output of the KMS GetPublicKey operation.
+// PublicKey is the DER encoded SubjectPublicKeyInfo of the CMK.
+type GetPublicKeyOutput struct {
+	_ struct{} `type:"structure"`
+
+	KeyId                 *string                `min:"1" type:"string"`
+	PublicKey             []byte                 `min:"1" type:"blob"`
+	CustomerMasterKeySpec string                 `type:"string" enum:"true"`
+	KeyUsage              string                 `type:"string" enum:"true"`
+	SigningAlgorithms     []SigningAlgorithmSpec `type:"list"`
+}
+
+// SignRequest is the request type for the Sign API operation.
+type SignRequest struct {
+	*aws.Request
+	Input *SignInput
+}
+
+// Send marshals and sends the Sign API request.
+func (r SignRequest) Send(ctx context.Context) (*SignOutput, error) {
+	r.Request.SetContext(ctx)
+	err := r.Request.Send()
+	if err != nil {
+		return nil, err
+	}
+	return r.Request.Data.(*SignOutput), nil
+}
+
+// GetPublicKeyRequest is the request type for the GetPublicKey API operation.
+type GetPublicKeyRequest struct {
+	*aws.Request
+	Input *GetPublicKeyInput
+}
+
+// Send marshals and sends the GetPublicKey API request.
+func (r GetPublicKeyRequest) Send(ctx context.Context) (*GetPublicKeyOutput, error) {
+	r.Request.SetContext(ctx)
+	err := r.Request.Send()
+	if err != nil {
+		return nil, err
+	}
+	return r.Request.Data.(*GetPublicKeyOutput), nil
+}
+
+// ClientAPI is the subset of the KMS API used by the Signer.
+type ClientAPI interface {
+	SignRequest(*SignInput) SignRequest
+	GetPublicKeyRequest(*GetPublicKeyInput) GetPublicKeyRequest
+}
+
+// Client implements ClientAPI using the AWS KMS service client.
+type Client struct {
+	*kms.Client
+}
+
+// SignRequest returns a request value for the KMS Sign operation.
+func (c Client) SignRequest(input *SignInput) SignRequest {
+	op := &aws.Operation{
+		Name:       opSign,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	if input == nil {
+		input = &SignInput{}
+	}
+	req := c.NewRequest(op, input, &SignOutput{})
+	return SignRequest{Request: req, Input: input}
+}
+
+// GetPublicKeyRequest returns a request value for the KMS GetPublicKey operation.
+func (c Client) GetPublicKeyRequest(input *GetPublicKeyInput) GetPublicKeyRequest {
+	op := &aws.Operation{
+		Name:       opGetPublicKey,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	if input == nil {
+		input = &GetPublicKeyInput{}
+	}
+	req := c.NewRequest(op, input, &GetPublicKeyOutput{})
+	return GetPublicKeyRequest{Request: req, Input: input}
+}
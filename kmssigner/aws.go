@@ -0,0 +1,208 @@
+// Package kmssigner provides a crypto.Signer backed by an asymmetric AWS KMS customer master key (CMK)
+// so that a CA private key never leaves KMS.
+package kmssigner
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// Signer is a crypto.Signer that signs digests using the KMS Sign operation.
+type Signer struct {
+	KMSsrv ClientAPI
+	KeyID  string
+	pub    crypto.PublicKey
+}
+
+// New returns a Signer for the KMS key ID or ARN provided.
+// The public key of the CMK is retrieved from KMS.
+func New(srv ClientAPI, keyID string) (*Signer, error) {
+	r := srv.GetPublicKeyRequest(&GetPublicKeyInput{
+		KeyId: &keyID,
+	})
+	out, err := r.Send(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("could not get public key of KMS key %s: %v", keyID, err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(out.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public key of KMS key %s: %v", keyID, err)
+	}
+	return &Signer{
+		KMSsrv: srv,
+		KeyID:  keyID,
+		pub:    pub,
+	}, nil
+}
+
+// GetSigner returns a Signer for the CMK ARN provided using the default AWS credentials.
+func GetSigner(cl *http.Client, cmkarn arn.ARN) (*Signer, error) {
+	cfg, err := loadAWSConfig(cl, cmkarn)
+	if err != nil {
+		return nil, err
+	}
+	return New(Client{kms.New(cfg)}, cmkarn.String())
+}
+
+// Public returns the public key of the CMK.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs the digest using the CMK. The rand argument is not used as signing is performed within KMS.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	alg, err := signingAlgorithm(s.pub, opts)
+	if err != nil {
+		return nil, err
+	}
+	r := s.KMSsrv.SignRequest(&SignInput{
+		KeyId:            &s.KeyID,
+		Message:          digest,
+		MessageType:      messageTypeDigest,
+		SigningAlgorithm: alg,
+	})
+	out, err := r.Send(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("KMS sign request failed: %v", err)
+	}
+	return out.Signature, nil
+}
+
+// signingAlgorithm returns the KMS signing algorithm for the public key type and signer options.
+func signingAlgorithm(pub crypto.PublicKey, opts crypto.SignerOpts) (SigningAlgorithmSpec, error) {
+	h := opts.HashFunc()
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			switch h {
+			case crypto.SHA256:
+				return RSASSA_PSS_SHA_256, nil
+			case crypto.SHA384:
+				return RSASSA_PSS_SHA_384, nil
+			case crypto.SHA512:
+				return RSASSA_PSS_SHA_512, nil
+			}
+		} else {
+			switch h {
+			case crypto.SHA256:
+				return RSASSA_PKCS1_V1_5_SHA_256, nil
+			case crypto.SHA384:
+				return RSASSA_PKCS1_V1_5_SHA_384, nil
+			case crypto.SHA512:
+				return RSASSA_PKCS1_V1_5_SHA_512, nil
+			}
+		}
+	case *ecdsa.PublicKey:
+		switch h {
+		case crypto.SHA256:
+			return ECDSA_SHA_256, nil
+		case crypto.SHA384:
+			return ECDSA_SHA_384, nil
+		case crypto.SHA512:
+			return ECDSA_SHA_512, nil
+		}
+	default:
+		return "", fmt.Errorf("unsupported KMS public key type: %T", pub)
+	}
+	return "", fmt.Errorf("unsupported hash function for KMS signing: %v", h)
+}
+
+// Backend is a signer.Backend for KMS keys. The key identifier is the KMS key ID or ARN.
+type Backend struct {
+	KMSsrv ClientAPI
+}
+
+// Signer returns a Signer for the KMS key ID or ARN provided.
+func (b Backend) Signer(id string) (crypto.Signer, error) {
+	return New(b.KMSsrv, id)
+}
+
+// loadAWSConfig loads the AWS API credentials and sets the region and HTTPClient returning an aws.Config.
+func loadAWSConfig(cl *http.Client, arn arn.ARN) (aws.Config, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("unable to load AWS SDK config: %v", err)
+	}
+	cfg.Region = arn.Region
+	cfg.HTTPClient = cl
+	return cfg, nil
+}
+
+// MockKMS implements ClientAPI using in process keys identified by key ID.
+type MockKMS struct {
+	Keys map[string]crypto.Signer
+}
+
+func (k MockKMS) SignRequest(i *SignInput) SignRequest {
+	req := &aws.Request{
+		HTTPRequest: &http.Request{},
+	}
+	key, ok := k.Keys[aws.StringValue(i.KeyId)]
+	if !ok {
+		req.Error = fmt.Errorf("NotFoundException: key %s not found", aws.StringValue(i.KeyId))
+		return SignRequest{Request: req, Input: i}
+	}
+	var opts crypto.SignerOpts
+	switch i.SigningAlgorithm {
+	case RSASSA_PKCS1_V1_5_SHA_256, ECDSA_SHA_256:
+		opts = crypto.SHA256
+	case RSASSA_PKCS1_V1_5_SHA_384, ECDSA_SHA_384:
+		opts = crypto.SHA384
+	case RSASSA_PKCS1_V1_5_SHA_512, ECDSA_SHA_512:
+		opts = crypto.SHA512
+	case RSASSA_PSS_SHA_256:
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	case RSASSA_PSS_SHA_384:
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA384}
+	case RSASSA_PSS_SHA_512:
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA512}
+	default:
+		req.Error = fmt.Errorf("ValidationException: unsupported signing algorithm %s", i.SigningAlgorithm)
+		return SignRequest{Request: req, Input: i}
+	}
+	sig, err := key.Sign(rand.Reader, i.Message, opts)
+	if err != nil {
+		req.Error = err
+		return SignRequest{Request: req, Input: i}
+	}
+	req.Data = &SignOutput{
+		KeyId:            i.KeyId,
+		Signature:        sig,
+		SigningAlgorithm: i.SigningAlgorithm,
+	}
+	return SignRequest{Request: req, Input: i}
+}
+
+func (k MockKMS) GetPublicKeyRequest(i *GetPublicKeyInput) GetPublicKeyRequest {
+	req := &aws.Request{
+		HTTPRequest: &http.Request{},
+	}
+	key, ok := k.Keys[aws.StringValue(i.KeyId)]
+	if !ok {
+		req.Error = fmt.Errorf("NotFoundException: key %s not found", aws.StringValue(i.KeyId))
+		return GetPublicKeyRequest{Request: req, Input: i}
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		req.Error = err
+		return GetPublicKeyRequest{Request: req, Input: i}
+	}
+	req.Data = &GetPublicKeyOutput{
+		KeyId:     i.KeyId,
+		PublicKey: der,
+		KeyUsage:  "SIGN_VERIFY",
+	}
+	return GetPublicKeyRequest{Request: req, Input: i}
+}
@@ -0,0 +1,145 @@
+package kmssigner
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/signer"
+	"github.com/stretchr/testify/assert"
+)
+
+const testKeyID = "arn:aws:kms:eu-west-2:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+func mockKMS(t *testing.T, alg csr.KeyAlgorithm) MockKMS {
+	key, err := csr.GenerateKey(alg, rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	return MockKMS{Keys: map[string]crypto.Signer{testKeyID: key}}
+}
+
+func TestInterface(t *testing.T) {
+	i := new(crypto.Signer)
+	s, err := New(mockKMS(t, csr.ECDSAP256), testKeyID)
+	if err != nil {
+		t.Fatalf("error creating signer: %v", err)
+	}
+	assert.Implements(t, i, s, "Signer does not implement crypto.Signer")
+	assert.Implements(t, new(signer.Backend), Backend{}, "Backend does not implement signer.Backend")
+	assert.Implements(t, new(ClientAPI), Client{}, "Client does not implement ClientAPI")
+}
+
+func TestSigner_CA(t *testing.T) {
+	var algs = []csr.KeyAlgorithm{
+		csr.RSA2048,
+		csr.ECDSAP256,
+		csr.ECDSAP384,
+	}
+	for _, alg := range algs {
+		b := Backend{KMSsrv: mockKMS(t, alg)}
+		key, err := b.Signer(testKeyID)
+		if err != nil {
+			t.Fatalf("error creating signer for %v key: %v", alg, err)
+		}
+		caCSR, err := csr.NewWithSigner(pkix.Name{CommonName: "Test CA"}, []string{}, key, rand.Reader)
+		if err != nil {
+			t.Fatalf("error creating CA CSR with %v key: %v", alg, err)
+		}
+		caCert, err := ca.New(caCSR, key, time.Hour, rand.Reader)
+		if err != nil {
+			t.Fatalf("error creating CA with %v key: %v", alg, err)
+		}
+		r, _, err := csr.New(pkix.Name{CommonName: "host.test.local"}, []string{}, rand.Reader)
+		if err != nil {
+			t.Fatalf("error creating CSR: %v", err)
+		}
+		crt, err := ca.Sign(r, caCert, key, time.Hour, rand.Reader)
+		if err != nil {
+			t.Fatalf("error signing certificate with %v key: %v", alg, err)
+		}
+		assert.NoError(t, crt.CheckSignatureFrom(caCert), "certificate signature not valid for %v key", alg)
+	}
+}
+
+func TestSigner_PSS(t *testing.T) {
+	s, err := New(mockKMS(t, csr.RSA2048), testKeyID)
+	if err != nil {
+		t.Fatalf("error creating signer: %v", err)
+	}
+	h := crypto.SHA384.New()
+	h.Write([]byte("message"))
+	digest := h.Sum(nil)
+	opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA384}
+	sig, err := s.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		t.Fatalf("error signing: %v", err)
+	}
+	assert.NoError(t, rsa.VerifyPSS(s.Public().(*rsa.PublicKey), crypto.SHA384, digest, sig, opts), "PSS signature not valid")
+}
+
+func TestSigner_UnknownKey(t *testing.T) {
+	_, err := New(mockKMS(t, csr.ECDSAP256), "unknown")
+	assert.Error(t, err, "error expected for unknown key")
+}
+
+func TestClient(t *testing.T) {
+	key, err := csr.GenerateKey(csr.ECDSAP256, rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	mock := MockKMS{Keys: map[string]crypto.Signer{testKeyID: key}}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			var in GetPublicKeyInput
+			json.NewDecoder(r.Body).Decode(&in)
+			out, err := mock.GetPublicKeyRequest(&in).Send(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(out)
+		case "TrentService.Sign":
+			var in SignInput
+			json.NewDecoder(r.Body).Decode(&in)
+			assert.Equal(t, messageTypeDigest, in.MessageType, "message type not as expected")
+			out, err := mock.SignRequest(&in).Send(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(out)
+		default:
+			http.Error(w, "unknown target", http.StatusBadRequest)
+		}
+	}))
+	defer s.Close()
+
+	cfg := defaults.Config()
+	cfg.Region = "eu-west-2"
+	cfg.EndpointResolver = aws.ResolveWithEndpointURL(s.URL)
+	ks, err := New(Client{kms.New(cfg)}, testKeyID)
+	if err != nil {
+		t.Fatalf("error creating signer: %v", err)
+	}
+	assert.Equal(t, key.Public(), ks.Public(), "public key not as expected")
+	caCSR, err := csr.NewWithSigner(pkix.Name{CommonName: "Test CA"}, []string{}, ks, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CA CSR: %v", err)
+	}
+	assert.Equal(t, x509.ECDSA, caCSR.PublicKeyAlgorithm, "public key algorithm not as expected")
+}
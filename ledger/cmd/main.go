@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jcmturner/pki/ledger"
+)
+
+func main() {
+	path := flag.String("ledger", "./ledger.jsonl", "Path to the issued certificate ledger file")
+	serial := flag.String("serial", "", "Serial number of the certificate to look up (decimal or 0x prefixed hex)")
+	subject := flag.String("subject", "", "Subject distinguished name or common name to list certificates for")
+	expiring := flag.Duration("expiring", 0, "List valid certificates expiring within this duration")
+	flag.Parse()
+
+	l, err := ledger.Open(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error opening ledger: %v\n", err)
+		os.Exit(1)
+	}
+	var rs []ledger.Record
+	switch {
+	case *serial != "":
+		sn, ok := new(big.Int).SetString(*serial, 0)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "invalid serial number: %s\n", *serial)
+			os.Exit(1)
+		}
+		var r ledger.Record
+		r, err = l.Get(sn)
+		rs = []ledger.Record{r}
+	case *subject != "":
+		rs, err = l.BySubject(*subject)
+	case *expiring > 0:
+		rs, err = l.Expiring(*expiring)
+	default:
+		rs, err = l.List()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error querying ledger: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tSUBJECT\tPROFILE\tSTATUS\tNOT AFTER")
+	for _, r := range rs {
+		fmt.Fprintf(w, "%#x\t%s\t%s\t%s\t%s\n", r.SerialNumber, r.Subject, r.Profile, r.Status, r.NotAfter.Format(time.RFC3339))
+	}
+	w.Flush()
+}
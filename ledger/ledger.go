@@ -0,0 +1,323 @@
+// Package ledger records the certificates issued by the CA and their revocation status.
+//
+// The ledger is the source of truth for revocation, it can back a ca.RevocationDB and the OCSP responder, and for
+// renewal, by listing the certificates approaching expiry.
+package ledger
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+)
+
+// ErrNotFound is returned when the ledger has no record of a certificate.
+var ErrNotFound = errors.New("certificate not found in ledger")
+
+// Status of an issued certificate.
+type Status string
+
+const (
+	StatusValid   Status = "valid"
+	StatusRevoked Status = "revoked"
+)
+
+// Record of an issued certificate.
+type Record struct {
+	SerialNumber     *big.Int            `json:"serial_number"`
+	Subject          string              `json:"subject"`
+	CommonName       string              `json:"common_name"`
+	DNSNames         []string            `json:"dns_names,omitempty"`
+	IPAddresses      []string            `json:"ip_addresses,omitempty"`
+	EmailAddresses   []string            `json:"email_addresses,omitempty"`
+	URIs             []string            `json:"uris,omitempty"`
+	NotBefore        time.Time           `json:"not_before"`
+	NotAfter         time.Time           `json:"not_after"`
+	Profile          string              `json:"profile"`
+	Status           Status              `json:"status"`
+	Issued           time.Time           `json:"issued"`
+	RevokedAt        time.Time           `json:"revoked_at"`
+	RevocationReason ca.RevocationReason `json:"revocation_reason,omitempty"`
+	// RevocationRecorded is when the revocation was recorded in the ledger.
+	RevocationRecorded time.Time `json:"revocation_recorded"`
+	// Raw is the DER encoded certificate.
+	Raw []byte `json:"raw"`
+}
+
+// Certificate returns the parsed certificate of the record.
+func (r Record) Certificate() (*x509.Certificate, error) {
+	return x509.ParseCertificate(r.Raw)
+}
+
+// Ledger records issued certificates.
+type Ledger interface {
+	// Add records the issue of the certificate under the profile named.
+	Add(crt *x509.Certificate, profile string) error
+	// Get returns the record of the certificate with the serial number provided.
+	Get(sn *big.Int) (Record, error)
+	// BySubject returns the records whose subject distinguished name or common name matches that provided.
+	BySubject(subject string) ([]Record, error)
+	// Expiring returns the records of valid certificates that expire within the duration provided, soonest first.
+	Expiring(within time.Duration) ([]Record, error)
+	// List returns all the records ordered by issue time.
+	List() ([]Record, error)
+	// Revoke records the revocation of the certificate with the serial number provided.
+	Revoke(sn *big.Int, reason ca.RevocationReason, at time.Time) error
+	// Revocations returns the revoked certificates.
+	Revocations() ([]ca.Revocation, error)
+}
+
+// File is a Ledger persisted as a file of JSON lines.
+// Each line is a snapshot of a record and, when the file is loaded, later lines replace earlier ones for the same serial number.
+//
+// Several processes may share the file: lines appended by others are read in when the file's size or modification time
+// has changed since it was last read.
+type File struct {
+	path    string
+	mux     sync.Mutex
+	records map[string]*Record
+	order   []string
+	// offset is the length of the file read, up to the end of its last complete line.
+	offset  int64
+	modTime time.Time
+	line    int
+}
+
+// Open the ledger file at the path provided, creating it if it does not exist.
+func Open(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open ledger file: %v", err)
+	}
+	f.Close()
+	l := &File{path: path}
+	l.reset()
+	err = l.load()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *File) reset() {
+	l.records = make(map[string]*Record)
+	l.order = nil
+	l.offset = 0
+	l.line = 0
+}
+
+// load reads the lines of the ledger file not yet read into the index. If the file has shrunk, or changed without
+// growing, it is read again from the start.
+func (l *File) load() error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return fmt.Errorf("could not open ledger file: %v", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not read ledger file: %v", err)
+	}
+	if fi.Size() == l.offset && fi.ModTime().Equal(l.modTime) {
+		return nil
+	}
+	if fi.Size() <= l.offset {
+		l.reset()
+	}
+	_, err = f.Seek(l.offset, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("could not read ledger file: %v", err)
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("could not read ledger file: %v", err)
+	}
+	// A line without its newline may still be being written so is left for the next load.
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			break
+		}
+		l.line++
+		if i > 0 {
+			r := new(Record)
+			err = json.Unmarshal(b[:i], r)
+			if err != nil {
+				return fmt.Errorf("could not parse ledger line %d: %v", l.line, err)
+			}
+			l.set(r)
+		}
+		l.offset += int64(i + 1)
+		b = b[i+1:]
+	}
+	l.modTime = fi.ModTime()
+	return nil
+}
+
+func (l *File) set(r *Record) {
+	k := r.SerialNumber.String()
+	if _, ok := l.records[k]; !ok {
+		l.order = append(l.order, k)
+	}
+	l.records[k] = r
+}
+
+// append writes the record to the end of the ledger file and reads it, with any lines appended by others, into the index.
+func (l *File) append(r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open ledger file: %v", err)
+	}
+	_, err = f.Write(append(b, '\n'))
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not write to ledger file: %v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("could not close ledger file: %v", err)
+	}
+	return l.load()
+}
+
+// Add records the issue of the certificate under the profile named.
+func (l *File) Add(crt *x509.Certificate, profile string) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if err := l.load(); err != nil {
+		return err
+	}
+	if _, ok := l.records[crt.SerialNumber.String()]; ok {
+		return fmt.Errorf("certificate with serial number %s already in ledger", crt.SerialNumber)
+	}
+	r := &Record{
+		SerialNumber:   crt.SerialNumber,
+		Subject:        crt.Subject.String(),
+		CommonName:     crt.Subject.CommonName,
+		DNSNames:       crt.DNSNames,
+		EmailAddresses: crt.EmailAddresses,
+		NotBefore:      crt.NotBefore,
+		NotAfter:       crt.NotAfter,
+		Profile:        profile,
+		Status:         StatusValid,
+		Issued:         time.Now(),
+		Raw:            crt.Raw,
+	}
+	for _, ip := range crt.IPAddresses {
+		r.IPAddresses = append(r.IPAddresses, ip.String())
+	}
+	for _, u := range crt.URIs {
+		r.URIs = append(r.URIs, u.String())
+	}
+	return l.append(r)
+}
+
+// Get returns the record of the certificate with the serial number provided.
+func (l *File) Get(sn *big.Int) (Record, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if err := l.load(); err != nil {
+		return Record{}, err
+	}
+	r, ok := l.records[sn.String()]
+	if !ok {
+		return Record{}, fmt.Errorf("%w: serial number %s", ErrNotFound, sn)
+	}
+	return *r, nil
+}
+
+// BySubject returns the records whose subject distinguished name or common name matches that provided.
+func (l *File) BySubject(subject string) ([]Record, error) {
+	return l.filter(func(r *Record) bool {
+		return r.Subject == subject || r.CommonName == subject
+	})
+}
+
+// Expiring returns the records of valid certificates that expire within the duration provided, soonest first.
+func (l *File) Expiring(within time.Duration) ([]Record, error) {
+	now := time.Now()
+	rs, err := l.filter(func(r *Record) bool {
+		return r.Status == StatusValid && r.NotAfter.After(now) && r.NotAfter.Before(now.Add(within))
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		return rs[i].NotAfter.Before(rs[j].NotAfter)
+	})
+	return rs, nil
+}
+
+// List returns all the records ordered by issue time.
+func (l *File) List() ([]Record, error) {
+	return l.filter(func(r *Record) bool { return true })
+}
+
+func (l *File) filter(f func(r *Record) bool) ([]Record, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	var rs []Record
+	for _, k := range l.order {
+		if r := l.records[k]; f(r) {
+			rs = append(rs, *r)
+		}
+	}
+	return rs, nil
+}
+
+// Revoke records the revocation of the certificate with the serial number provided.
+func (l *File) Revoke(sn *big.Int, reason ca.RevocationReason, at time.Time) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if err := l.load(); err != nil {
+		return err
+	}
+	r, ok := l.records[sn.String()]
+	if !ok {
+		return fmt.Errorf("%w: serial number %s", ErrNotFound, sn)
+	}
+	if r.Status == StatusRevoked {
+		return fmt.Errorf("certificate with serial number %s already revoked", sn)
+	}
+	u := *r
+	u.Status = StatusRevoked
+	u.RevokedAt = at
+	u.RevocationReason = reason
+	u.RevocationRecorded = time.Now()
+	return l.append(&u)
+}
+
+// Revocations returns the revoked certificates.
+func (l *File) Revocations() ([]ca.Revocation, error) {
+	rs, err := l.filter(func(r *Record) bool { return r.Status == StatusRevoked })
+	if err != nil {
+		return nil, err
+	}
+	var rvs []ca.Revocation
+	for _, r := range rs {
+		rvs = append(rvs, ca.Revocation{
+			SerialNumber: r.SerialNumber,
+			RevokedAt:    r.RevokedAt,
+			Reason:       r.RevocationReason,
+			Recorded:     r.RevocationRecorded,
+		})
+	}
+	return rvs, nil
+}
@@ -0,0 +1,212 @@
+package ledger
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/internal/testpki"
+	"github.com/stretchr/testify/assert"
+)
+
+// issue returns a certificate signed by the CA valid for the duration provided.
+func issue(t *testing.T, caCert *x509.Certificate, caKey crypto.Signer, cn string, sans []string, d time.Duration) *x509.Certificate {
+	r, _ := testpki.CSR(t, cn, csr.ECDSAP256, sans...)
+	crt, err := ca.Sign(r, caCert, caKey, d, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing certificate: %v", err)
+	}
+	return crt
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ledger.jsonl")
+	caCert, caKey := testpki.CA(t, "Test CA", csr.ECDSAP256)
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("error opening ledger: %v", err)
+	}
+	short := issue(t, caCert, caKey, "short.test.local", []string{"10.0.0.1"}, time.Hour)
+	long := issue(t, caCert, caKey, "long.test.local", []string{}, time.Hour*24*30)
+	other := issue(t, caCert, caKey, "short.test.local", []string{}, time.Hour*2)
+	for _, c := range []*x509.Certificate{short, long, other} {
+		err = l.Add(c, ca.ProfileServer)
+		if err != nil {
+			t.Fatalf("error adding certificate: %v", err)
+		}
+	}
+	assert.Error(t, l.Add(short, ca.ProfileServer), "adding the same certificate twice should error")
+
+	r, err := l.Get(short.SerialNumber)
+	if err != nil {
+		t.Fatalf("error getting record: %v", err)
+	}
+	assert.Equal(t, "short.test.local", r.CommonName, "common name not as expected")
+	assert.Equal(t, []string{"short.test.local"}, r.DNSNames, "DNS names not as expected")
+	assert.Equal(t, []string{"10.0.0.1"}, r.IPAddresses, "IP addresses not as expected")
+	assert.Equal(t, ca.ProfileServer, r.Profile, "profile not as expected")
+	assert.Equal(t, StatusValid, r.Status, "status not as expected")
+	c, err := r.Certificate()
+	if err != nil {
+		t.Fatalf("error parsing record certificate: %v", err)
+	}
+	assert.True(t, c.Equal(short), "record certificate not as expected")
+	_, err = l.Get(big.NewInt(1))
+	assert.True(t, errors.Is(err, ErrNotFound), "missing serial should return ErrNotFound")
+
+	rs, err := l.BySubject("short.test.local")
+	if err != nil {
+		t.Fatalf("error listing by subject: %v", err)
+	}
+	assert.Len(t, rs, 2, "number of records by subject not as expected")
+
+	rs, err = l.Expiring(time.Hour * 24)
+	if err != nil {
+		t.Fatalf("error listing expiring: %v", err)
+	}
+	if assert.Len(t, rs, 2, "number of expiring records not as expected") {
+		assert.Equal(t, short.SerialNumber, rs[0].SerialNumber, "soonest expiring record should be first")
+	}
+
+	err = l.Revoke(short.SerialNumber, ca.ReasonKeyCompromise, time.Now())
+	if err != nil {
+		t.Fatalf("error revoking: %v", err)
+	}
+	assert.Error(t, l.Revoke(short.SerialNumber, ca.ReasonKeyCompromise, time.Now()), "revoking twice should error")
+	assert.Error(t, l.Revoke(big.NewInt(1), ca.ReasonKeyCompromise, time.Now()), "revoking an unknown serial should error")
+	rs, err = l.Expiring(time.Hour * 24)
+	if err != nil {
+		t.Fatalf("error listing expiring: %v", err)
+	}
+	assert.Len(t, rs, 1, "revoked certificates should not be listed as expiring")
+
+	// Reopen to check the ledger is persisted.
+	l, err = Open(path)
+	if err != nil {
+		t.Fatalf("error reopening ledger: %v", err)
+	}
+	rs, err = l.List()
+	if err != nil {
+		t.Fatalf("error listing: %v", err)
+	}
+	if assert.Len(t, rs, 3, "number of records not as expected") {
+		assert.Equal(t, short.SerialNumber, rs[0].SerialNumber, "records should be in issue order")
+		assert.Equal(t, StatusRevoked, rs[0].Status, "revoked status not persisted")
+		assert.Equal(t, ca.ReasonKeyCompromise, rs[0].RevocationReason, "revocation reason not persisted")
+	}
+	rvs, err := l.Revocations()
+	if err != nil {
+		t.Fatalf("error getting revocations: %v", err)
+	}
+	if assert.Len(t, rvs, 1, "number of revocations not as expected") {
+		assert.Equal(t, short.SerialNumber, rvs[0].SerialNumber, "revoked serial not as expected")
+	}
+}
+
+func TestFile_RevocationStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caCert, caKey := testpki.CA(t, "Test CA", csr.ECDSAP256)
+	l, err := Open(filepath.Join(dir, "ledger.jsonl"))
+	if err != nil {
+		t.Fatalf("error opening ledger: %v", err)
+	}
+	assert.Implements(t, (*ca.RevocationStore)(nil), l, "File does not implement the ca.RevocationStore interface")
+	crt := issue(t, caCert, caKey, "host.test.local", []string{}, time.Hour)
+	err = l.Add(crt, ca.ProfileDefault)
+	if err != nil {
+		t.Fatalf("error adding certificate: %v", err)
+	}
+
+	db := &ca.RevocationDB{Store: l}
+	err = db.Revoke(crt.SerialNumber, ca.ReasonSuperseded, time.Now())
+	if err != nil {
+		t.Fatalf("error revoking through revocation database: %v", err)
+	}
+	r, err := l.Get(crt.SerialNumber)
+	if err != nil {
+		t.Fatalf("error getting record: %v", err)
+	}
+	assert.Equal(t, StatusRevoked, r.Status, "revocation not recorded in ledger")
+	crl, err := db.CRL(caCert, caKey, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CRL: %v", err)
+	}
+	if assert.Len(t, crl.RevokedCertificateEntries, 1, "number of revoked entries not as expected") {
+		assert.Equal(t, crt.SerialNumber, crl.RevokedCertificateEntries[0].SerialNumber, "revoked serial not as expected")
+	}
+}
+
+func TestFile_Shared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ledger.jsonl")
+	caCert, caKey := testpki.CA(t, "Test CA", csr.ECDSAP256)
+	// Two Files on the same path stand in for separate processes sharing the ledger.
+	issuer, err := Open(path)
+	if err != nil {
+		t.Fatalf("error opening ledger: %v", err)
+	}
+	responder, err := Open(path)
+	if err != nil {
+		t.Fatalf("error opening ledger: %v", err)
+	}
+	crt := issue(t, caCert, caKey, "host.test.local", []string{}, time.Hour)
+	err = issuer.Add(crt, ca.ProfileDefault)
+	if err != nil {
+		t.Fatalf("error adding certificate: %v", err)
+	}
+	r, err := responder.Get(crt.SerialNumber)
+	if err != nil {
+		t.Fatalf("certificate added by another ledger not found: %v", err)
+	}
+	assert.Equal(t, StatusValid, r.Status, "status not as expected")
+
+	err = issuer.Revoke(crt.SerialNumber, ca.ReasonKeyCompromise, time.Now())
+	if err != nil {
+		t.Fatalf("error revoking: %v", err)
+	}
+	rvs, err := responder.Revocations()
+	if err != nil {
+		t.Fatalf("error getting revocations: %v", err)
+	}
+	assert.Len(t, rvs, 1, "revocation by another ledger not seen")
+	assert.Error(t, responder.Revoke(crt.SerialNumber, ca.ReasonKeyCompromise, time.Now()), "revoking a certificate revoked by another ledger should error")
+
+	// A line still being written is not read until it is complete.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.Write([]byte(`{"serial_number":`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := responder.List()
+	if err != nil {
+		t.Fatalf("error listing with a partial line: %v", err)
+	}
+	assert.Len(t, rs, 1, "number of records not as expected")
+}
@@ -51,11 +51,16 @@ func main() {
 	}
 
 	// Write to files
+	var keyPEM []byte
 	err = ioutil.WriteFile("/Users/turnerj/jtca.crt", certificate.PEMEncode(caCert), 0644)
 	if err != nil {
 		panic(err.Error())
 	}
-	err = ioutil.WriteFile("/Users/turnerj/jtca.key", certificate.PEMEncodeRSAPrivateKey(cakey), 0600)
+	keyPEM, err = certificate.PEMEncodePrivateKey(cakey)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = ioutil.WriteFile("/Users/turnerj/jtca.key", keyPEM, 0600)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -63,7 +68,11 @@ func main() {
 	if err != nil {
 		panic(err.Error())
 	}
-	err = ioutil.WriteFile("/Users/turnerj/jtwww.key", certificate.PEMEncodeRSAPrivateKey(key), 0600)
+	keyPEM, err = certificate.PEMEncodePrivateKey(key)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = ioutil.WriteFile("/Users/turnerj/jtwww.key", keyPEM, 0600)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -71,7 +80,11 @@ func main() {
 	if err != nil {
 		panic(err.Error())
 	}
-	err = ioutil.WriteFile("/Users/turnerj/jtmac.key", certificate.PEMEncodeRSAPrivateKey(mackey), 0600)
+	keyPEM, err = certificate.PEMEncodePrivateKey(mackey)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = ioutil.WriteFile("/Users/turnerj/jtmac.key", keyPEM, 0600)
 	if err != nil {
 		panic(err.Error())
 	}
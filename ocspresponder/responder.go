@@ -0,0 +1,378 @@
+// Package ocspresponder provides an RFC 6960 OCSP responder for the certificates issued by the CA.
+package ocspresponder
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/ledger"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	requestContentType  = "application/ocsp-request"
+	responseContentType = "application/ocsp-response"
+	maxRequestSize      = 10240
+)
+
+// CertStatus is the status of a certificate as described in RFC 6960 section 4.2.1.
+type CertStatus int
+
+const (
+	Good    CertStatus = ocsp.Good
+	Revoked CertStatus = ocsp.Revoked
+	Unknown CertStatus = ocsp.Unknown
+)
+
+// Status is the revocation status of a certificate.
+type Status struct {
+	Status    CertStatus
+	RevokedAt time.Time
+	Reason    ca.RevocationReason
+}
+
+// Source provides the status of certificates issued by the CA.
+type Source interface {
+	Status(sn *big.Int) (Status, error)
+}
+
+// RevocationDBSource is a Source that reports certificates recorded in the revocation database as revoked.
+// The revocation database does not record the certificates issued so Issued is used to tell them apart from serial
+// numbers the CA never issued, which are reported as unknown.
+type RevocationDBSource struct {
+	DB *ca.RevocationDB
+	// Issued returns if the CA issued the certificate with the serial number. If nil no certificate is reported as good.
+	Issued func(sn *big.Int) bool
+}
+
+// Status returns the status of the certificate with the serial number provided.
+func (s RevocationDBSource) Status(sn *big.Int) (Status, error) {
+	r, ok, err := s.DB.IsRevoked(sn)
+	if err != nil {
+		return Status{}, err
+	}
+	if ok {
+		return Status{
+			Status:    Revoked,
+			RevokedAt: r.RevokedAt,
+			Reason:    r.Reason,
+		}, nil
+	}
+	if s.Issued == nil || !s.Issued(sn) {
+		return Status{Status: Unknown}, nil
+	}
+	return Status{Status: Good}, nil
+}
+
+// LedgerSource is a Source backed by the ledger of issued certificates.
+// Certificates not recorded in the ledger are reported as unknown.
+type LedgerSource struct {
+	Ledger ledger.Ledger
+}
+
+// Status returns the status of the certificate with the serial number provided.
+func (s LedgerSource) Status(sn *big.Int) (Status, error) {
+	r, err := s.Ledger.Get(sn)
+	if err != nil {
+		if errors.Is(err, ledger.ErrNotFound) {
+			return Status{Status: Unknown}, nil
+		}
+		return Status{}, err
+	}
+	if r.Status == ledger.StatusRevoked {
+		return Status{
+			Status:    Revoked,
+			RevokedAt: r.RevokedAt,
+			Reason:    r.RevocationReason,
+		}, nil
+	}
+	return Status{Status: Good}, nil
+}
+
+// Responder answers OCSP requests for certificates issued by the CA.
+//
+// Responses are signed by the CA key unless a delegated OCSP signing certificate, issued by the CA with the
+// OCSP signing extended key usage, and its key are set with Delegate. RSA and ECDSA signing keys are supported.
+//
+// A nonce in the request is echoed in the response extensions and the response is not cached.
+type Responder struct {
+	CAcrt  *x509.Certificate
+	Source Source
+	// NextUpdate is the duration for which responses are valid. Zero omits the nextUpdate field.
+	NextUpdate time.Duration
+	Logger     *log.Logger
+
+	signer     crypto.Signer
+	signerCert *x509.Certificate
+}
+
+// New returns a Responder that signs responses with the CA key.
+func New(CAcrt *x509.Certificate, CAkey crypto.Signer, src Source) (*Responder, error) {
+	r := &Responder{
+		CAcrt:  CAcrt,
+		Source: src,
+	}
+	err := r.setSigner(CAcrt, CAkey)
+	return r, err
+}
+
+// Delegate sets the delegated OCSP signing certificate and key used to sign responses.
+func (r *Responder) Delegate(cert *x509.Certificate, key crypto.Signer) error {
+	if err := cert.CheckSignatureFrom(r.CAcrt); err != nil {
+		return fmt.Errorf("delegated OCSP signing certificate not issued by the CA: %v", err)
+	}
+	var ocspSigning bool
+	for _, eku := range cert.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageOCSPSigning {
+			ocspSigning = true
+		}
+	}
+	if !ocspSigning {
+		return errors.New("delegated OCSP signing certificate does not have the OCSP signing extended key usage")
+	}
+	return r.setSigner(cert, key)
+}
+
+func (r *Responder) setSigner(cert *x509.Certificate, key crypto.Signer) error {
+	switch key.Public().(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return fmt.Errorf("unsupported OCSP signing key type: %T", key.Public())
+	}
+	r.signer = key
+	r.signerCert = cert
+	return nil
+}
+
+// ServeHTTP handles OCSP requests sent by HTTP GET or POST as described in RFC 6960 appendix A.
+// For GET requests the whole URL path is the base64 encoded request so, if the responder is not served at the root,
+// it should be wrapped with http.StripPrefix.
+func (r *Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var der []byte
+	switch req.Method {
+	case http.MethodGet:
+		p := strings.TrimPrefix(req.URL.Path, "/")
+		// Some clients do not URL encode '+' in the base64 request.
+		p = strings.Replace(p, " ", "+", -1)
+		b, err := base64.StdEncoding.DecodeString(p)
+		if err != nil {
+			r.write(w, ocsp.MalformedRequestErrorResponse, false)
+			return
+		}
+		der = b
+	case http.MethodPost:
+		if ct := req.Header.Get("Content-Type"); ct != requestContentType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxRequestSize))
+		if err != nil {
+			r.write(w, ocsp.MalformedRequestErrorResponse, false)
+			return
+		}
+		der = b
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	resp, cacheable := r.respond(der)
+	r.write(w, resp, cacheable && req.Method == http.MethodGet)
+}
+
+func (r *Responder) write(w http.ResponseWriter, resp []byte, cacheable bool) {
+	w.Header().Set("Content-Type", responseContentType)
+	if cacheable && r.NextUpdate > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", int(r.NextUpdate.Seconds())))
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// Respond returns the DER encoded OCSP response for the DER encoded OCSP request provided.
+func (r *Responder) Respond(der []byte) []byte {
+	resp, _ := r.respond(der)
+	return resp
+}
+
+// respond returns the response and if it may be cached, which is the case when it does not echo a nonce.
+func (r *Responder) respond(der []byte) ([]byte, bool) {
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, false
+	}
+	if !r.issuedBy(req) {
+		return ocsp.UnauthorizedErrorResponse, false
+	}
+	st, err := r.Source.Status(req.SerialNumber)
+	if err != nil {
+		r.logf("error getting status of serial number %s: %v", req.SerialNumber, err)
+		return ocsp.TryLaterErrorResponse, false
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	tmpl := ocsp.Response{
+		Status:       int(st.Status),
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		IssuerHash:   req.HashAlgorithm,
+	}
+	if r.NextUpdate > 0 {
+		tmpl.NextUpdate = now.Add(r.NextUpdate)
+	}
+	if st.Status == Revoked {
+		tmpl.RevokedAt = st.RevokedAt
+		tmpl.RevocationReason = int(st.Reason)
+	}
+	if r.signerCert != r.CAcrt {
+		tmpl.Certificate = r.signerCert
+	}
+	resp, err := ocsp.CreateResponse(r.CAcrt, r.signerCert, tmpl, r.signer)
+	if err != nil {
+		r.logf("error signing OCSP response: %v", err)
+		return ocsp.InternalErrorErrorResponse, false
+	}
+	nonce, ok := requestNonce(der)
+	if ok {
+		resp, err = r.addResponseExtensions(resp, []pkix.Extension{nonce})
+		if err != nil {
+			r.logf("error adding nonce to OCSP response: %v", err)
+			return ocsp.InternalErrorErrorResponse, false
+		}
+	}
+	return resp, !ok
+}
+
+// signatureHashes are the hashes of the signature algorithms ocsp.CreateResponse signs with.
+var signatureHashes = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, crypto.SHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, crypto.SHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, crypto.SHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, crypto.SHA512},
+}
+
+// addResponseExtensions returns the DER encoded successful OCSP response with the extensions set as the
+// responseExtensions of its ResponseData and signed again. ocsp.CreateResponse only supports single response
+// extensions.
+func (r *Responder) addResponseExtensions(der []byte, exts []pkix.Extension) ([]byte, error) {
+	var resp struct {
+		Status asn1.Enumerated
+		Bytes  struct {
+			ResponseType asn1.ObjectIdentifier
+			Response     []byte
+		} `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, fmt.Errorf("could not decode OCSP response: %v", err)
+	}
+	var basic struct {
+		TBSResponseData    asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Signature          asn1.BitString
+		Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+	}
+	if _, err := asn1.Unmarshal(resp.Bytes.Response, &basic); err != nil {
+		return nil, fmt.Errorf("could not decode basic OCSP response: %v", err)
+	}
+	var hash crypto.Hash
+	for _, h := range signatureHashes {
+		if h.oid.Equal(basic.SignatureAlgorithm.Algorithm) {
+			hash = h.hash
+		}
+	}
+	if hash == 0 {
+		return nil, fmt.Errorf("unsupported OCSP response signature algorithm: %v", basic.SignatureAlgorithm.Algorithm)
+	}
+
+	eb, err := asn1.Marshal(exts)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode response extensions: %v", err)
+	}
+	eb, err = asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: eb})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode response extensions: %v", err)
+	}
+	// Copy the ResponseData content as appending in place would overwrite the rest of the response.
+	tbs := append(append([]byte{}, basic.TBSResponseData.Bytes...), eb...)
+	tbs, err = asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: tbs})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode response data: %v", err)
+	}
+	h := hash.New()
+	h.Write(tbs)
+	sig, err := r.signer.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign response data: %v", err)
+	}
+	basic.TBSResponseData = asn1.RawValue{FullBytes: tbs}
+	basic.Signature = asn1.BitString{Bytes: sig, BitLength: len(sig) * 8}
+	resp.Bytes.Response, err = asn1.Marshal(basic)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode basic OCSP response: %v", err)
+	}
+	return asn1.Marshal(resp)
+}
+
+// issuedBy returns if the request identifies the CA as the issuer.
+func (r *Responder) issuedBy(req *ocsp.Request) bool {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(r.CAcrt.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+	kh := req.HashAlgorithm.New()
+	kh.Write(spki.PublicKey.RightAlign())
+	nh := req.HashAlgorithm.New()
+	nh.Write(r.CAcrt.RawSubject)
+	return bytes.Equal(kh.Sum(nil), req.IssuerKeyHash) && bytes.Equal(nh.Sum(nil), req.IssuerNameHash)
+}
+
+// oidOCSPNonce is the nonce extension of RFC 6960 section 4.4.1.
+var oidOCSPNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+// requestNonce returns the nonce extension of the DER encoded OCSP request, which ocsp.ParseRequest does not provide.
+func requestNonce(der []byte) (pkix.Extension, bool) {
+	var req struct {
+		TBSRequest struct {
+			Version           int           `asn1:"explicit,tag:0,default:0,optional"`
+			RequestorName     asn1.RawValue `asn1:"explicit,tag:1,optional"`
+			RequestList       asn1.RawValue
+			RequestExtensions []pkix.Extension `asn1:"explicit,tag:2,optional"`
+		}
+	}
+	if _, err := asn1.Unmarshal(der, &req); err != nil {
+		return pkix.Extension{}, false
+	}
+	for _, e := range req.TBSRequest.RequestExtensions {
+		if e.Id.Equal(oidOCSPNonce) {
+			return e, true
+		}
+	}
+	return pkix.Extension{}, false
+}
+
+func (r *Responder) logf(format string, v ...interface{}) {
+	if r.Logger != nil {
+		r.Logger.Printf(format, v...)
+	}
+}
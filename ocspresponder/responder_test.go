@@ -0,0 +1,331 @@
+package ocspresponder
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/internal/testpki"
+	"github.com/jcmturner/pki/ledger"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+)
+
+type testPKI struct {
+	caCert  *x509.Certificate
+	caKey   crypto.Signer
+	good    *x509.Certificate
+	revoked *x509.Certificate
+	db      *ca.RevocationDB
+}
+
+func newTestPKI(t *testing.T, alg csr.KeyAlgorithm) testPKI {
+	caCert, caKey := testpki.CA(t, "Test CA", alg)
+	p := testPKI{caCert: caCert, caKey: caKey, db: new(ca.RevocationDB)}
+	p.good, _ = testpki.Issue(t, caCert, caKey, "host.test.local")
+	p.revoked, _ = testpki.Issue(t, caCert, caKey, "host.test.local")
+	err := p.db.Revoke(p.revoked.SerialNumber, ca.ReasonKeyCompromise, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("error revoking certificate: %v", err)
+	}
+	return p
+}
+
+// source returns a RevocationDBSource for the test PKI's revocation database and issued certificates.
+func (p testPKI) source() RevocationDBSource {
+	return RevocationDBSource{DB: p.db, Issued: func(sn *big.Int) bool {
+		return sn.Cmp(p.good.SerialNumber) == 0 || sn.Cmp(p.revoked.SerialNumber) == 0
+	}}
+}
+
+func TestResponder_HTTP(t *testing.T) {
+	for _, alg := range []csr.KeyAlgorithm{csr.RSA2048, csr.ECDSAP384} {
+		p := newTestPKI(t, alg)
+		r, err := New(p.caCert, p.caKey, p.source())
+		if err != nil {
+			t.Fatalf("error creating responder with %v key: %v", alg, err)
+		}
+		r.NextUpdate = time.Hour
+		s := httptest.NewServer(r)
+
+		// POST
+		req, err := ocsp.CreateRequest(p.good, p.caCert, &ocsp.RequestOptions{Hash: crypto.SHA256})
+		if err != nil {
+			t.Fatalf("error creating OCSP request: %v", err)
+		}
+		httpResp, err := http.Post(s.URL, requestContentType, bytes.NewReader(req))
+		if err != nil {
+			t.Fatalf("error posting OCSP request: %v", err)
+		}
+		b, _ := ioutil.ReadAll(httpResp.Body)
+		httpResp.Body.Close()
+		assert.Equal(t, responseContentType, httpResp.Header.Get("Content-Type"), "content type not as expected")
+		resp, err := ocsp.ParseResponseForCert(b, p.good, p.caCert)
+		if err != nil {
+			t.Fatalf("error parsing OCSP response for %v CA: %v", alg, err)
+		}
+		assert.Equal(t, ocsp.Good, resp.Status, "status not as expected")
+		assert.True(t, resp.NextUpdate.After(resp.ThisUpdate), "next update not set")
+
+		// GET
+		req, err = ocsp.CreateRequest(p.revoked, p.caCert, nil)
+		if err != nil {
+			t.Fatalf("error creating OCSP request: %v", err)
+		}
+		httpResp, err = http.Get(s.URL + "/" + url.PathEscape(base64.StdEncoding.EncodeToString(req)))
+		if err != nil {
+			t.Fatalf("error getting OCSP response: %v", err)
+		}
+		b, _ = ioutil.ReadAll(httpResp.Body)
+		httpResp.Body.Close()
+		assert.Contains(t, httpResp.Header.Get("Cache-Control"), "max-age=3600", "cache control header not as expected")
+		resp, err = ocsp.ParseResponseForCert(b, p.revoked, p.caCert)
+		if err != nil {
+			t.Fatalf("error parsing OCSP response for %v CA: %v", alg, err)
+		}
+		assert.Equal(t, ocsp.Revoked, resp.Status, "status not as expected")
+		assert.Equal(t, ocsp.KeyCompromise, resp.RevocationReason, "revocation reason not as expected")
+		s.Close()
+	}
+
+	p := newTestPKI(t, csr.Ed25519)
+	_, err := New(p.caCert, p.caKey, p.source())
+	assert.Error(t, err, "Ed25519 signing key should not be supported")
+}
+
+func TestRevocationDBSource(t *testing.T) {
+	p := newTestPKI(t, csr.ECDSAP256)
+	unissued, _ := testpki.Issue(t, p.caCert, p.caKey, "unissued.test.local")
+	for _, test := range []struct {
+		name   string
+		src    RevocationDBSource
+		sn     *big.Int
+		status CertStatus
+	}{
+		{"good", p.source(), p.good.SerialNumber, Good},
+		{"revoked", p.source(), p.revoked.SerialNumber, Revoked},
+		{"never issued", p.source(), unissued.SerialNumber, Unknown},
+		{"no issued func", RevocationDBSource{DB: p.db}, p.good.SerialNumber, Unknown},
+	} {
+		st, err := test.src.Status(test.sn)
+		if err != nil {
+			t.Fatalf("%s: error getting status: %v", test.name, err)
+		}
+		assert.Equal(t, test.status, st.Status, "%s: status not as expected", test.name)
+	}
+}
+
+// failingStore is a revocation store whose revocations cannot be read.
+type failingStore struct{}
+
+func (failingStore) Revoke(sn *big.Int, reason ca.RevocationReason, at time.Time) error {
+	return errors.New("store unavailable")
+}
+
+func (failingStore) Revocations() ([]ca.Revocation, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func TestRevocationDBSource_StoreError(t *testing.T) {
+	p := newTestPKI(t, csr.ECDSAP256)
+	src := p.source()
+	src.DB = &ca.RevocationDB{Store: failingStore{}}
+	_, err := src.Status(p.good.SerialNumber)
+	assert.Error(t, err, "store error should be returned")
+
+	r, err := New(p.caCert, p.caKey, src)
+	if err != nil {
+		t.Fatalf("error creating responder: %v", err)
+	}
+	req, err := ocsp.CreateRequest(p.good, p.caCert, nil)
+	if err != nil {
+		t.Fatalf("error creating OCSP request: %v", err)
+	}
+	_, err = ocsp.ParseResponse(r.Respond(req), nil)
+	assert.Equal(t, ocsp.ResponseError{Status: ocsp.TryLater}, err, "try later error not as expected")
+}
+
+func TestResponder_Nonce(t *testing.T) {
+	p := newTestPKI(t, csr.ECDSAP256)
+	r, err := New(p.caCert, p.caKey, p.source())
+	if err != nil {
+		t.Fatalf("error creating responder: %v", err)
+	}
+	plain, err := ocsp.CreateRequest(p.good, p.caCert, nil)
+	if err != nil {
+		t.Fatalf("error creating OCSP request: %v", err)
+	}
+	var req struct {
+		TBSRequest struct {
+			RequestList       []asn1.RawValue
+			RequestExtensions []pkix.Extension `asn1:"explicit,tag:2,optional"`
+		}
+	}
+	_, err = asn1.Unmarshal(plain, &req)
+	if err != nil {
+		t.Fatalf("error decoding OCSP request: %v", err)
+	}
+	nonce, _ := asn1.Marshal([]byte("0123456789abcdef"))
+	req.TBSRequest.RequestExtensions = []pkix.Extension{{Id: oidOCSPNonce, Value: nonce}}
+	der, err := asn1.Marshal(req)
+	if err != nil {
+		t.Fatalf("error encoding OCSP request: %v", err)
+	}
+	rb := r.Respond(der)
+	resp, err := ocsp.ParseResponseForCert(rb, p.good, p.caCert)
+	if err != nil {
+		t.Fatalf("error parsing response with nonce: %v", err)
+	}
+	assert.Equal(t, ocsp.Good, resp.Status, "status not as expected")
+	assert.Len(t, resp.Extensions, 0, "nonce should not be in the single response extensions")
+
+	var outer struct {
+		Status asn1.Enumerated
+		Bytes  struct {
+			ResponseType asn1.ObjectIdentifier
+			Response     []byte
+		} `asn1:"explicit,tag:0"`
+	}
+	_, err = asn1.Unmarshal(rb, &outer)
+	if err != nil {
+		t.Fatalf("error decoding OCSP response: %v", err)
+	}
+	var basic struct {
+		TBSResponseData struct {
+			Version            int `asn1:"explicit,tag:0,default:0,optional"`
+			ResponderID        asn1.RawValue
+			ProducedAt         time.Time `asn1:"generalized"`
+			Responses          []asn1.RawValue
+			ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+		}
+	}
+	_, err = asn1.Unmarshal(outer.Bytes.Response, &basic)
+	if err != nil {
+		t.Fatalf("error decoding basic OCSP response: %v", err)
+	}
+	exts := basic.TBSResponseData.ResponseExtensions
+	if assert.Len(t, exts, 1, "nonce not in the response extensions") {
+		assert.True(t, exts[0].Id.Equal(oidOCSPNonce), "response extension not the nonce")
+		assert.Equal(t, nonce, exts[0].Value, "nonce not echoed")
+	}
+}
+
+func TestResponder_Delegated(t *testing.T) {
+	p := newTestPKI(t, csr.RSA2048)
+	r, err := New(p.caCert, p.caKey, p.source())
+	if err != nil {
+		t.Fatalf("error creating responder: %v", err)
+	}
+	dCSR, dKey := testpki.CSR(t, "OCSP Responder", csr.ECDSAP256)
+	err = r.Delegate(p.good, dKey)
+	assert.Error(t, err, "certificate without OCSP signing usage should be rejected")
+	dCert, err := ca.SignWithProfile(dCSR, p.caCert, p.caKey, ca.DefaultProfiles()[ca.ProfileOCSPSigning], time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing delegate certificate: %v", err)
+	}
+	err = r.Delegate(dCert, dKey)
+	if err != nil {
+		t.Fatalf("error setting delegate: %v", err)
+	}
+	req, err := ocsp.CreateRequest(p.revoked, p.caCert, nil)
+	if err != nil {
+		t.Fatalf("error creating OCSP request: %v", err)
+	}
+	resp, err := ocsp.ParseResponseForCert(r.Respond(req), p.revoked, p.caCert)
+	if err != nil {
+		t.Fatalf("error parsing delegated OCSP response: %v", err)
+	}
+	assert.Equal(t, ocsp.Revoked, resp.Status, "status not as expected")
+	if assert.NotNil(t, resp.Certificate, "delegated certificate not included") {
+		assert.Equal(t, dCert.Raw, resp.Certificate.Raw, "delegated certificate not as expected")
+	}
+}
+
+func TestResponder_Errors(t *testing.T) {
+	p := newTestPKI(t, csr.ECDSAP256)
+	r, err := New(p.caCert, p.caKey, p.source())
+	if err != nil {
+		t.Fatalf("error creating responder: %v", err)
+	}
+	_, err = ocsp.ParseResponse(r.Respond([]byte("not a request")), nil)
+	assert.Equal(t, ocsp.ResponseError{Status: ocsp.Malformed}, err, "malformed request error not as expected")
+
+	other := newTestPKI(t, csr.ECDSAP256)
+	req, err := ocsp.CreateRequest(other.good, other.caCert, nil)
+	if err != nil {
+		t.Fatalf("error creating OCSP request: %v", err)
+	}
+	_, err = ocsp.ParseResponse(r.Respond(req), nil)
+	assert.Equal(t, ocsp.ResponseError{Status: ocsp.Unauthorized}, err, "unauthorized error not as expected")
+
+	s := httptest.NewServer(r)
+	defer s.Close()
+	httpResp, err := http.Post(s.URL, "text/plain", bytes.NewReader(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusUnsupportedMediaType, httpResp.StatusCode, "HTTP status not as expected")
+}
+
+func TestLedgerSource(t *testing.T) {
+	p := newTestPKI(t, csr.ECDSAP256)
+	dir, err := ioutil.TempDir("", "ocsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := ledger.Open(filepath.Join(dir, "ledger.jsonl"))
+	if err != nil {
+		t.Fatalf("error opening ledger: %v", err)
+	}
+	for _, c := range []*x509.Certificate{p.good, p.revoked} {
+		err = l.Add(c, ca.ProfileDefault)
+		if err != nil {
+			t.Fatalf("error adding certificate to ledger: %v", err)
+		}
+	}
+	err = l.Revoke(p.revoked.SerialNumber, ca.ReasonKeyCompromise, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("error revoking certificate: %v", err)
+	}
+	r, err := New(p.caCert, p.caKey, LedgerSource{Ledger: l})
+	if err != nil {
+		t.Fatalf("error creating responder: %v", err)
+	}
+	unrecorded, _ := testpki.Issue(t, p.caCert, p.caKey, "unrecorded.test.local")
+	for _, test := range []struct {
+		crt    *x509.Certificate
+		status int
+	}{
+		{p.good, ocsp.Good},
+		{p.revoked, ocsp.Revoked},
+		{unrecorded, ocsp.Unknown},
+	} {
+		req, err := ocsp.CreateRequest(test.crt, p.caCert, nil)
+		if err != nil {
+			t.Fatalf("error creating OCSP request: %v", err)
+		}
+		resp, err := ocsp.ParseResponseForCert(r.Respond(req), test.crt, p.caCert)
+		if err != nil {
+			t.Fatalf("error parsing OCSP response: %v", err)
+		}
+		assert.Equal(t, test.status, resp.Status, "status not as expected")
+	}
+}
@@ -0,0 +1,466 @@
+package scep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/jcmturner/pki/certificate"
+
+	// Register the digests used by SCEP clients.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+var (
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+func (i issuerAndSerialNumber) matches(c *x509.Certificate) bool {
+	return bytes.Equal(i.Issuer.FullBytes, c.RawIssuer) && i.SerialNumber.Cmp(c.SerialNumber) == 0
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// envelopedData is the PKCS#7 EnvelopedData structure of RFC 2315 section 10.1.
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []recipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type recipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  issuerAndSerialNumber
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// signedMessage is the content and authenticated attributes of a verified SignedData structure.
+type signedMessage struct {
+	content []byte
+	signer  *x509.Certificate
+	hash    crypto.Hash
+	attrs   []attribute
+}
+
+// attribute returns the first value of the authenticated attribute with the OID provided.
+func (m *signedMessage) attribute(oid asn1.ObjectIdentifier) (asn1.RawValue, bool) {
+	for _, a := range m.attrs {
+		if a.Type.Equal(oid) && len(a.Values) > 0 {
+			return a.Values[0], true
+		}
+	}
+	return asn1.RawValue{}, false
+}
+
+func hashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm %v", oid)
+}
+
+func oidForHash(h crypto.Hash) asn1.ObjectIdentifier {
+	switch h {
+	case crypto.SHA1:
+		return oidSHA1
+	case crypto.SHA384:
+		return oidSHA384
+	case crypto.SHA512:
+		return oidSHA512
+	}
+	return oidSHA256
+}
+
+func digest(h crypto.Hash, b []byte) []byte {
+	d := h.New()
+	d.Write(b)
+	return d.Sum(nil)
+}
+
+// octets returns the bytes of an OCTET STRING that may use the constructed encoding.
+func octets(v asn1.RawValue) ([]byte, error) {
+	if !v.IsCompound {
+		return v.Bytes, nil
+	}
+	var b []byte
+	for rest := v.Bytes; len(rest) > 0; {
+		var s asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &s)
+		if err != nil {
+			return nil, err
+		}
+		p, err := octets(s)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, p...)
+	}
+	return b, nil
+}
+
+// parseSignedData parses the DER encoded ContentInfo holding SignedData and verifies the signature of its signer.
+func parseSignedData(der []byte) (*signedMessage, error) {
+	var ci certificate.PKCS7ContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("could not parse content info: %v", err)
+	}
+	if !ci.ContentType.Equal(certificate.OIDPKCS7SignedData) {
+		return nil, fmt.Errorf("content type %v is not signed data", ci.ContentType)
+	}
+	var sd certificate.PKCS7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("could not parse signed data: %v", err)
+	}
+	var inner certificate.PKCS7ContentInfo
+	if _, err := asn1.Unmarshal(sd.ContentInfo.FullBytes, &inner); err != nil {
+		return nil, fmt.Errorf("could not parse signed content info: %v", err)
+	}
+	var infos []signerInfo
+	if _, err := asn1.UnmarshalWithParams(sd.SignerInfos.FullBytes, &infos, "set"); err != nil {
+		return nil, fmt.Errorf("could not parse signer infos: %v", err)
+	}
+	if len(infos) != 1 {
+		return nil, fmt.Errorf("signed data has %d signers", len(infos))
+	}
+	si := infos[0]
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse certificates: %v", err)
+	}
+	m := new(signedMessage)
+	for _, c := range certs {
+		if si.IssuerAndSerialNumber.matches(c) {
+			m.signer = c
+		}
+	}
+	if m.signer == nil {
+		return nil, errors.New("signer certificate not included")
+	}
+	if len(inner.Content.FullBytes) > 0 {
+		m.content, err = octets(inner.Content)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse content: %v", err)
+		}
+	}
+	m.hash, err = hashForOID(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if len(si.AuthenticatedAttributes.Bytes) < 1 {
+		return nil, errors.New("signer has no authenticated attributes")
+	}
+	for rest := si.AuthenticatedAttributes.Bytes; len(rest) > 0; {
+		var a attribute
+		rest, err = asn1.Unmarshal(rest, &a)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse authenticated attributes: %v", err)
+		}
+		m.attrs = append(m.attrs, a)
+	}
+	md, ok := m.attribute(oidAttributeMessageDigest)
+	if !ok || !bytes.Equal(md.Bytes, digest(m.hash, m.content)) {
+		return nil, errors.New("message digest does not match the content")
+	}
+	// The signature is over the DER encoding of the attributes as a SET rather than with the implicit tag.
+	signed := append([]byte{}, si.AuthenticatedAttributes.FullBytes...)
+	signed[0] = asn1.TagSet | 0x20
+	if err := verify(m.signer.PublicKey, m.hash, digest(m.hash, signed), si.EncryptedDigest); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func verify(pub crypto.PublicKey, h crypto.Hash, d, sig []byte) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, h, d, sig); err != nil {
+			return fmt.Errorf("signature not valid: %v", err)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, d, sig) {
+			return errors.New("signature not valid")
+		}
+	default:
+		return fmt.Errorf("unsupported signer key type %T", pub)
+	}
+	return nil
+}
+
+// newAttribute returns an attribute with the single value provided.
+func newAttribute(oid asn1.ObjectIdentifier, v interface{}) (attribute, error) {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		return attribute{}, err
+	}
+	return attribute{Type: oid, Values: []asn1.RawValue{{FullBytes: b}}}, nil
+}
+
+// signData returns the DER encoded ContentInfo holding SignedData of the content, which may be nil, signed by the key
+// with the authenticated attributes provided in addition to the content type and message digest.
+func signData(content []byte, attrs []attribute, crt *x509.Certificate, key crypto.Signer, h crypto.Hash) ([]byte, error) {
+	ct, err := newAttribute(oidAttributeContentType, certificate.OIDPKCS7Data)
+	if err != nil {
+		return nil, err
+	}
+	md, err := newAttribute(oidAttributeMessageDigest, digest(h, content))
+	if err != nil {
+		return nil, err
+	}
+	var encoded [][]byte
+	for _, a := range append([]attribute{ct, md}, attrs...) {
+		b, err := asn1.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, b)
+	}
+	// DER requires the elements of a SET OF to be sorted by their encoding.
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	attrBytes := bytes.Join(encoded, nil)
+	signed, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attrBytes})
+	if err != nil {
+		return nil, err
+	}
+	sig, err := key.Sign(rand.Reader, digest(h, signed), h)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign: %v", err)
+	}
+	sigAlg := pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	if _, ok := key.Public().(*ecdsa.PublicKey); ok {
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: map[crypto.Hash]asn1.ObjectIdentifier{
+			crypto.SHA1:   oidECDSAWithSHA1,
+			crypto.SHA256: oidECDSAWithSHA256,
+			crypto.SHA384: oidECDSAWithSHA384,
+			crypto.SHA512: oidECDSAWithSHA512,
+		}[h]}
+	}
+	digestAlg := pkix.AlgorithmIdentifier{Algorithm: oidForHash(h), Parameters: asn1.NullRawValue}
+	digestAlgs, err := asn1.MarshalWithParams([]pkix.AlgorithmIdentifier{digestAlg}, "set")
+	if err != nil {
+		return nil, err
+	}
+	infos, err := asn1.MarshalWithParams([]signerInfo{{
+		Version:                   1,
+		IssuerAndSerialNumber:     issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: crt.RawIssuer}, SerialNumber: crt.SerialNumber},
+		DigestAlgorithm:           digestAlg,
+		AuthenticatedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrBytes},
+		DigestEncryptionAlgorithm: sigAlg,
+		EncryptedDigest:           sig,
+	}}, "set")
+	if err != nil {
+		return nil, err
+	}
+	inner := certificate.PKCS7ContentInfo{ContentType: certificate.OIDPKCS7Data}
+	if content != nil {
+		os, err := asn1.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		inner.Content = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: os}
+	}
+	innerBytes, err := asn1.Marshal(inner)
+	if err != nil {
+		return nil, err
+	}
+	return marshalContentInfo(certificate.OIDPKCS7SignedData, certificate.PKCS7SignedData{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{FullBytes: digestAlgs},
+		ContentInfo:      asn1.RawValue{FullBytes: innerBytes},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: crt.Raw},
+		SignerInfos:      asn1.RawValue{FullBytes: infos},
+	})
+}
+
+func marshalContentInfo(contentType asn1.ObjectIdentifier, content interface{}) ([]byte, error) {
+	b, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(certificate.PKCS7ContentInfo{
+		ContentType: contentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b},
+	})
+}
+
+// contentCipher returns the block cipher and key size of the content encryption algorithm.
+func contentCipher(oid asn1.ObjectIdentifier) (func(key []byte) (cipher.Block, error), int, error) {
+	switch {
+	case oid.Equal(oidDESEDE3CBC):
+		return des.NewTripleDESCipher, 24, nil
+	case oid.Equal(oidAES128CBC):
+		return aes.NewCipher, 16, nil
+	case oid.Equal(oidAES192CBC):
+		return aes.NewCipher, 24, nil
+	case oid.Equal(oidAES256CBC):
+		return aes.NewCipher, 32, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported content encryption algorithm %v", oid)
+}
+
+// envelope returns the DER encoded ContentInfo holding EnvelopedData of the content encrypted with the algorithm
+// provided under a random key transported to the recipient's RSA key.
+func envelope(content []byte, recipient *x509.Certificate, alg asn1.ObjectIdentifier) ([]byte, error) {
+	pub, ok := recipient.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("recipient key type %T does not support key transport", recipient.PublicKey)
+	}
+	newCipher, size, err := contentCipher(alg)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	n := block.BlockSize() - len(content)%block.BlockSize()
+	ct := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(n)}, n)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ct, ct)
+	ek, err := rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+	if err != nil {
+		return nil, err
+	}
+	return marshalContentInfo(oidEnvelopedData, envelopedData{
+		RecipientInfos: []recipientInfo{{
+			IssuerAndSerialNumber:  issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: recipient.RawIssuer}, SerialNumber: recipient.SerialNumber},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           ek,
+		}},
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                certificate.OIDPKCS7Data,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: alg, Parameters: asn1.RawValue{Tag: asn1.TagOctetString, Bytes: iv}},
+			EncryptedContent:           asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ct},
+		},
+	})
+}
+
+// openEnvelope decrypts the DER encoded ContentInfo holding EnvelopedData for the recipient returning the content and
+// the content encryption algorithm used.
+func openEnvelope(der []byte, recipient *x509.Certificate, key crypto.Decrypter) ([]byte, asn1.ObjectIdentifier, error) {
+	var ci certificate.PKCS7ContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, nil, fmt.Errorf("could not parse content info: %v", err)
+	}
+	if !ci.ContentType.Equal(oidEnvelopedData) {
+		return nil, nil, fmt.Errorf("content type %v is not enveloped data", ci.ContentType)
+	}
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+		return nil, nil, fmt.Errorf("could not parse enveloped data: %v", err)
+	}
+	var ek []byte
+	for _, ri := range ed.RecipientInfos {
+		if ri.IssuerAndSerialNumber.matches(recipient) {
+			ek = ri.EncryptedKey
+		}
+	}
+	if ek == nil {
+		return nil, nil, errors.New("not a recipient of the enveloped data")
+	}
+	cek, err := key.Decrypt(rand.Reader, ek, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not decrypt content encryption key: %v", err)
+	}
+	alg := ed.EncryptedContentInfo.ContentEncryptionAlgorithm
+	newCipher, size, err := contentCipher(alg.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(cek) != size {
+		return nil, nil, errors.New("content encryption key length not valid")
+	}
+	block, err := newCipher(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	iv := alg.Parameters.Bytes
+	ct, err := octets(ed.EncryptedContentInfo.EncryptedContent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse encrypted content: %v", err)
+	}
+	bs := block.BlockSize()
+	if len(iv) != bs || len(ct) == 0 || len(ct)%bs != 0 {
+		return nil, nil, errors.New("encrypted content not valid")
+	}
+	pt := make([]byte, len(ct))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(pt, ct)
+	n := int(pt[len(pt)-1])
+	if n < 1 || n > bs || !bytes.Equal(pt[len(pt)-n:], bytes.Repeat([]byte{byte(n)}, n)) {
+		return nil, nil, errors.New("encrypted content padding not valid")
+	}
+	return pt[:len(pt)-n], alg.Algorithm, nil
+}
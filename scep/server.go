@@ -0,0 +1,372 @@
+// Package scep provides an RFC 8894 Simple Certificate Enrolment Protocol (SCEP) server that issues certificates from
+// the CA to devices, such as printers and MDM managed clients, that do not support EST or ACME.
+package scep
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/certificate"
+	"github.com/jcmturner/pki/ledger"
+)
+
+// SCEP message types, PKI statuses and failure reasons of RFC 8894 section 3.2.1.
+const (
+	MessageTypeCertRep      = "3"
+	MessageTypeRenewalReq   = "17"
+	MessageTypePKCSReq      = "19"
+	MessageTypeCertPoll     = "20"
+	MessageTypeGetCert      = "21"
+	MessageTypeGetCRL       = "22"
+	PKIStatusSuccess        = "0"
+	PKIStatusFailure        = "2"
+	PKIStatusPending        = "3"
+	FailInfoBadAlg          = "0"
+	FailInfoBadMessageCheck = "1"
+	FailInfoBadRequest      = "2"
+	FailInfoBadTime         = "3"
+	FailInfoBadCertID       = "4"
+)
+
+const (
+	caCertContentType     = "application/x-x509-ca-cert"
+	caRACertContentType   = "application/x-x509-ca-ra-cert"
+	pkiMessageContentType = "application/x-pki-message"
+	maxRequestSize        = 65536
+	operationGetCACert    = "GetCACert"
+	operationGetCACaps    = "GetCACaps"
+	operationPKIOperation = "PKIOperation"
+)
+
+var (
+	oidMessageType       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidPKIStatus         = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidFailInfo          = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSenderNonce       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidRecipientNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidTransactionID     = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+	oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+)
+
+// Capabilities are the CA capabilities returned by GetCACaps.
+var Capabilities = []string{"AES", "DES3", "POSTPKIOperation", "SCEPStandard", "SHA-1", "SHA-256", "SHA-512"}
+
+// Server is a SCEP server that signs PKCSReq requests with the CA key using ca.Sign.
+//
+// SCEP encrypts requests to, and signs responses with, an RSA key. Unless the CA key is RSA a registration authority
+// (RA) certificate issued by the CA with an RSA key must be set with Delegate.
+type Server struct {
+	CAcrt *x509.Certificate
+	CAkey crypto.Signer
+	// Duration is the validity of issued certificates.
+	Duration time.Duration
+	// Challenge verifies the challenge password of requests. If nil all requests are rejected.
+	Challenge func(password string, csr *x509.CertificateRequest) bool
+	// Ledger, if set, records the certificates issued in response to PKCSReq messages. A certificate that cannot be
+	// recorded is not returned and the request fails.
+	Ledger ledger.Ledger
+	Logger *log.Logger
+
+	raCert *x509.Certificate
+	raKey  crypto.Signer
+}
+
+// New returns a Server that signs certificates valid for the duration provided with the CA key.
+func New(CAcrt *x509.Certificate, CAkey crypto.Signer, duration time.Duration) *Server {
+	return &Server{
+		CAcrt:    CAcrt,
+		CAkey:    CAkey,
+		Duration: duration,
+	}
+}
+
+// Delegate sets the RA certificate and key used to decrypt requests and sign responses in place of the CA's.
+func (s *Server) Delegate(cert *x509.Certificate, key crypto.Signer) error {
+	if err := cert.CheckSignatureFrom(s.CAcrt); err != nil {
+		return fmt.Errorf("RA certificate not issued by the CA: %v", err)
+	}
+	if _, err := decrypter(key); err != nil {
+		return err
+	}
+	s.raCert = cert
+	s.raKey = key
+	return nil
+}
+
+// StaticChallenge returns a Challenge function that accepts the password provided.
+func StaticChallenge(password string) func(string, *x509.CertificateRequest) bool {
+	return func(p string, _ *x509.CertificateRequest) bool {
+		return subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+	}
+}
+
+func decrypter(key crypto.Signer) (crypto.Decrypter, error) {
+	d, ok := key.(crypto.Decrypter)
+	if _, rsaKey := key.Public().(*rsa.PublicKey); !ok || !rsaKey {
+		return nil, errors.New("SCEP requires an RSA key that supports decryption")
+	}
+	return d, nil
+}
+
+// recipient returns the certificate and key that decrypt requests and sign responses.
+func (s *Server) recipient() (*x509.Certificate, crypto.Signer) {
+	if s.raCert != nil {
+		return s.raCert, s.raKey
+	}
+	return s.CAcrt, s.CAkey
+}
+
+// ServeHTTP handles the SCEP operation named by the operation query parameter. PKIOperation messages are accepted
+// base64 encoded in the message query parameter of a GET or as the body of a POST.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch op := r.URL.Query().Get("operation"); op {
+	case operationGetCACert:
+		s.caCert(w)
+	case operationGetCACaps:
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(strings.Join(Capabilities, "\n")))
+	case operationPKIOperation:
+		s.pkiOperation(w, r)
+	default:
+		http.Error(w, fmt.Sprintf("operation %q not supported", op), http.StatusBadRequest)
+	}
+}
+
+func (s *Server) caCert(w http.ResponseWriter) {
+	if s.raCert == nil {
+		w.Header().Set("Content-Type", caCertContentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(s.CAcrt.Raw)
+		return
+	}
+	b, err := certificate.EncodePKCS7([]*x509.Certificate{s.raCert, s.CAcrt})
+	if err != nil {
+		s.logf("error encoding PKCS#7: %v", err)
+		http.Error(w, "could not encode certificates", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", caRACertContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+func (s *Server) pkiOperation(w http.ResponseWriter, r *http.Request) {
+	var der []byte
+	if r.Method == http.MethodGet {
+		// Some clients do not URL encode '+' in the base64 message.
+		msg := strings.Replace(r.URL.Query().Get("message"), " ", "+", -1)
+		b, err := base64.StdEncoding.DecodeString(msg)
+		if err != nil {
+			http.Error(w, "message is not base64 encoded", http.StatusBadRequest)
+			return
+		}
+		der = b
+	} else {
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		if err != nil {
+			http.Error(w, "could not read request", http.StatusBadRequest)
+			return
+		}
+		der = b
+	}
+	msg, err := parseSignedData(der)
+	if err != nil {
+		// Without a verified signer there is no one to address a CertRep to.
+		http.Error(w, fmt.Sprintf("could not parse PKI message: %v", err), http.StatusBadRequest)
+		return
+	}
+	resp, err := s.respond(msg)
+	if err != nil {
+		s.logf("error creating CertRep: %v", err)
+		http.Error(w, "could not create response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", pkiMessageContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// respond returns the CertRep for the verified PKI message.
+func (s *Server) respond(msg *signedMessage) ([]byte, error) {
+	rcrt, rkey := s.recipient()
+	dec, err := decrypter(rkey)
+	if err != nil {
+		return nil, err
+	}
+	rep := certRep{msg: msg}
+	var mt, tid string
+	if err := stringAttribute(msg, oidMessageType, &mt); err != nil {
+		rep.failInfo = FailInfoBadRequest
+		return s.certRep(rep, rcrt, rkey)
+	}
+	if err := stringAttribute(msg, oidTransactionID, &tid); err != nil || tid == "" {
+		rep.failInfo = FailInfoBadRequest
+		return s.certRep(rep, rcrt, rkey)
+	}
+	if mt != MessageTypePKCSReq {
+		s.logf("transaction %s: message type %s not supported", tid, mt)
+		rep.failInfo = FailInfoBadRequest
+		return s.certRep(rep, rcrt, rkey)
+	}
+	b, alg, err := openEnvelope(msg.content, rcrt, dec)
+	if err != nil {
+		s.logf("transaction %s: %v", tid, err)
+		rep.failInfo = FailInfoBadMessageCheck
+		return s.certRep(rep, rcrt, rkey)
+	}
+	rep.alg = alg
+	csr, err := x509.ParseCertificateRequest(b)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		s.logf("transaction %s: certificate request not valid: %v", tid, err)
+		rep.failInfo = FailInfoBadMessageCheck
+		return s.certRep(rep, rcrt, rkey)
+	}
+	if s.Challenge == nil {
+		s.logf("transaction %s: no challenge configured to authenticate %s", tid, csr.Subject)
+		rep.failInfo = FailInfoBadRequest
+		return s.certRep(rep, rcrt, rkey)
+	}
+	pass, err := challengePassword(csr)
+	if err != nil || !s.Challenge(pass, csr) {
+		s.logf("transaction %s: challenge password for %s not valid", tid, csr.Subject)
+		rep.failInfo = FailInfoBadRequest
+		return s.certRep(rep, rcrt, rkey)
+	}
+	issued, err := ca.Sign(csr, s.CAcrt, s.CAkey, s.Duration, rand.Reader)
+	if err != nil {
+		s.logf("transaction %s: error signing certificate for %s: %v", tid, csr.Subject, err)
+		rep.failInfo = FailInfoBadRequest
+		return s.certRep(rep, rcrt, rkey)
+	}
+	if s.Ledger != nil {
+		if err := s.Ledger.Add(issued, ca.ProfileDefault); err != nil {
+			s.logf("transaction %s: error recording certificate %s in ledger: %v", tid, issued.SerialNumber, err)
+			rep.failInfo = FailInfoBadRequest
+			return s.certRep(rep, rcrt, rkey)
+		}
+	}
+	rep.issued = issued
+	return s.certRep(rep, rcrt, rkey)
+}
+
+// certRep holds the outcome of a request. A non-empty failInfo is a failure; alg is the content encryption
+// algorithm of the request used to envelope the issued certificate.
+type certRep struct {
+	msg      *signedMessage
+	issued   *x509.Certificate
+	failInfo string
+	alg      asn1.ObjectIdentifier
+}
+
+// certRep returns the CertRep message signed by the key. A successful response envelopes the issued certificate to
+// the requester's signing certificate.
+func (s *Server) certRep(rep certRep, crt *x509.Certificate, key crypto.Signer) ([]byte, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	var attrs []attribute
+	var err error
+	add := func(oid asn1.ObjectIdentifier, v interface{}) {
+		if err != nil {
+			return
+		}
+		var a attribute
+		a, err = newAttribute(oid, v)
+		attrs = append(attrs, a)
+	}
+	add(oidMessageType, printable(MessageTypeCertRep))
+	add(oidSenderNonce, nonce)
+	add(oidAttributeSigningTime, time.Now().UTC())
+	if tid, ok := rep.msg.attribute(oidTransactionID); ok {
+		add(oidTransactionID, tid)
+	}
+	if sn, ok := rep.msg.attribute(oidSenderNonce); ok {
+		add(oidRecipientNonce, sn)
+	}
+	var content []byte
+	if rep.failInfo != "" {
+		add(oidPKIStatus, printable(PKIStatusFailure))
+		add(oidFailInfo, printable(rep.failInfo))
+	} else {
+		add(oidPKIStatus, printable(PKIStatusSuccess))
+		inner, err := certificate.EncodePKCS7([]*x509.Certificate{rep.issued})
+		if err != nil {
+			return nil, err
+		}
+		content, err = envelope(inner, rep.msg.signer, rep.alg)
+		if err != nil {
+			return nil, fmt.Errorf("could not envelope certificate: %v", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return signData(content, attrs, crt, key, rep.msg.hash)
+}
+
+// printable returns the value as an ASN.1 PrintableString.
+func printable(s string) asn1.RawValue {
+	return asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte(s)}
+}
+
+func stringAttribute(msg *signedMessage, oid asn1.ObjectIdentifier, s *string) error {
+	v, ok := msg.attribute(oid)
+	if !ok {
+		return fmt.Errorf("attribute %v not present", oid)
+	}
+	_, err := asn1.Unmarshal(v.FullBytes, s)
+	return err
+}
+
+// tbsCertificateRequest is the CertificationRequestInfo structure of RFC 2986 section 4.1.
+type tbsCertificateRequest struct {
+	Version    int
+	Subject    asn1.RawValue
+	PublicKey  asn1.RawValue
+	Attributes []attribute `asn1:"tag:0"`
+}
+
+// challengePassword returns the challenge password attribute of the certificate request.
+func challengePassword(csr *x509.CertificateRequest) (string, error) {
+	var tbs tbsCertificateRequest
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &tbs); err != nil {
+		return "", fmt.Errorf("could not parse certificate request: %v", err)
+	}
+	for _, a := range tbs.Attributes {
+		if a.Type.Equal(oidChallengePassword) && len(a.Values) > 0 {
+			var p string
+			_, err := asn1.Unmarshal(a.Values[0].FullBytes, &p)
+			return p, err
+		}
+	}
+	return "", errors.New("challenge password not present")
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,325 @@
+package scep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/certificate"
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/internal/testpki"
+	"github.com/jcmturner/pki/ledger"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	caCert, caKey := testpki.CA(t, "Test CA", csr.RSA2048)
+	s := New(caCert, caKey, time.Hour)
+	s.Challenge = StaticChallenge("secret")
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+	return s, hs
+}
+
+// newRequest returns a certificate request with the challenge password provided and the self-signed certificate and
+// key the requester signs its PKI message with.
+func newRequest(t *testing.T, cn, password string) ([]byte, *x509.Certificate, crypto.Signer) {
+	r, key := testpki.CSR(t, cn, csr.RSA2048)
+	self, err := ca.New(r, key, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating self-signed certificate: %v", err)
+	}
+	var tbs tbsCertificateRequest
+	if _, err := asn1.Unmarshal(r.RawTBSCertificateRequest, &tbs); err != nil {
+		t.Fatalf("error parsing CSR: %v", err)
+	}
+	a, err := newAttribute(oidChallengePassword, password)
+	if err != nil {
+		t.Fatalf("error encoding challenge password: %v", err)
+	}
+	tbs.Attributes = []attribute{a}
+	tbsDER, err := asn1.Marshal(tbs)
+	if err != nil {
+		t.Fatalf("error encoding CSR: %v", err)
+	}
+	sig, err := key.Sign(rand.Reader, digest(crypto.SHA256, tbsDER), crypto.SHA256)
+	if err != nil {
+		t.Fatalf("error signing CSR: %v", err)
+	}
+	der, err := asn1.Marshal(struct {
+		TBS       asn1.RawValue
+		Algorithm pkix.AlgorithmIdentifier
+		Signature asn1.BitString
+	}{
+		TBS:       asn1.RawValue{FullBytes: tbsDER},
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, Parameters: asn1.NullRawValue},
+		Signature: asn1.BitString{Bytes: sig, BitLength: len(sig) * 8},
+	})
+	if err != nil {
+		t.Fatalf("error encoding CSR: %v", err)
+	}
+	return der, self, key
+}
+
+// pkiMessage returns the request enveloped to the recipient and signed by the requester.
+func pkiMessage(t *testing.T, messageType string, req []byte, recipient, crt *x509.Certificate, key crypto.Signer, alg asn1.ObjectIdentifier) []byte {
+	env, err := envelope(req, recipient, alg)
+	if err != nil {
+		t.Fatalf("error enveloping request: %v", err)
+	}
+	var attrs []attribute
+	for _, v := range []struct {
+		oid asn1.ObjectIdentifier
+		v   interface{}
+	}{
+		{oidMessageType, printable(messageType)},
+		{oidTransactionID, printable("transaction-1")},
+		{oidSenderNonce, []byte("0123456789abcdef")},
+	} {
+		a, err := newAttribute(v.oid, v.v)
+		if err != nil {
+			t.Fatalf("error encoding attribute: %v", err)
+		}
+		attrs = append(attrs, a)
+	}
+	b, err := signData(env, attrs, crt, key, crypto.SHA256)
+	if err != nil {
+		t.Fatalf("error signing PKI message: %v", err)
+	}
+	return b
+}
+
+// send posts, or gets if get is true, the PKI message returning the verified response.
+func send(t *testing.T, hs *httptest.Server, msg []byte, get bool) (*http.Response, *signedMessage) {
+	var resp *http.Response
+	var err error
+	if get {
+		resp, err = hs.Client().Get(hs.URL + "/scep?operation=PKIOperation&message=" + url.QueryEscape(base64.StdEncoding.EncodeToString(msg)))
+	} else {
+		resp, err = hs.Client().Post(hs.URL+"/scep?operation=PKIOperation", pkiMessageContentType, bytes.NewReader(msg))
+	}
+	if err != nil {
+		t.Fatalf("error sending PKI message: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	assert.Equal(t, pkiMessageContentType, resp.Header.Get("Content-Type"), "content type not as expected")
+	b, _ := ioutil.ReadAll(resp.Body)
+	rep, err := parseSignedData(b)
+	if err != nil {
+		t.Fatalf("error verifying CertRep: %v", err)
+	}
+	return resp, rep
+}
+
+func attr(m *signedMessage, oid asn1.ObjectIdentifier) string {
+	var s string
+	if err := stringAttribute(m, oid, &s); err != nil {
+		return ""
+	}
+	return s
+}
+
+func TestServer_GetCACert(t *testing.T) {
+	s, hs := newTestServer(t)
+	resp, err := hs.Client().Get(hs.URL + "/scep?operation=GetCACert")
+	if err != nil {
+		t.Fatalf("error getting CA certificate: %v", err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, caCertContentType, resp.Header.Get("Content-Type"), "content type not as expected")
+	b, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, s.CAcrt.Raw, b, "CA certificate not as expected")
+
+	// An RA is returned with the CA certificate.
+	ecCert, ecKey := testpki.CA(t, "Test CA", csr.ECDSAP256)
+	s = New(ecCert, ecKey, time.Hour)
+	s.Challenge = StaticChallenge("secret")
+	raCSR, raKey := testpki.CSR(t, "Test RA", csr.RSA2048)
+	raCert := testpki.Sign(t, raCSR, ecCert, ecKey)
+	assert.Error(t, s.Delegate(raCert, ecKey), "delegating to a key that cannot decrypt should error")
+	foreign, foreignKey := testpki.CA(t, "Test CA", csr.RSA2048)
+	assert.Error(t, s.Delegate(foreign, foreignKey), "delegating to a certificate not issued by the CA should error")
+	if err := s.Delegate(raCert, raKey); err != nil {
+		t.Fatalf("error delegating to RA: %v", err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/scep?operation=GetCACert", nil))
+	assert.Equal(t, caRACertContentType, w.Header().Get("Content-Type"), "RA content type not as expected")
+	bundle, err := certificate.Parse(w.Body.Bytes(), "")
+	if err != nil {
+		t.Fatalf("error parsing PKCS#7 response: %v", err)
+	}
+	if assert.Len(t, bundle.Chain, 2, "RA certificates not as expected") {
+		assert.True(t, bundle.Chain[0].Equal(raCert), "RA certificate not as expected")
+		assert.True(t, bundle.Chain[1].Equal(ecCert), "CA certificate not as expected")
+	}
+
+	// Requests are encrypted to the RA which signs the response to a certificate issued by the CA.
+	hs = httptest.NewServer(s)
+	defer hs.Close()
+	req, self, key := newRequest(t, "printer.test.local", "secret")
+	_, rep := send(t, hs, pkiMessage(t, MessageTypePKCSReq, req, raCert, self, key, oidAES128CBC), false)
+	if assert.NotNil(t, rep, "CertRep not returned") {
+		assert.True(t, rep.signer.Equal(raCert), "CertRep not signed by the RA")
+		assert.Equal(t, PKIStatusSuccess, attr(rep, oidPKIStatus), "status not as expected")
+		b, _, err := openEnvelope(rep.content, self, key.(crypto.Decrypter))
+		if err != nil {
+			t.Fatalf("error decrypting CertRep: %v", err)
+		}
+		bundle, err := certificate.Parse(b, "")
+		if err != nil {
+			t.Fatalf("error parsing issued certificate: %v", err)
+		}
+		assert.NoError(t, bundle.Chain[0].CheckSignatureFrom(ecCert), "certificate not signed by the CA")
+	}
+}
+
+func TestServer_GetCACaps(t *testing.T) {
+	_, hs := newTestServer(t)
+	resp, err := hs.Client().Get(hs.URL + "/scep?operation=GetCACaps")
+	if err != nil {
+		t.Fatalf("error getting CA capabilities: %v", err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	caps := strings.Split(string(b), "\n")
+	assert.Contains(t, caps, "POSTPKIOperation", "capabilities not as expected")
+	assert.Contains(t, caps, "SHA-256", "capabilities not as expected")
+
+	resp, err = hs.Client().Get(hs.URL + "/scep?operation=Unknown")
+	if err != nil {
+		t.Fatalf("error getting unknown operation: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "status of unknown operation not as expected")
+}
+
+func TestServer_PKCSReq(t *testing.T) {
+	s, hs := newTestServer(t)
+	dir, err := ioutil.TempDir("", "scep")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := ledger.Open(filepath.Join(dir, "ledger.jsonl"))
+	if err != nil {
+		t.Fatalf("error opening ledger: %v", err)
+	}
+	s.Ledger = l
+
+	var tests = []struct {
+		name        string
+		messageType string
+		password    string
+		alg         asn1.ObjectIdentifier
+		get         bool
+		failInfo    string
+	}{
+		{"AES POST", MessageTypePKCSReq, "secret", oidAES128CBC, false, ""},
+		{"DES3 GET", MessageTypePKCSReq, "secret", oidDESEDE3CBC, true, ""},
+		{"AES256", MessageTypePKCSReq, "secret", oidAES256CBC, false, ""},
+		{"bad password", MessageTypePKCSReq, "wrong", oidAES128CBC, false, FailInfoBadRequest},
+		{"renewal", MessageTypeRenewalReq, "secret", oidAES128CBC, false, FailInfoBadRequest},
+	}
+	for _, test := range tests {
+		req, self, key := newRequest(t, "printer.test.local", test.password)
+		msg := pkiMessage(t, test.messageType, req, s.CAcrt, self, key, test.alg)
+		resp, rep := send(t, hs, msg, test.get)
+		if rep == nil {
+			t.Errorf("%s: response status %d not as expected", test.name, resp.StatusCode)
+			continue
+		}
+		assert.True(t, rep.signer.Equal(s.CAcrt), "%s: CertRep not signed by the CA", test.name)
+		assert.Equal(t, MessageTypeCertRep, attr(rep, oidMessageType), "%s: message type not as expected", test.name)
+		assert.Equal(t, "transaction-1", attr(rep, oidTransactionID), "%s: transaction ID not as expected", test.name)
+		if rn, ok := rep.attribute(oidRecipientNonce); assert.True(t, ok, "%s: recipient nonce not present", test.name) {
+			assert.Equal(t, []byte("0123456789abcdef"), rn.Bytes, "%s: recipient nonce not as expected", test.name)
+		}
+		if test.failInfo != "" {
+			assert.Equal(t, PKIStatusFailure, attr(rep, oidPKIStatus), "%s: status not as expected", test.name)
+			assert.Equal(t, test.failInfo, attr(rep, oidFailInfo), "%s: fail info not as expected", test.name)
+			continue
+		}
+		if !assert.Equal(t, PKIStatusSuccess, attr(rep, oidPKIStatus), "%s: status not as expected: %s", test.name, attr(rep, oidFailInfo)) {
+			continue
+		}
+		b, alg, err := openEnvelope(rep.content, self, key.(crypto.Decrypter))
+		if err != nil {
+			t.Errorf("%s: error decrypting CertRep: %v", test.name, err)
+			continue
+		}
+		assert.True(t, alg.Equal(test.alg), "%s: response encryption not as expected", test.name)
+		bundle, err := certificate.Parse(b, "")
+		if err != nil {
+			t.Errorf("%s: error parsing issued certificate: %v", test.name, err)
+			continue
+		}
+		crt := bundle.Chain[0]
+		assert.Equal(t, "CN=printer.test.local", crt.Subject.String(), "%s: subject not as expected", test.name)
+		assert.Equal(t, self.PublicKey, crt.PublicKey, "%s: public key not that requested", test.name)
+		assert.NoError(t, crt.CheckSignatureFrom(s.CAcrt), "%s: certificate not signed by the CA", test.name)
+		_, err = l.Get(crt.SerialNumber)
+		assert.NoError(t, err, "%s: certificate not recorded in ledger", test.name)
+	}
+
+	// Messages that cannot be verified are refused without a CertRep.
+	req, self, key := newRequest(t, "printer.test.local", "secret")
+	msg := pkiMessage(t, MessageTypePKCSReq, req, s.CAcrt, self, key, oidAES128CBC)
+	tampered := append([]byte{}, msg...)
+	tampered[len(tampered)-1] ^= 0xff
+	resp, _ := send(t, hs, tampered, false)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "status of tampered message not as expected")
+
+	// Requests encrypted to another recipient cannot be decrypted.
+	other, _ := testpki.CA(t, "Test CA", csr.RSA2048)
+	_, rep := send(t, hs, pkiMessage(t, MessageTypePKCSReq, req, other, self, key, oidAES128CBC), false)
+	if assert.NotNil(t, rep, "CertRep not returned for undecryptable request") {
+		assert.Equal(t, FailInfoBadMessageCheck, attr(rep, oidFailInfo), "fail info not as expected")
+	}
+
+	// Requests are refused if no challenge is configured.
+	s.Challenge = nil
+	_, rep = send(t, hs, msg, false)
+	if assert.NotNil(t, rep, "CertRep not returned without a challenge") {
+		assert.Equal(t, PKIStatusFailure, attr(rep, oidPKIStatus), "status without a challenge not as expected")
+		assert.Equal(t, FailInfoBadRequest, attr(rep, oidFailInfo), "fail info without a challenge not as expected")
+	}
+}
+
+// failingLedger is a ledger that cannot record certificates.
+type failingLedger struct {
+	ledger.Ledger
+}
+
+func (failingLedger) Add(crt *x509.Certificate, profile string) error {
+	return errors.New("ledger unavailable")
+}
+
+func TestServer_PKCSReqLedgerError(t *testing.T) {
+	s, hs := newTestServer(t)
+	s.Ledger = failingLedger{}
+	req, self, key := newRequest(t, "printer.test.local", "secret")
+	_, rep := send(t, hs, pkiMessage(t, MessageTypePKCSReq, req, s.CAcrt, self, key, oidAES128CBC), false)
+	if assert.NotNil(t, rep, "CertRep not returned when the ledger fails") {
+		assert.Equal(t, PKIStatusFailure, attr(rep, oidPKIStatus), "status not as expected")
+		assert.Equal(t, FailInfoBadRequest, attr(rep, oidFailInfo), "fail info not as expected")
+	}
+}
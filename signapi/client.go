@@ -0,0 +1,233 @@
+package signapi
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/jcmturner/pki/ledger"
+	"gopkg.in/yaml.v3"
+)
+
+// Client is an API client and the policy applied to its requests.
+type Client struct {
+	Name string `json:"name" yaml:"name"`
+	// Token, if set, authenticates the client by an "Authorization: Bearer" header.
+	Token string `json:"token" yaml:"token"`
+	// CommonName, if set, authenticates the client by a TLS client certificate with that subject common name.
+	CommonName string `json:"common_name" yaml:"common_name"`
+	// Profiles are the names of the signing profiles the client may request. Clients may only fetch, list and revoke
+	// the certificates issued under these profiles for names permitted by SANs.
+	Profiles []string `json:"profiles" yaml:"profiles"`
+	// SANs constrains the subject common name and alternative names the client may request. If empty any names are
+	// permitted.
+	//
+	// Each entry is a DNS name, where a leading "*." matches any name under the domain, an IP address or CIDR range, an
+	// email address, where a leading "@" matches any address at the domain, or a URI such as "spiffe://example.org/ns/"
+	// which matches URIs of the same scheme and host with a path at or under its path.
+	SANs []string `json:"sans" yaml:"sans"`
+}
+
+// allowsProfile returns if the client may use the signing profile named.
+func (c *Client) allowsProfile(name string) bool {
+	for _, p := range c.Profiles {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNames returns an error for the first subject common name or alternative name of the CSR not permitted by the
+// client's constraints.
+func (c *Client) checkNames(csr *x509.CertificateRequest) error {
+	return c.checkSubjectNames(csr.Subject.CommonName, csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs)
+}
+
+// permits returns if the client may fetch, list and revoke the certificate of the record. It must have been issued
+// under one of the client's profiles for names the client could have requested.
+func (c *Client) permits(rec ledger.Record) bool {
+	if !c.allowsProfile(rec.Profile) {
+		return false
+	}
+	crt, err := rec.Certificate()
+	if err != nil {
+		return false
+	}
+	return c.checkSubjectNames(crt.Subject.CommonName, crt.DNSNames, crt.IPAddresses, crt.EmailAddresses, crt.URIs) == nil
+}
+
+func (c *Client) checkSubjectNames(cn string, dns []string, ips []net.IP, emails []string, uris []*url.URL) error {
+	if len(c.SANs) < 1 {
+		return nil
+	}
+	if cn != "" && !c.allowsSAN(cn, matchName) {
+		return fmt.Errorf("common name %s not permitted", cn)
+	}
+	for _, n := range dns {
+		if !c.allowsSAN(n, matchDNS) {
+			return fmt.Errorf("DNS name %s not permitted", n)
+		}
+	}
+	for _, ip := range ips {
+		if !c.allowsSAN(ip.String(), matchIP) {
+			return fmt.Errorf("IP address %s not permitted", ip)
+		}
+	}
+	for _, e := range emails {
+		if !c.allowsSAN(e, matchEmail) {
+			return fmt.Errorf("email address %s not permitted", e)
+		}
+	}
+	for _, u := range uris {
+		if !c.allowsSAN(u.String(), matchURI) {
+			return fmt.Errorf("URI %s not permitted", u)
+		}
+	}
+	return nil
+}
+
+func (c *Client) allowsSAN(name string, match func(pattern, name string) bool) bool {
+	for _, p := range c.SANs {
+		if match(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchName matches a common name, which may be in the form of any subject alternative name.
+func matchName(pattern, name string) bool {
+	return matchDNS(pattern, name) || matchIP(pattern, name) || matchEmail(pattern, name) || matchURI(pattern, name)
+}
+
+func matchDNS(pattern, name string) bool {
+	if strings.Contains(pattern, "@") || strings.Contains(pattern, "://") || net.ParseIP(pattern) != nil {
+		return false
+	}
+	pattern = strings.ToLower(pattern)
+	name = strings.ToLower(name)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(name, pattern[1:]) && len(name) > len(pattern)-1
+	}
+	return name == pattern
+}
+
+func matchIP(pattern, name string) bool {
+	ip := net.ParseIP(name)
+	if _, n, err := net.ParseCIDR(pattern); err == nil {
+		return n.Contains(ip)
+	}
+	p := net.ParseIP(pattern)
+	return p != nil && p.Equal(ip)
+}
+
+func matchEmail(pattern, name string) bool {
+	if !strings.Contains(pattern, "@") || strings.Contains(pattern, "://") {
+		return false
+	}
+	if strings.HasPrefix(pattern, "@") {
+		return strings.HasSuffix(strings.ToLower(name), strings.ToLower(pattern))
+	}
+	return strings.EqualFold(pattern, name)
+}
+
+// matchURI matches a URI with the scheme and host of the pattern and a path under the pattern's path.
+func matchURI(pattern, name string) bool {
+	if !strings.Contains(pattern, "://") {
+		return false
+	}
+	p, err := url.Parse(pattern)
+	if err != nil || p.Host == "" {
+		return false
+	}
+	u, err := url.Parse(name)
+	if err != nil || u.Opaque != "" || u.User != nil {
+		return false
+	}
+	if !strings.EqualFold(u.Scheme, p.Scheme) || !strings.EqualFold(u.Host, p.Host) {
+		return false
+	}
+	for _, seg := range strings.Split(u.Path, "/") {
+		// Dot segments could climb out of the pattern's path.
+		if seg == "." || seg == ".." {
+			return false
+		}
+	}
+	pp := strings.TrimSuffix(p.Path, "/")
+	return u.Path == pp || strings.HasPrefix(u.Path, pp+"/")
+}
+
+// tokenEqual compares tokens in constant time.
+func tokenEqual(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// LoadClients parses a JSON or YAML encoded list of clients.
+//
+// Example YAML:
+//
+//	# clients.yaml
+//	- name: deploy
+//	  token: 6f1c0e...
+//	  profiles: [server]
+//	  sans: ["*.svc.example.com", 10.0.0.0/8]
+//	- name: mdm
+//	  common_name: mdm.example.com
+//	  profiles: [client]
+func LoadClients(b []byte) ([]Client, error) {
+	// YAML is a superset of JSON so both are handled by the YAML decoder.
+	var cs []Client
+	err := yaml.Unmarshal(b, &cs)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse clients: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, c := range cs {
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("client %s: %v", c.Name, err)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("client %s defined more than once", c.Name)
+		}
+		names[c.Name] = true
+	}
+	return cs, nil
+}
+
+// LoadClientsFile reads the JSON or YAML file of clients at the path provided.
+func LoadClientsFile(path string) ([]Client, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read clients file: %v", err)
+	}
+	return LoadClients(b)
+}
+
+func (c Client) validate() error {
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	if c.Token == "" && c.CommonName == "" {
+		return errors.New("a token or common name is required")
+	}
+	if len(c.Profiles) < 1 {
+		return errors.New("at least one profile is required")
+	}
+	for _, p := range c.SANs {
+		if strings.Contains(p, "/") && !strings.Contains(p, "://") {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				return fmt.Errorf("invalid SAN constraint %s: %v", p, err)
+			}
+		}
+	}
+	return nil
+}
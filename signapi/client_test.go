@@ -0,0 +1,106 @@
+package signapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/internal/testpki"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_CheckNames(t *testing.T) {
+	c := Client{SANs: []string{"*.svc.test.local", "www.test.local", "10.0.0.0/8", "192.168.1.1", "@test.local", "spiffe://test.local/ns/"}}
+	var tests = []struct {
+		sans  []string
+		allow bool
+	}{
+		{[]string{"api.svc.test.local"}, true},
+		{[]string{"a.b.svc.test.local"}, true},
+		{[]string{"svc.test.local"}, false},
+		{[]string{"WWW.test.local"}, true},
+		{[]string{"other.test.local"}, false},
+		{[]string{"10.1.2.3"}, true},
+		{[]string{"192.168.1.1"}, true},
+		{[]string{"192.168.1.2"}, false},
+		{[]string{"admin@test.local"}, true},
+		{[]string{"admin@other.local"}, false},
+		{[]string{"spiffe://test.local/ns/default/sa/web"}, true},
+		{[]string{"spiffe://other.local/ns/default"}, false},
+		{[]string{"spiffe://test.local/ns"}, true},
+		{[]string{"spiffe://test.local.other.local/ns/default"}, false},
+		{[]string{"spiffe://test.local/nsx/default"}, false},
+		{[]string{"spiffe://test.local/ns/../admin"}, false},
+		{[]string{"https://test.local/ns/default"}, false},
+		{[]string{"www.test.local", "other.test.local"}, false},
+	}
+	for _, test := range tests {
+		// The common name is also added as a subject alternative name.
+		r, _ := testpki.CSR(t, test.sans[0], csr.ECDSAP256, test.sans...)
+		err := c.checkNames(r)
+		if test.allow {
+			assert.NoError(t, err, "%v: SANs should be permitted", test.sans)
+		} else {
+			assert.Error(t, err, "%v: SANs should not be permitted", test.sans)
+		}
+	}
+
+	r, _ := testpki.CSR(t, "test", csr.ECDSAP256, "anything.local")
+	assert.NoError(t, (&Client{}).checkNames(r), "client without constraints should permit any SANs")
+
+	// The common name is constrained even if not also requested as a subject alternative name.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "mdm.test.local"},
+		DNSNames: []string{"api.svc.test.local"},
+	}, key)
+	if err != nil {
+		t.Fatalf("error creating CSR: %v", err)
+	}
+	r, err = x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatalf("error parsing CSR: %v", err)
+	}
+	assert.Error(t, c.checkNames(r), "common name outside the constraints should not be permitted")
+}
+
+func TestLoadClients(t *testing.T) {
+	cs, err := LoadClients([]byte(`
+- name: deploy
+  token: secret
+  profiles: [server]
+  sans: ["*.svc.test.local", 10.0.0.0/8]
+- name: mdm
+  common_name: mdm.test.local
+  profiles: [client, email]
+`))
+	if err != nil {
+		t.Fatalf("error loading clients: %v", err)
+	}
+	if assert.Len(t, cs, 2, "clients not as expected") {
+		assert.Equal(t, Client{Name: "deploy", Token: "secret", Profiles: []string{"server"}, SANs: []string{"*.svc.test.local", "10.0.0.0/8"}}, cs[0], "client not as expected")
+		assert.Equal(t, "mdm.test.local", cs[1].CommonName, "common name not as expected")
+	}
+
+	var tests = []struct {
+		name string
+		cfg  string
+	}{
+		{"no name", `[{token: a, profiles: [server]}]`},
+		{"no credential", `[{name: a, profiles: [server]}]`},
+		{"no profiles", `[{name: a, token: a}]`},
+		{"bad CIDR", `[{name: a, token: a, profiles: [server], sans: [10.0.0.0/33]}]`},
+		{"duplicate", `[{name: a, token: a, profiles: [server]}, {name: a, token: b, profiles: [server]}]`},
+	}
+	for _, test := range tests {
+		_, err := LoadClients([]byte(test.cfg))
+		assert.Error(t, err, "%s: loading should error", test.name)
+	}
+}
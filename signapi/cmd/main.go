@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/internal/cmdutil"
+	"github.com/jcmturner/pki/ledger"
+	"github.com/jcmturner/pki/signapi"
+)
+
+func main() {
+	addr := flag.String("addr", ":8443", "Address to listen on")
+	cacertp := flag.String("cacert", "", "Path to the CA certificate file (PEM, DER, PKCS#7 or PKCS#12). Any further certificates in the file are returned as the CA chain")
+	cakeyp := flag.String("cakey", "", "Path to the CA private key file (PEM, DER or PKCS#12). Not required if the CA certificate file also holds the key")
+	passfile := flag.String("passfile", "", "File containing the passphrase of the CA private key. If not provided and the key is encrypted the passphrase is prompted for")
+	kmskey := flag.String("kmskey", "", "ARN of the AWS KMS key to sign with instead of a CA private key file")
+	tlscert := flag.String("tlscert", "", "Path to the PEM certificate chain the service presents")
+	tlskey := flag.String("tlskey", "", "Path to the PEM private key of the service's certificate")
+	clientsp := flag.String("clients", "", "Path to the JSON or YAML file of API clients")
+	clientcas := flag.String("clientcas", "", "Path to the PEM certificates that verify TLS client certificates. Required for clients with a common_name and should not include the CA")
+	profp := flag.String("profiles", "", "Path to a JSON or YAML file of signing profiles")
+	ledgerp := flag.String("ledger", "./ledger.jsonl", "Path to the issued certificate ledger file")
+	d := flag.Duration("duration", signapi.DefaultDuration, "Expiration duration of certificates when a request does not specify one")
+	flag.Parse()
+
+	if *tlscert == "" || *tlskey == "" {
+		log.Fatal("the service's TLS certificate and key must be provided with -tlscert and -tlskey")
+	}
+	clients, err := signapi.LoadClientsFile(*clientsp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, c := range clients {
+		if c.CommonName != "" && *clientcas == "" {
+			log.Fatalf("client %s authenticates by TLS client certificate so -clientcas must be provided", c.Name)
+		}
+	}
+	profiles := ca.DefaultProfiles()
+	if *profp != "" {
+		profiles, err = ca.LoadProfilesFile(*profp)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	l, err := ledger.Open(*ledgerp)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cab, err := cmdutil.LoadCA(*cacertp, *cakeyp, *passfile, *kmskey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := signapi.New(cab.Leaf(), cab.Key, l, clients)
+	s.Profiles = profiles
+	s.Duration = *d
+	s.Logger = log.New(os.Stderr, "signapi: ", log.LstdFlags)
+	s.Chain = cab.Chain[1:]
+	if *clientcas != "" {
+		b, err := ioutil.ReadFile(*clientcas)
+		if err != nil {
+			log.Fatalf("could not read client CAs file: %v", err)
+		}
+		s.ClientCAs = x509.NewCertPool()
+		if !s.ClientCAs.AppendCertsFromPEM(b) {
+			log.Fatal("no certificates found in client CAs file")
+		}
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+		// Client certificates are requested but verified by the signapi.Server so token clients need not present one.
+		TLSConfig: &tls.Config{ClientAuth: tls.RequestClientCert, MinVersion: tls.VersionTLS12},
+	}
+	log.Printf("signing API listening on %s", *addr)
+	log.Fatal(srv.ListenAndServeTLS(*tlscert, *tlskey))
+}
@@ -0,0 +1,414 @@
+// Package signapi provides an HTTP/JSON API for signing certificate requests with the CA, so that clients do not need
+// filesystem access to the CA key.
+//
+// The API, under /v1/, is:
+//
+//	GET  /v1/ca                            the CA certificate chain
+//	POST /v1/certificates                  sign a CSR
+//	GET  /v1/certificates                  list certificates, optionally by ?subject= or ?expiring=
+//	GET  /v1/certificates/{serial}         fetch a certificate
+//	POST /v1/certificates/{serial}/revoke  revoke a certificate
+//
+// Serial numbers are decimal or 0x prefixed hex. Errors are returned as a JSON object with an "error" field.
+package signapi
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/certificate"
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/ledger"
+)
+
+const (
+	// PathPrefix is the path under which the API is served.
+	PathPrefix = "/v1/"
+
+	// DefaultDuration is the validity of issued certificates when a request does not specify one.
+	DefaultDuration = time.Hour * 24 * 90
+
+	maxRequestSize = 65536
+)
+
+// SignRequest is the body of a request to sign a CSR.
+type SignRequest struct {
+	// CSR is the PEM encoded certificate signing request.
+	CSR string `json:"csr"`
+	// Profile is the name of the signing profile to apply. Defaults to ca.ProfileDefault.
+	Profile string `json:"profile,omitempty"`
+	// Duration is the requested validity, such as "720h". It must not exceed the profile's maximum validity.
+	Duration string `json:"duration,omitempty"`
+}
+
+// RevokeRequest is the body of a request to revoke a certificate.
+type RevokeRequest struct {
+	// Reason is the RFC 5280 revocation reason name, such as keyCompromise. Defaults to unspecified.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Certificate is an issued certificate as returned by the API.
+type Certificate struct {
+	SerialNumber     string        `json:"serial_number"`
+	Subject          string        `json:"subject"`
+	Profile          string        `json:"profile"`
+	Status           ledger.Status `json:"status"`
+	NotBefore        time.Time     `json:"not_before"`
+	NotAfter         time.Time     `json:"not_after"`
+	RevokedAt        *time.Time    `json:"revoked_at,omitempty"`
+	RevocationReason string        `json:"revocation_reason,omitempty"`
+	// Certificate is the PEM encoded certificate.
+	Certificate string `json:"certificate"`
+}
+
+// Chain is the CA certificate chain as returned by the API.
+type Chain struct {
+	// Certificates are the PEM encoded CA certificate followed by its issuers.
+	Certificates []string `json:"certificates"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server is the signing API. Issued certificates are recorded in the ledger from which they are fetched, listed and
+// revoked.
+//
+// Clients authenticate with a bearer token or a TLS client certificate issued by ClientCAs, verified as described by
+// certificate.VerifyClient.
+type Server struct {
+	CAcrt *x509.Certificate
+	CAkey crypto.Signer
+	// Chain holds the certificates of the CA's issuers returned with the CA certificate.
+	Chain    []*x509.Certificate
+	Profiles ca.Profiles
+	Clients  []Client
+	// Duration is the validity of issued certificates when a request does not specify one. It is capped at the
+	// profile's maximum validity.
+	Duration time.Duration
+	// ClientCAs verifies TLS client certificates. If nil TLS client certificates are not accepted. Certificates issued
+	// through the CA are rejected, even if it is in ClientCAs, as any client could request one with another client's
+	// common name.
+	ClientCAs *x509.CertPool
+	// Ledger records the certificates issued and is the source for fetching, listing and revoking them.
+	Ledger ledger.Ledger
+	Logger *log.Logger
+}
+
+// New returns a Server that signs with the CA key, using the built in profiles, for the clients provided.
+func New(CAcrt *x509.Certificate, CAkey crypto.Signer, l ledger.Ledger, clients []Client) *Server {
+	return &Server{
+		CAcrt:    CAcrt,
+		CAkey:    CAkey,
+		Profiles: ca.DefaultProfiles(),
+		Clients:  clients,
+		Duration: DefaultDuration,
+		Ledger:   l,
+	}
+}
+
+// ServeHTTP handles the API requests under PathPrefix.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, PathPrefix) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	c, err := s.authenticate(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="signapi"`)
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, PathPrefix), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "ca":
+		if allow(w, r, http.MethodGet) {
+			s.chain(w)
+		}
+	case len(parts) == 1 && parts[0] == "certificates":
+		switch r.Method {
+		case http.MethodGet:
+			s.list(w, r, c)
+		case http.MethodPost:
+			s.sign(w, r, c)
+		default:
+			allow(w, r, http.MethodGet, http.MethodPost)
+		}
+	case len(parts) == 2 && parts[0] == "certificates":
+		if allow(w, r, http.MethodGet) {
+			s.get(w, c, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "certificates" && parts[2] == "revoke":
+		if allow(w, r, http.MethodPost) {
+			s.revoke(w, r, c, parts[1])
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// allow returns if the request method is one of those provided, otherwise it writes a method not allowed response.
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// authenticate returns the client of the request's bearer token or, if there is none, of its TLS client certificate.
+func (s *Server) authenticate(r *http.Request) (*Client, error) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		token := strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+		if token == h {
+			return nil, errors.New("authorization scheme must be Bearer")
+		}
+		for i := range s.Clients {
+			if s.Clients[i].Token != "" && tokenEqual(s.Clients[i].Token, token) {
+				return &s.Clients[i], nil
+			}
+		}
+		return nil, errors.New("token not valid")
+	}
+	if s.ClientCAs == nil {
+		return nil, errors.New("authentication required")
+	}
+	chains, err := certificate.VerifyClient(r.TLS, s.ClientCAs)
+	if err != nil {
+		return nil, fmt.Errorf("TLS client certificate not valid: %v", err)
+	}
+	if chains == nil {
+		return nil, errors.New("authentication required")
+	}
+	crt := chains[0][0]
+	for _, chain := range chains {
+		for _, c := range chain[1:] {
+			if c.Equal(s.CAcrt) {
+				return nil, errors.New("TLS client certificates issued through the CA are not accepted")
+			}
+		}
+	}
+	for i := range s.Clients {
+		if s.Clients[i].CommonName != "" && s.Clients[i].CommonName == crt.Subject.CommonName {
+			return &s.Clients[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no client for TLS client certificate %s", crt.Subject)
+}
+
+func (s *Server) chain(w http.ResponseWriter) {
+	var ch Chain
+	for _, c := range append([]*x509.Certificate{s.CAcrt}, s.Chain...) {
+		ch.Certificates = append(ch.Certificates, string(certificate.PEMEncode(c)))
+	}
+	writeJSON(w, http.StatusOK, ch)
+}
+
+func (s *Server) sign(w http.ResponseWriter, r *http.Request, c *Client) {
+	var req SignRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not parse request: %v", err))
+		return
+	}
+	if req.Profile == "" {
+		req.Profile = ca.ProfileDefault
+	}
+	if !c.allowsProfile(req.Profile) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("profile %s not permitted", req.Profile))
+		return
+	}
+	profile, err := s.Profiles.Get(req.Profile)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	d := s.Duration
+	if profile.MaxValidity > 0 && d > profile.MaxValidity {
+		d = profile.MaxValidity
+	}
+	if req.Duration != "" {
+		d, err = time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid duration: %s", req.Duration))
+			return
+		}
+	}
+	cr, err := csr.Load([]byte(req.CSR))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not load CSR: %v", err))
+		return
+	}
+	if err := c.checkNames(cr); err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	crt, err := ca.SignWithProfile(cr, s.CAcrt, s.CAkey, profile, d, rand.Reader)
+	if errors.Is(err, ca.ErrNotPermitted) {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		s.logf("client %s: error signing certificate for %s: %v", c.Name, cr.Subject, err)
+		writeError(w, http.StatusInternalServerError, "could not sign certificate")
+		return
+	}
+	if err := s.Ledger.Add(crt, profile.Name); err != nil {
+		s.logf("client %s: error recording certificate %s in ledger: %v", c.Name, crt.SerialNumber, err)
+		writeError(w, http.StatusInternalServerError, "could not record certificate")
+		return
+	}
+	s.logf("client %s: issued certificate %#x to %s under profile %s", c.Name, crt.SerialNumber, crt.Subject, profile.Name)
+	rec, err := s.Ledger.Get(crt.SerialNumber)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, newCertificate(rec))
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, c *Client) {
+	var (
+		rs  []ledger.Record
+		err error
+	)
+	q := r.URL.Query()
+	switch {
+	case q.Get("subject") != "":
+		rs, err = s.Ledger.BySubject(q.Get("subject"))
+	case q.Get("expiring") != "":
+		d, perr := time.ParseDuration(q.Get("expiring"))
+		if perr != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid expiring duration: %s", q.Get("expiring")))
+			return
+		}
+		rs, err = s.Ledger.Expiring(d)
+	default:
+		rs, err = s.Ledger.List()
+	}
+	if err != nil {
+		s.logf("error querying ledger: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not query ledger")
+		return
+	}
+	certs := []Certificate{}
+	for _, rec := range rs {
+		if c.permits(rec) {
+			certs = append(certs, newCertificate(rec))
+		}
+	}
+	writeJSON(w, http.StatusOK, certs)
+}
+
+// record returns the ledger record of the serial number if the client is permitted its certificate. Otherwise it writes
+// an error response.
+func (s *Server) record(w http.ResponseWriter, c *Client, serial string) (ledger.Record, bool) {
+	sn, ok := new(big.Int).SetString(serial, 0)
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid serial number: %s", serial))
+		return ledger.Record{}, false
+	}
+	rec, err := s.Ledger.Get(sn)
+	// Certificates the client is not permitted are reported as not found so their existence is not disclosed.
+	if errors.Is(err, ledger.ErrNotFound) || (err == nil && !c.permits(rec)) {
+		writeError(w, http.StatusNotFound, ledger.ErrNotFound.Error())
+		return ledger.Record{}, false
+	}
+	if err != nil {
+		s.logf("error querying ledger: %v", err)
+		writeError(w, http.StatusInternalServerError, "could not query ledger")
+		return ledger.Record{}, false
+	}
+	return rec, true
+}
+
+func (s *Server) get(w http.ResponseWriter, c *Client, serial string) {
+	if rec, ok := s.record(w, c, serial); ok {
+		writeJSON(w, http.StatusOK, newCertificate(rec))
+	}
+}
+
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request, c *Client, serial string) {
+	var req RevokeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not parse request: %v", err))
+		return
+	}
+	reason := ca.ReasonUnspecified
+	if req.Reason != "" {
+		var err error
+		reason, err = ca.ParseRevocationReason(req.Reason)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	rec, ok := s.record(w, c, serial)
+	if !ok {
+		return
+	}
+	if rec.Status == ledger.StatusRevoked {
+		writeError(w, http.StatusConflict, "certificate already revoked")
+		return
+	}
+	if err := s.Ledger.Revoke(rec.SerialNumber, reason, time.Now()); err != nil {
+		s.logf("client %s: error revoking certificate %#x: %v", c.Name, rec.SerialNumber, err)
+		writeError(w, http.StatusInternalServerError, "could not revoke certificate")
+		return
+	}
+	s.logf("client %s: revoked certificate %#x of %s: %s", c.Name, rec.SerialNumber, rec.Subject, reason)
+	rec, err := s.Ledger.Get(rec.SerialNumber)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, newCertificate(rec))
+}
+
+func newCertificate(rec ledger.Record) Certificate {
+	c := Certificate{
+		SerialNumber: fmt.Sprintf("%#x", rec.SerialNumber),
+		Subject:      rec.Subject,
+		Profile:      rec.Profile,
+		Status:       rec.Status,
+		NotBefore:    rec.NotBefore,
+		NotAfter:     rec.NotAfter,
+	}
+	if crt, err := rec.Certificate(); err == nil {
+		c.Certificate = string(certificate.PEMEncode(crt))
+	}
+	if rec.Status == ledger.StatusRevoked {
+		at := rec.RevokedAt
+		c.RevokedAt = &at
+		c.RevocationReason = rec.RevocationReason.String()
+	}
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,217 @@
+package signapi
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/certificate"
+	"github.com/jcmturner/pki/csr"
+	"github.com/jcmturner/pki/internal/testpki"
+	"github.com/jcmturner/pki/ledger"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	caCert, caKey := testpki.CA(t, "Test CA", csr.ECDSAP256)
+	dir, err := ioutil.TempDir("", "signapi")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	l, err := ledger.Open(filepath.Join(dir, "ledger.jsonl"))
+	if err != nil {
+		t.Fatalf("error opening ledger: %v", err)
+	}
+	s := New(caCert, caKey, l, []Client{
+		{Name: "deploy", Token: "deploy-token", Profiles: []string{ca.ProfileServer}, SANs: []string{"*.svc.test.local"}},
+		{Name: "admin", Token: "admin-token", Profiles: []string{ca.ProfileServer, ca.ProfileClient}},
+		{Name: "mdm", CommonName: "mdm.test.local", Profiles: []string{ca.ProfileClient}},
+	})
+	hs := httptest.NewUnstartedServer(s)
+	hs.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	hs.StartTLS()
+	t.Cleanup(hs.Close)
+	return s, hs
+}
+
+// do sends the request, with the bearer token if not empty, decoding a JSON response into v.
+func do(t *testing.T, cl *http.Client, method, url, token string, body, v interface{}) int {
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		if err != nil {
+			t.Fatalf("error encoding request: %v", err)
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		t.Fatalf("error sending request to %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("error decoding response: %v", err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// newCSR returns a PEM encoded CSR for the common name and subject alternative names.
+func newCSR(t *testing.T, cn string, sans ...string) string {
+	r, _ := testpki.CSR(t, cn, csr.ECDSAP256, sans...)
+	return string(csr.PEMEncode(r))
+}
+
+func TestServer_Authentication(t *testing.T) {
+	s, hs := newTestServer(t)
+	url := hs.URL + PathPrefix + "ca"
+	assert.Equal(t, http.StatusUnauthorized, do(t, hs.Client(), http.MethodGet, url, "", nil, nil), "request without authentication should be refused")
+	assert.Equal(t, http.StatusUnauthorized, do(t, hs.Client(), http.MethodGet, url, "wrong", nil, nil), "request with bad token should be refused")
+
+	var ch Chain
+	if assert.Equal(t, http.StatusOK, do(t, hs.Client(), http.MethodGet, url, "deploy-token", nil, &ch), "status not as expected") &&
+		assert.Len(t, ch.Certificates, 1, "chain not as expected") {
+		crt, err := certificate.LoadCert([]byte(ch.Certificates[0]))
+		if err != nil {
+			t.Fatalf("error loading CA certificate: %v", err)
+		}
+		assert.True(t, crt.Equal(s.CAcrt), "CA certificate not as expected")
+	}
+
+	// Clients authenticate with TLS client certificates issued by ClientCAs.
+	clientCAcrt, clientCAkey := testpki.CA(t, "Test Client CA", csr.ECDSAP256)
+	mtls := func(crt *x509.Certificate, key crypto.Signer) *http.Client {
+		tr := hs.Client().Transport.(*http.Transport).Clone()
+		tr.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{crt.Raw}, PrivateKey: key}}
+		return &http.Client{Transport: tr}
+	}
+	issue := func(cn string, issuer *x509.Certificate, issuerKey crypto.Signer) *http.Client {
+		return mtls(testpki.Issue(t, issuer, issuerKey, cn))
+	}
+	mdm := issue("mdm.test.local", clientCAcrt, clientCAkey)
+	assert.Equal(t, http.StatusUnauthorized, do(t, mdm, http.MethodGet, url, "", nil, nil), "request with client certificate should be refused without ClientCAs")
+	s.ClientCAs = x509.NewCertPool()
+	s.ClientCAs.AddCert(clientCAcrt)
+	assert.Equal(t, http.StatusOK, do(t, mdm, http.MethodGet, url, "", nil, nil), "request with client certificate should succeed")
+	assert.Equal(t, http.StatusUnauthorized, do(t, issue("unknown.test.local", clientCAcrt, clientCAkey), http.MethodGet, url, "", nil, nil), "request with unknown client certificate should be refused")
+	assert.Equal(t, http.StatusUnauthorized, do(t, issue("mdm.test.local", s.CAcrt, s.CAkey), http.MethodGet, url, "", nil, nil), "request with client certificate not issued by ClientCAs should be refused")
+	var c Certificate
+	status := do(t, mdm, http.MethodPost, hs.URL+PathPrefix+"certificates", "", SignRequest{CSR: newCSR(t, "device", "device@test.local"), Profile: ca.ProfileClient}, &c)
+	if assert.Equal(t, http.StatusCreated, status, "signing with client certificate not as expected") {
+		assert.Equal(t, ca.ProfileClient, c.Profile, "profile not as expected")
+	}
+
+	// A token client cannot impersonate a TLS client with a certificate issued through the API, even if the CA is
+	// in ClientCAs.
+	r, key := testpki.CSR(t, "mdm.test.local", csr.ECDSAP256)
+	status = do(t, hs.Client(), http.MethodPost, hs.URL+PathPrefix+"certificates", "admin-token", SignRequest{CSR: string(csr.PEMEncode(r)), Profile: ca.ProfileClient}, &c)
+	if !assert.Equal(t, http.StatusCreated, status, "signing impersonating certificate not as expected") {
+		t.FailNow()
+	}
+	crt, err := certificate.LoadCert([]byte(c.Certificate))
+	if err != nil {
+		t.Fatalf("error loading issued certificate: %v", err)
+	}
+	s.ClientCAs.AddCert(s.CAcrt)
+	assert.Equal(t, http.StatusUnauthorized, do(t, mtls(crt, key), http.MethodGet, url, "", nil, nil), "request with certificate issued through the API should be refused")
+}
+
+func TestServer_Sign(t *testing.T) {
+	s, hs := newTestServer(t)
+	url := hs.URL + PathPrefix + "certificates"
+
+	var tests = []struct {
+		name    string
+		token   string
+		req     SignRequest
+		status  int
+		profile string
+	}{
+		{"server", "deploy-token", SignRequest{CSR: newCSR(t, "api.svc.test.local"), Profile: ca.ProfileServer, Duration: "24h"}, http.StatusCreated, ca.ProfileServer},
+		{"profile not permitted", "deploy-token", SignRequest{CSR: newCSR(t, "api.svc.test.local"), Profile: ca.ProfileClient}, http.StatusForbidden, ""},
+		{"default profile not permitted", "deploy-token", SignRequest{CSR: newCSR(t, "api.svc.test.local")}, http.StatusForbidden, ""},
+		{"SAN not permitted", "deploy-token", SignRequest{CSR: newCSR(t, "api.other.local"), Profile: ca.ProfileServer}, http.StatusForbidden, ""},
+		{"unconstrained SANs", "admin-token", SignRequest{CSR: newCSR(t, "api.other.local"), Profile: ca.ProfileServer}, http.StatusCreated, ca.ProfileServer},
+		{"unknown profile", "admin-token", SignRequest{CSR: "", Profile: "unknown"}, http.StatusForbidden, ""},
+		{"bad CSR", "admin-token", SignRequest{CSR: "not a CSR", Profile: ca.ProfileServer}, http.StatusBadRequest, ""},
+		{"duration beyond profile", "admin-token", SignRequest{CSR: newCSR(t, "api"), Profile: ca.ProfileServer, Duration: "87600h"}, http.StatusForbidden, ""},
+		{"SAN not permitted by profile", "admin-token", SignRequest{CSR: newCSR(t, "api", "admin@test.local"), Profile: ca.ProfileServer}, http.StatusForbidden, ""},
+		{"bad duration", "admin-token", SignRequest{CSR: newCSR(t, "api"), Profile: ca.ProfileServer, Duration: "soon"}, http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		var c Certificate
+		status := do(t, hs.Client(), http.MethodPost, url, test.token, test.req, &c)
+		if !assert.Equal(t, test.status, status, "%s: status not as expected", test.name) || status != http.StatusCreated {
+			continue
+		}
+		assert.Equal(t, test.profile, c.Profile, "%s: profile not as expected", test.name)
+		assert.Equal(t, ledger.StatusValid, c.Status, "%s: status not as expected", test.name)
+		crt, err := certificate.LoadCert([]byte(c.Certificate))
+		if err != nil {
+			t.Errorf("%s: error loading certificate: %v", test.name, err)
+			continue
+		}
+		assert.NoError(t, crt.CheckSignatureFrom(s.CAcrt), "%s: certificate not signed by the CA", test.name)
+		assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, crt.ExtKeyUsage, "%s: profile not applied", test.name)
+		if test.req.Duration != "" {
+			assert.WithinDuration(t, time.Now().Add(24*time.Hour), crt.NotAfter, time.Minute, "%s: validity not as expected", test.name)
+		}
+	}
+}
+
+func TestServer_FetchListRevoke(t *testing.T) {
+	_, hs := newTestServer(t)
+	url := hs.URL + PathPrefix + "certificates"
+	var server, client, other Certificate
+	do(t, hs.Client(), http.MethodPost, url, "deploy-token", SignRequest{CSR: newCSR(t, "api.svc.test.local"), Profile: ca.ProfileServer}, &server)
+	do(t, hs.Client(), http.MethodPost, url, "admin-token", SignRequest{CSR: newCSR(t, "user"), Profile: ca.ProfileClient}, &client)
+	// Issued under a profile the deploy client is permitted, but for names outside its SANs.
+	do(t, hs.Client(), http.MethodPost, url, "admin-token", SignRequest{CSR: newCSR(t, "www.test.local"), Profile: ca.ProfileServer}, &other)
+
+	var c Certificate
+	if assert.Equal(t, http.StatusOK, do(t, hs.Client(), http.MethodGet, url+"/"+server.SerialNumber, "deploy-token", nil, &c), "fetch status not as expected") {
+		assert.Equal(t, server, c, "fetched certificate not as expected")
+	}
+	assert.Equal(t, http.StatusNotFound, do(t, hs.Client(), http.MethodGet, url+"/"+client.SerialNumber, "deploy-token", nil, nil), "certificate of another profile should not be found")
+	assert.Equal(t, http.StatusNotFound, do(t, hs.Client(), http.MethodGet, url+"/"+other.SerialNumber, "deploy-token", nil, nil), "certificate for names outside the client's SANs should not be found")
+	assert.Equal(t, http.StatusNotFound, do(t, hs.Client(), http.MethodGet, url+"/12345", "deploy-token", nil, nil), "unknown certificate should not be found")
+	assert.Equal(t, http.StatusBadRequest, do(t, hs.Client(), http.MethodGet, url+"/xyz", "deploy-token", nil, nil), "invalid serial status not as expected")
+
+	var list []Certificate
+	do(t, hs.Client(), http.MethodGet, url, "deploy-token", nil, &list)
+	assert.Equal(t, []Certificate{server}, list, "list should only hold certificates of the client's profiles and SANs")
+	do(t, hs.Client(), http.MethodGet, url, "admin-token", nil, &list)
+	assert.Len(t, list, 3, "list not as expected")
+	do(t, hs.Client(), http.MethodGet, url+"?subject=user", "admin-token", nil, &list)
+	assert.Equal(t, []Certificate{client}, list, "list by subject not as expected")
+
+	assert.Equal(t, http.StatusNotFound, do(t, hs.Client(), http.MethodPost, url+"/"+client.SerialNumber+"/revoke", "deploy-token", RevokeRequest{}, nil), "revoking certificate of another profile should not be found")
+	assert.Equal(t, http.StatusNotFound, do(t, hs.Client(), http.MethodPost, url+"/"+other.SerialNumber+"/revoke", "deploy-token", RevokeRequest{}, nil), "revoking certificate for names outside the client's SANs should not be found")
+	assert.Equal(t, http.StatusBadRequest, do(t, hs.Client(), http.MethodPost, url+"/"+server.SerialNumber+"/revoke", "deploy-token", RevokeRequest{Reason: "bored"}, nil), "unknown reason status not as expected")
+	if assert.Equal(t, http.StatusOK, do(t, hs.Client(), http.MethodPost, url+"/"+server.SerialNumber+"/revoke", "deploy-token", RevokeRequest{Reason: "keyCompromise"}, &c), "revoke status not as expected") {
+		assert.Equal(t, ledger.StatusRevoked, c.Status, "status not as expected")
+		assert.Equal(t, "keyCompromise", c.RevocationReason, "reason not as expected")
+		assert.NotNil(t, c.RevokedAt, "revocation time not set")
+	}
+	assert.Equal(t, http.StatusConflict, do(t, hs.Client(), http.MethodPost, url+"/"+server.SerialNumber+"/revoke", "deploy-token", RevokeRequest{}, nil), "revoking twice status not as expected")
+	assert.Equal(t, http.StatusMethodNotAllowed, do(t, hs.Client(), http.MethodDelete, url+"/"+server.SerialNumber, "deploy-token", nil, nil), "method status not as expected")
+}
@@ -0,0 +1,131 @@
+// Package signer provides backends that hold the CA signing key and hand out crypto.Signer values for it.
+//
+// Callers of ca.New and ca.Sign only need a crypto.Signer so the private key material can remain within the backend,
+// for example an HSM or KMS, rather than being loaded into process memory.
+package signer
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jcmturner/pki/certificate"
+	"github.com/jcmturner/pki/csr"
+)
+
+// ErrNotFound is returned when a backend does not hold a key with the identifier requested.
+var ErrNotFound = errors.New("signing key not found")
+
+// Backend holds signing keys and returns a crypto.Signer for them.
+type Backend interface {
+	// Signer returns the crypto.Signer for the key with the identifier provided.
+	Signer(id string) (crypto.Signer, error)
+}
+
+// Generator is implemented by backends that can create new keys.
+type Generator interface {
+	// Generate creates a new key of the algorithm specified and stores it under the identifier provided.
+	Generate(id string, alg csr.KeyAlgorithm) (crypto.Signer, error)
+}
+
+// Memory is a Backend holding keys in process memory.
+type Memory struct {
+	Rand io.Reader
+	mux  sync.RWMutex
+	keys map[string]crypto.Signer
+}
+
+// NewMemory returns an empty in memory backend that uses the random reader provided for key generation.
+func NewMemory(rnd io.Reader) *Memory {
+	return &Memory{
+		Rand: rnd,
+		keys: make(map[string]crypto.Signer),
+	}
+}
+
+// Add the key to the backend under the identifier provided, replacing any existing key.
+func (m *Memory) Add(id string, key crypto.Signer) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.keys == nil {
+		m.keys = make(map[string]crypto.Signer)
+	}
+	m.keys[id] = key
+}
+
+// Signer returns the crypto.Signer for the key with the identifier provided.
+func (m *Memory) Signer(id string) (crypto.Signer, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	key, ok := m.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return key, nil
+}
+
+// Generate creates a new key of the algorithm specified and stores it under the identifier provided.
+func (m *Memory) Generate(id string, alg csr.KeyAlgorithm) (crypto.Signer, error) {
+	key, err := csr.GenerateKey(alg, m.Rand)
+	if err != nil {
+		return nil, err
+	}
+	m.Add(id, key)
+	return key, nil
+}
+
+// File is a Backend holding PEM encoded keys on the filesystem.
+// The key identifier is the path of the key file relative to Dir.
+type File struct {
+	Dir        string
+	Passphrase string
+	Rand       io.Reader
+}
+
+func (f File) path(id string) string {
+	return filepath.Join(f.Dir, id)
+}
+
+// Signer loads the key with the identifier provided and returns it as a crypto.Signer.
+func (f File) Signer(id string) (crypto.Signer, error) {
+	b, err := ioutil.ReadFile(f.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return nil, fmt.Errorf("could not read key file: %v", err)
+	}
+	return certificate.LoadKey(b, f.Passphrase)
+}
+
+// Generate creates a new key of the algorithm specified and writes it to the file identified.
+// If Passphrase is set the key is written as encrypted PKCS#8. An existing key file will not be overwritten.
+func (f File) Generate(id string, alg csr.KeyAlgorithm) (crypto.Signer, error) {
+	key, err := csr.GenerateKey(alg, f.Rand)
+	if err != nil {
+		return nil, err
+	}
+	keyOut, err := os.OpenFile(f.path(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not create key file: %v", err)
+	}
+	if f.Passphrase != "" {
+		err = certificate.WriteEncryptedKey(key, f.Passphrase, certificate.PBKDF2, keyOut, f.Rand)
+	} else {
+		err = certificate.WriteKey(key, keyOut)
+	}
+	if err != nil {
+		keyOut.Close()
+		return nil, fmt.Errorf("failed to write key data: %v", err)
+	}
+	err = keyOut.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not close key file: %v", err)
+	}
+	return key, nil
+}
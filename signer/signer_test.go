@@ -0,0 +1,86 @@
+package signer
+
+import (
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/pki/ca"
+	"github.com/jcmturner/pki/csr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterface(t *testing.T) {
+	i := new(Backend)
+	g := new(Generator)
+	assert.Implements(t, i, NewMemory(rand.Reader), "Memory does not implement Backend")
+	assert.Implements(t, g, NewMemory(rand.Reader), "Memory does not implement Generator")
+	assert.Implements(t, i, File{}, "File does not implement Backend")
+	assert.Implements(t, g, File{}, "File does not implement Generator")
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory(rand.Reader)
+	_, err := m.Signer("ca")
+	assert.True(t, errors.Is(err, ErrNotFound), "error not as expected for missing key")
+	key, err := m.Generate("ca", csr.ECDSAP256)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	s, err := m.Signer("ca")
+	if err != nil {
+		t.Fatalf("error getting signer: %v", err)
+	}
+	assert.Equal(t, key, s, "signer not as expected")
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := File{Dir: dir, Rand: rand.Reader}
+	_, err = f.Signer("ca.pem")
+	assert.True(t, errors.Is(err, ErrNotFound), "error not as expected for missing key")
+	key, err := f.Generate("ca.pem", csr.Ed25519)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	_, err = f.Generate("ca.pem", csr.Ed25519)
+	assert.Error(t, err, "existing key file should not be overwritten")
+	s, err := f.Signer("ca.pem")
+	if err != nil {
+		t.Fatalf("error loading signer: %v", err)
+	}
+	assert.Equal(t, key.Public(), s.Public(), "loaded key not as expected")
+}
+
+func TestSoftware_CASign(t *testing.T) {
+	key, err := NewSoftware(csr.ECDSAP384, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating software signer: %v", err)
+	}
+	caCSR, err := csr.NewWithSigner(pkix.Name{CommonName: "Test CA"}, []string{}, key, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CA CSR: %v", err)
+	}
+	caCert, err := ca.New(caCSR, key, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	r, _, err := csr.New(pkix.Name{CommonName: "host.test.local"}, []string{}, rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating CSR: %v", err)
+	}
+	crt, err := ca.Sign(r, caCert, key, time.Hour, rand.Reader)
+	if err != nil {
+		t.Fatalf("error signing certificate: %v", err)
+	}
+	assert.NoError(t, crt.CheckSignatureFrom(caCert), "certificate signature not valid")
+	assert.Equal(t, 3, key.Count(), "number of signing operations not as expected")
+}
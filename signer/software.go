@@ -0,0 +1,46 @@
+package signer
+
+import (
+	"crypto"
+	"io"
+	"sync"
+
+	"github.com/jcmturner/pki/csr"
+)
+
+// Software is a crypto.Signer backed by an in process key that is never exposed.
+// It stands in for HSM or KMS backed keys where only the public key and signing operation are available.
+type Software struct {
+	key   crypto.Signer
+	mux   sync.Mutex
+	count int
+}
+
+// NewSoftware generates a new key of the algorithm specified and returns it wrapped as a Software signer.
+func NewSoftware(alg csr.KeyAlgorithm, rnd io.Reader) (*Software, error) {
+	key, err := csr.GenerateKey(alg, rnd)
+	if err != nil {
+		return nil, err
+	}
+	return &Software{key: key}, nil
+}
+
+// Public returns the public key corresponding to the private key.
+func (s *Software) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+// Sign signs the digest with the private key.
+func (s *Software) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.mux.Lock()
+	s.count++
+	s.mux.Unlock()
+	return s.key.Sign(rand, digest, opts)
+}
+
+// Count returns the number of signing operations performed.
+func (s *Software) Count() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.count
+}
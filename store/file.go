@@ -0,0 +1,101 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// File is a Store on the local filesystem. Each kind of object is kept in a sub directory of Dir.
+type File struct {
+	Dir string
+}
+
+func (s File) path(kind Kind, name string) string {
+	return filepath.Join(s.Dir, string(kind), name)
+}
+
+// Put stores the data under the kind and name provided replacing any existing object.
+// The file is written to a temporary file and renamed into place so readers never see a partial object.
+func (s File) Put(kind Kind, name string, data []byte) error {
+	if err := validate(kind, name); err != nil {
+		return err
+	}
+	dir := filepath.Join(s.Dir, string(kind))
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return fmt.Errorf("could not create store directory: %v", err)
+	}
+	f, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %v", err)
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("could not write object: %v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("could not close object file: %v", err)
+	}
+	err = os.Rename(f.Name(), s.path(kind, name))
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("could not store object: %v", err)
+	}
+	return nil
+}
+
+// Get returns the data stored under the kind and name provided.
+func (s File) Get(kind Kind, name string) ([]byte, error) {
+	if err := validate(kind, name); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(s.path(kind, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s/%s", ErrNotFound, kind, name)
+		}
+		return nil, fmt.Errorf("could not read object: %v", err)
+	}
+	return b, nil
+}
+
+// List returns the names of the objects of the kind provided in lexical order.
+func (s File) List(kind Kind) ([]string, error) {
+	if err := validKind(kind); err != nil {
+		return nil, err
+	}
+	fis, err := ioutil.ReadDir(filepath.Join(s.Dir, string(kind)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not list objects: %v", err)
+	}
+	var names []string
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && fi.Name()[0] != '.' {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Delete removes the object stored under the kind and name provided.
+func (s File) Delete(kind Kind, name string) error {
+	if err := validate(kind, name); err != nil {
+		return err
+	}
+	err := os.Remove(s.path(kind, name))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not delete object: %v", err)
+	}
+	return nil
+}
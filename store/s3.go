@@ -1 +1,148 @@
 package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/s3iface"
+)
+
+// S3 is a Store in an S3 compatible object store. Objects are stored under the key Prefix/kind/name in Bucket.
+type S3 struct {
+	S3srv  s3iface.ClientAPI
+	Bucket string
+	Prefix string
+	// ServerSideEncryption, if set, is requested for all objects put in the store.
+	ServerSideEncryption s3.ServerSideEncryption
+}
+
+// NewS3 returns an S3 store using the default AWS SDK config with the region provided.
+// If endpoint is not empty requests are sent to it using path style addressing, for S3 compatible services.
+func NewS3(cl *http.Client, region, endpoint, bucket, prefix string) (S3, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return S3{}, fmt.Errorf("unable to load AWS SDK config: %v", err)
+	}
+	cfg.Region = region
+	cfg.HTTPClient = cl
+	if endpoint != "" {
+		cfg.EndpointResolver = aws.ResolveWithEndpointURL(endpoint)
+	}
+	return newS3(cfg, endpoint != "", bucket, prefix), nil
+}
+
+func newS3(cfg aws.Config, pathStyle bool, bucket, prefix string) S3 {
+	srv := s3.New(cfg)
+	srv.ForcePathStyle = pathStyle
+	return S3{
+		S3srv:  srv,
+		Bucket: bucket,
+		Prefix: strings.Trim(prefix, "/"),
+	}
+}
+
+func (s S3) key(kind Kind, name string) string {
+	return s.kindPrefix(kind) + name
+}
+
+func (s S3) kindPrefix(kind Kind) string {
+	if s.Prefix == "" {
+		return string(kind) + "/"
+	}
+	return s.Prefix + "/" + string(kind) + "/"
+}
+
+// Put stores the data under the kind and name provided replacing any existing object.
+func (s S3) Put(kind Kind, name string, data []byte) error {
+	if err := validate(kind, name); err != nil {
+		return err
+	}
+	in := &s3.PutObjectInput{
+		Bucket:               aws.String(s.Bucket),
+		Key:                  aws.String(s.key(kind, name)),
+		Body:                 bytes.NewReader(data),
+		ContentType:          aws.String("application/octet-stream"),
+		ServerSideEncryption: s.ServerSideEncryption,
+	}
+	_, err := s.S3srv.PutObjectRequest(in).Send(context.Background())
+	if err != nil {
+		return fmt.Errorf("could not put object %s/%s: %v", kind, name, err)
+	}
+	return nil
+}
+
+// Get returns the data stored under the kind and name provided.
+func (s S3) Get(kind Kind, name string) ([]byte, error) {
+	if err := validate(kind, name); err != nil {
+		return nil, err
+	}
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(kind, name)),
+	}
+	out, err := s.S3srv.GetObjectRequest(in).Send(context.Background())
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, fmt.Errorf("%w: %s/%s", ErrNotFound, kind, name)
+		}
+		return nil, fmt.Errorf("could not get object %s/%s: %v", kind, name, err)
+	}
+	defer out.Body.Close()
+	b, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read object %s/%s: %v", kind, name, err)
+	}
+	return b, nil
+}
+
+// List returns the names of the objects of the kind provided in lexical order.
+func (s S3) List(kind Kind) ([]string, error) {
+	if err := validKind(kind); err != nil {
+		return nil, err
+	}
+	prefix := s.kindPrefix(kind)
+	in := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	p := s3.NewListObjectsV2Paginator(s.S3srv.ListObjectsV2Request(in))
+	var names []string
+	for p.Next(context.Background()) {
+		for _, o := range p.CurrentPage().Contents {
+			name := strings.TrimPrefix(aws.StringValue(o.Key), prefix)
+			if name != "" && !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+	}
+	if err := p.Err(); err != nil {
+		return nil, fmt.Errorf("could not list objects: %v", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Delete removes the object stored under the kind and name provided.
+func (s S3) Delete(kind Kind, name string) error {
+	if err := validate(kind, name); err != nil {
+		return err
+	}
+	in := &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(kind, name)),
+	}
+	_, err := s.S3srv.DeleteObjectRequest(in).Send(context.Background())
+	if err != nil {
+		return fmt.Errorf("could not delete object %s/%s: %v", kind, name, err)
+	}
+	return nil
+}
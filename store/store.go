@@ -0,0 +1,60 @@
+// Package store persists CA material: CA certificates, encrypted private keys, issued certificates and CRLs.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotFound is returned when an object is not in the store.
+var ErrNotFound = errors.New("object not found in store")
+
+// Kind of object held in the store. Each kind is kept in its own namespace.
+type Kind string
+
+const (
+	KindCACert Kind = "ca"
+	KindKey    Kind = "keys"
+	KindCert   Kind = "certs"
+	KindCRL    Kind = "crls"
+)
+
+// Kinds lists the kinds of object a store holds.
+var Kinds = []Kind{KindCACert, KindKey, KindCert, KindCRL}
+
+// Store holds objects by kind and name.
+//
+// Objects are stored as provided. Private keys must be encrypted by the caller before they are put in a store.
+type Store interface {
+	// Put stores the data under the kind and name provided replacing any existing object.
+	Put(kind Kind, name string, data []byte) error
+	// Get returns the data stored under the kind and name provided.
+	Get(kind Kind, name string) ([]byte, error)
+	// List returns the names of the objects of the kind provided in lexical order.
+	List(kind Kind) ([]string, error)
+	// Delete removes the object stored under the kind and name provided. Deleting an object that does not exist is not an error.
+	Delete(kind Kind, name string) error
+}
+
+// validKind checks the kind is one the store holds.
+func validKind(kind Kind) error {
+	for _, k := range Kinds {
+		if k == kind {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown object kind: %s", kind)
+}
+
+// validate checks the kind is known and the name can be used as a file name or an object key component.
+// Names beginning with a dot are reserved for temporary files.
+func validate(kind Kind, name string) error {
+	if err := validKind(kind); err != nil {
+		return err
+	}
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid object name: %q", name)
+	}
+	return nil
+}
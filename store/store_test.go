@@ -0,0 +1,200 @@
+package store
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/stretchr/testify/assert"
+)
+
+const testBucket = "pki-test"
+
+// fakeS3 is an in-process implementation of the subset of the S3 REST API used by the S3 store.
+type fakeS3 struct {
+	mux     sync.Mutex
+	objects map[string][]byte
+	// pageSize limits the keys returned per list request to exercise pagination.
+	pageSize int
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	KeyCount              int
+	IsTruncated           bool
+	NextContinuationToken string `xml:",omitempty"`
+	Contents              []struct {
+		Key  string
+		Size int
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.HasPrefix(path, testBucket) {
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(path, testBucket), "/")
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r)
+	case r.Method == http.MethodPut:
+		b, _ := ioutil.ReadAll(r.Body)
+		f.objects[key] = b
+	case r.Method == http.MethodGet:
+		b, ok := f.objects[key]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Write(b)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	start, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+	res := listBucketResult{Name: testBucket, Prefix: prefix}
+	for i := start; i < len(keys); i++ {
+		if len(res.Contents) == f.pageSize {
+			res.IsTruncated = true
+			res.NextContinuationToken = strconv.Itoa(i)
+			break
+		}
+		res.Contents = append(res.Contents, struct {
+			Key  string
+			Size int
+		}{keys[i], len(f.objects[keys[i]])})
+	}
+	res.KeyCount = len(res.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(res)
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte("<Error><Code>" + code + "</Code><Message>" + code + "</Message></Error>"))
+}
+
+func testStore(t *testing.T, s Store) {
+	objects := map[Kind]map[string][]byte{
+		KindCACert: {"root": []byte("root CA certificate")},
+		KindKey:    {"root": []byte("encrypted root CA key")},
+		KindCert:   {"03": []byte("cert 3"), "01": []byte("cert 1"), "02": []byte("cert 2")},
+		KindCRL:    {"root": []byte("root CRL")},
+	}
+	for kind, objs := range objects {
+		for name, data := range objs {
+			err := s.Put(kind, name, data)
+			if err != nil {
+				t.Fatalf("error putting %s/%s: %v", kind, name, err)
+			}
+		}
+	}
+	for kind, objs := range objects {
+		for name, data := range objs {
+			b, err := s.Get(kind, name)
+			if err != nil {
+				t.Fatalf("error getting %s/%s: %v", kind, name, err)
+			}
+			assert.Equal(t, data, b, "data of %s/%s not as expected", kind, name)
+		}
+	}
+	names, err := s.List(KindCert)
+	if err != nil {
+		t.Fatalf("error listing: %v", err)
+	}
+	assert.Equal(t, []string{"01", "02", "03"}, names, "listed names not as expected")
+
+	err = s.Put(KindCert, "02", []byte("cert 2 replaced"))
+	if err != nil {
+		t.Fatalf("error replacing object: %v", err)
+	}
+	b, err := s.Get(KindCert, "02")
+	if err != nil {
+		t.Fatalf("error getting replaced object: %v", err)
+	}
+	assert.Equal(t, []byte("cert 2 replaced"), b, "replaced data not as expected")
+
+	err = s.Delete(KindCert, "02")
+	if err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+	assert.NoError(t, s.Delete(KindCert, "02"), "deleting a missing object should not error")
+	_, err = s.Get(KindCert, "02")
+	assert.True(t, errors.Is(err, ErrNotFound), "deleted object should not be found: %v", err)
+	names, err = s.List(KindCert)
+	if err != nil {
+		t.Fatalf("error listing: %v", err)
+	}
+	assert.Equal(t, []string{"01", "03"}, names, "listed names after delete not as expected")
+	names, err = s.List(KindCRL)
+	if err != nil {
+		t.Fatalf("error listing: %v", err)
+	}
+	assert.Equal(t, []string{"root"}, names, "listed CRL names not as expected")
+
+	assert.Error(t, s.Put(KindCert, "../escape", []byte("x")), "name with a path separator should be rejected")
+	assert.Error(t, s.Put(KindCert, ".hidden", []byte("x")), "name beginning with a dot should be rejected")
+	assert.Error(t, s.Put(Kind("other"), "name", []byte("x")), "unknown kind should be rejected")
+}
+
+func TestInterface(t *testing.T) {
+	assert.Implements(t, (*Store)(nil), File{}, "File does not implement the Store interface")
+	assert.Implements(t, (*Store)(nil), S3{}, "S3 does not implement the Store interface")
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	names, err := File{Dir: dir}.List(KindCert)
+	assert.NoError(t, err, "listing an empty store should not error")
+	assert.Empty(t, names, "empty store should have no names")
+	testStore(t, File{Dir: dir})
+}
+
+func TestS3(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte), pageSize: 2}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	cfg := defaults.Config()
+	cfg.Region = "eu-west-2"
+	cfg.Credentials = aws.NewStaticCredentialsProvider("AKID", "SECRET", "")
+	cfg.EndpointResolver = aws.ResolveWithEndpointURL(srv.URL)
+	s := newS3(cfg, true, testBucket, "/pki/")
+	testStore(t, s)
+	_, ok := fake.objects["pki/keys/root"]
+	assert.True(t, ok, "object key not under prefix")
+}
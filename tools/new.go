@@ -60,7 +60,11 @@ func main() {
 	if err != nil {
 		panic(err.Error())
 	}
-	err = ioutil.WriteFile(outputdir+"/"+hostname+".key", certificate.PEMEncodeRSAPrivateKey(key), 0600)
+	keyPEM, err := certificate.PEMEncodePrivateKey(key)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = ioutil.WriteFile(outputdir+"/"+hostname+".key", keyPEM, 0600)
 	if err != nil {
 		panic(err.Error())
 	}